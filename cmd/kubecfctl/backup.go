@@ -14,11 +14,13 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
+	"io/ioutil"
 	"os"
+	"time"
 
-	"github.com/kyokomi/emoji"
+	backup "github.com/mudler/kubecfctl/cmd/kubecfctl/backup"
 	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -37,44 +39,114 @@ To list the available deployments, run:
 Then to backup a component, simply run:
 
 	$ kubecfctl backup [COMPONENT]
+
+The location, set with --location, can be a local directory or an s3:// URL
+(requires the aws CLI). Older versions took it with --output, which now sets
+the output format.
+
+To run backups periodically inside the cluster, see:
+
+	$ kubecfctl backup schedule --help
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
 
 		viper.BindPFlag("version", cmd.Flags().Lookup("version"))
-		viper.BindPFlag("output", cmd.Flags().Lookup("output"))
-
+		viper.BindPFlag("location", cmd.Flags().Lookup("location"))
+		viper.BindPFlag("timestamp", cmd.Flags().Lookup("timestamp"))
+		viper.BindPFlag("debug", cmd.Flags().Lookup("debug"))
+		viper.BindPFlag("additional-namespace", cmd.Flags().Lookup("additional-namespace"))
+		viper.BindPFlag("include", cmd.Flags().Lookup("include"))
+		viper.BindPFlag("exclude", cmd.Flags().Lookup("exclude"))
+		viper.BindPFlag("consistent", cmd.Flags().Lookup("consistent"))
 	},
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		version := viper.GetString("version")
-		output := viper.GetString("output")
-
-		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if err := backupComponent(cmd, args); err != nil {
+			os.Exit(kubernetes.ReportError(err))
 		}
-		emoji.Println(cluster.GetPlatform().Describe())
-		inst := kubernetes.NewInstaller()
+	},
+}
+
+// backupComponent backs up the component in args to the location set with
+// the flags. It returns instead of exiting, so the temporary directory of
+// remote locations is removed
+func backupComponent(cmd *cobra.Command, args []string) error {
+	version := viper.GetString("version")
+	output := viper.GetString("location")
+	debug := viper.GetBool("debug")
+	additionalNamespaces := viper.GetStringSlice("additional-namespace")
+	include := viper.GetStringSlice("include")
+	exclude := viper.GetStringSlice("exclude")
+	consistent := viper.GetBool("consistent")
+
+	if viper.GetBool("timestamp") {
+		output = helpers.JoinLocation(output, args[0]+"-"+time.Now().UTC().Format("20060102150405"))
+	}
 
-		d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
-			Version: version,
-			Timeout: 1000,
-		})
+	dir := output
+	if helpers.IsRemote(output) {
+		tmp, err := ioutil.TempDir(os.TempDir(), "kubecfctl")
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
-		err = inst.Backup(d, *cluster, output)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	} else if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+	if err != nil {
+		return err
+	}
+	helpers.Info("", cluster.GetPlatform().Describe())
+	inst := newInstaller(cmd, args)
+
+	waitTimeouts, err := timeouts()
+	if err != nil {
+		return err
+	}
+
+	d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
+		Version:              version,
+		Timeouts:             waitTimeouts,
+		Debug:                debug,
+		AdditionalNamespaces: additionalNamespaces,
+		Include:              include,
+		Exclude:              exclude,
+		Consistent:           consistent,
+	})
+	if err != nil {
+		return err
+	}
+	err = inst.Backup(cmd.Context(), d, *cluster, dir)
+	if err != nil {
+		return err
+	}
+
+	if helpers.IsRemote(output) {
+		if err := helpers.Upload(cmd.Context(), dir, output, debug); err != nil {
+			return err
 		}
-	},
+	}
+	helpers.Info("", ":floppy_disk: Backup stored in "+output)
+	return nil
 }
 
 func init() {
-	backupCmd.Flags().String("output", "", "backup output directory")
+	backupCmd.Flags().String("location", "", "backup output directory or s3:// URL")
 	backupCmd.Flags().String("version", "", "Component version")
+	backupCmd.Flags().StringSlice("additional-namespace", []string{}, "Additional namespaces to backup (optional, detected if not specified)")
+	backupCmd.Flags().Bool("timestamp", false, "Store the backup in a timestamped subdirectory of the location")
+
+	backupCmd.AddCommand(backup.ScheduleCmd)
+
+	backupCmd.Flags().StringSlice("include", []string{}, "Data sets to backup (uaa, ccdb, blobstore, credhub, config, secrets), defaults to all")
+	backupCmd.Flags().StringSlice("exclude", []string{}, "Data sets to skip")
+	backupCmd.Flags().Bool("consistent", false, "Stop the Cloud Controller during the backup, so databases and blobstore are consistent")
+
+	backupCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
 
 	RootCmd.AddCommand(backupCmd)
 }
@@ -0,0 +1,51 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"os"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var scheduleDeleteCmd = &cobra.Command{
+	Use:   "delete [NAME]",
+	Short: "deletes a scheduled backup",
+	Long:  `This command deletes a scheduled backup and the jobs it has created`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("namespace", cmd.Flags().Lookup("namespace"))
+	},
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		audit := kubernetes.NewInstaller().Audit(cmd.Context(), *cluster, "backup schedule delete", nil)
+		err = cluster.DeleteBackupSchedule(cmd.Context(), viper.GetString("namespace"), args[0])
+		audit(err)
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Success("", ":heavy_check_mark: Backup schedule "+args[0]+" deleted")
+	},
+}
+
+func init() {
+	scheduleDeleteCmd.Flags().String("namespace", "kubecfctl", "Namespace of the schedule")
+}
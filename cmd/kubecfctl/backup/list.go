@@ -0,0 +1,63 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var scheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "lists scheduled backups",
+	Long:  `This command lists the scheduled backups and the result of their last run`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("namespace", cmd.Flags().Lookup("namespace"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		updateSchedules(cmd.Context(), cluster, viper.GetString("namespace"))
+
+		schedules, err := cluster.ListBackupSchedules(cmd.Context(), viper.GetString("namespace"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Name", "Component", "Schedule", "Output", "Last run", "Result"})
+		for _, s := range schedules {
+			last := "-"
+			if s.LastSchedule != nil {
+				last = s.LastSchedule.Format(time.RFC3339)
+			}
+			t.AppendRow(table.Row{s.Name, s.Component, s.Cron, s.Output, last, s.LastResult})
+		}
+		t.SetStyle(table.StyleColoredBright)
+		t.Render()
+	},
+}
+
+func init() {
+	scheduleListCmd.Flags().String("namespace", "kubecfctl", "Namespace of the schedules")
+}
@@ -0,0 +1,133 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"os"
+
+	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ScheduleCmd = &cobra.Command{
+	Use:   "schedule <options> [COMPONENT]",
+	Short: "schedules periodic backups of a deployment",
+	Long: `This command creates a CronJob which periodically backups a deployment
+from inside the cluster.
+
+For example, to backup KubeCF every night at 2am to an S3 bucket:
+
+	$ kubecfctl backup schedule kubecf --cron "0 2 * * *" --location s3://bucket/kubecf --image <image>
+
+The image must contain kubecfctl and, for s3:// locations, the aws CLI.
+Object storage credentials can be passed to the job with --secret, which
+exposes the given secret keys as environment variables.
+
+To list and delete schedules, run:
+
+	$ kubecfctl backup schedule list
+	$ kubecfctl backup schedule delete [NAME]
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("cron", cmd.Flags().Lookup("cron"))
+		viper.BindPFlag("location", cmd.Flags().Lookup("location"))
+		viper.BindPFlag("image", cmd.Flags().Lookup("image"))
+		viper.BindPFlag("secret", cmd.Flags().Lookup("secret"))
+		viper.BindPFlag("name", cmd.Flags().Lookup("name"))
+		viper.BindPFlag("namespace", cmd.Flags().Lookup("namespace"))
+		viper.BindPFlag("version", cmd.Flags().Lookup("version"))
+	},
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cron := viper.GetString("cron")
+		output := viper.GetString("location")
+		image := viper.GetString("image")
+		version := viper.GetString("version")
+		name := viper.GetString("name")
+
+		if cron == "" || output == "" || image == "" {
+			os.Exit(kubernetes.ReportError(kubernetes.UsageError{Err: errors.New("--cron, --location and --image are required")}))
+		}
+
+		// Fail early on components we don't know about
+		if _, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{Version: version}); err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		if name == "" {
+			name = args[0] + "-backup"
+		}
+
+		backupArgs := []string{"backup", args[0], "--location", output, "--timestamp"}
+		if version != "" {
+			backupArgs = append(backupArgs, "--version", version)
+		}
+
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		updateSchedules(cmd.Context(), cluster, viper.GetString("namespace"))
+
+		inst := kubernetes.NewInstaller()
+		inst.Component = args[0]
+		audit := inst.Audit(cmd.Context(), *cluster, "backup schedule", nil)
+		err = cluster.CreateBackupSchedule(cmd.Context(), kubernetes.BackupSchedule{
+			Name:      name,
+			Namespace: viper.GetString("namespace"),
+			Component: args[0],
+			Cron:      cron,
+			Output:    output,
+			Image:     image,
+			Secret:    viper.GetString("secret"),
+			Args:      backupArgs,
+		})
+		audit(err)
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", ":alarm_clock: Backup of "+args[0]+" scheduled as "+name+" ("+cron+")")
+	},
+}
+
+// updateSchedules rewrites the backup commands of the schedules created by
+// older versions, which use flags renamed since then
+func updateSchedules(ctx context.Context, cluster *kubernetes.Cluster, namespace string) {
+	updated, err := cluster.UpdateBackupSchedules(ctx, namespace)
+	if err != nil {
+		helpers.Warning("", ":warning: Failed updating the schedules created by older versions: "+err.Error())
+	}
+	for _, name := range updated {
+		helpers.Info("", ":arrows_counterclockwise: Schedule "+name+" updated to the current backup flags")
+	}
+}
+
+func init() {
+	ScheduleCmd.Flags().String("cron", "", "Schedule in cron format, e.g. \"0 2 * * *\"")
+	ScheduleCmd.Flags().String("location", "", "Backup destination (s3:// URL)")
+	ScheduleCmd.Flags().String("image", "", "Image containing kubecfctl, used to run the backup")
+	ScheduleCmd.Flags().String("secret", "", "Secret exposed as environment to the backup job (optional)")
+	ScheduleCmd.Flags().String("name", "", "Schedule name (defaults to <COMPONENT>-backup)")
+	ScheduleCmd.Flags().String("namespace", "kubecfctl", "Namespace where the schedule is created")
+	ScheduleCmd.Flags().String("version", "", "Component version")
+
+	ScheduleCmd.AddCommand(scheduleListCmd)
+	ScheduleCmd.AddCommand(scheduleDeleteCmd)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "removes resources left behind by past installs",
+	Long: `This command looks for resources left behind by failed or partial installs
+of KubeCF and of the Quarks operator, and removes them once confirmed.
+
+The resources of KubeCF are only considered orphaned if no kubecf release is
+installed, and the ones of Quarks if no cf-operator release is. They are
+found by the helm release annotations and by their known names:
+
+	- the kubecf-default and eirini-* pod security policies
+	- the eirini-cluster-role* cluster roles and bindings
+	- the quarks CRDs and the cf-operator webhooks
+	- the <namespace>cfo<xxxxx> service accounts and role bindings created in
+	  the additional namespaces
+
+To remove them without asking:
+
+	$ kubecfctl cleanup --yes
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("yes", cmd.Flags().Lookup("yes"))
+	},
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", cluster.GetPlatform().Describe())
+
+		orphans, err := deployments.Orphans(cmd.Context(), *cluster)
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		if len(orphans) == 0 {
+			helpers.Success("", ":heavy_check_mark: No orphaned resources found")
+			return
+		}
+
+		helpers.Info("", ":broom: Orphaned resources:")
+		for _, o := range orphans {
+			helpers.Info("", "   "+o.String())
+		}
+		if !viper.GetBool("yes") {
+			remove, err := confirm("Remove them?", "yes")
+			if err != nil {
+				os.Exit(kubernetes.ReportError(err))
+			}
+			if !remove {
+				return
+			}
+		}
+		audit := newInstaller(cmd, args).Audit(cmd.Context(), *cluster, "cleanup", nil)
+		err = cluster.DeleteResources(cmd.Context(), orphans)
+		audit(err)
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Success("", ":heavy_check_mark: Orphaned resources removed")
+	},
+}
+
+func init() {
+	cleanupCmd.Flags().Bool("yes", false, "Remove the orphaned resources without asking")
+
+	RootCmd.AddCommand(cleanupCmd)
+}
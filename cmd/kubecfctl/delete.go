@@ -14,11 +14,10 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -37,8 +36,13 @@ To list the available deployments, run:
 Then to delete a component, simply run:
 
 	$ kubecfctl delete [COMPONENT]
+
+Delete waits for the namespaces of the component to be gone, then looks for
+cluster wide resources left behind (pod security policies, cluster roles and
+bindings, CRDs, webhooks and released volumes) and offers to remove them.
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
 		viper.BindPFlag("eirini", cmd.Flags().Lookup("eirini"))
 		viper.BindPFlag("ingress", cmd.Flags().Lookup("ingress"))
 		viper.BindPFlag("debug", cmd.Flags().Lookup("debug"))
@@ -46,6 +50,7 @@ Then to delete a component, simply run:
 		viper.BindPFlag("chart", cmd.Flags().Lookup("chart"))
 		viper.BindPFlag("quarks-chart", cmd.Flags().Lookup("quarks-chart"))
 		viper.BindPFlag("additional-namespace", cmd.Flags().Lookup("additional-namespace"))
+		viper.BindPFlag("remove-leftovers", cmd.Flags().Lookup("remove-leftovers"))
 
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -59,16 +64,20 @@ Then to delete a component, simply run:
 
 		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", cluster.GetPlatform().Describe())
+		inst := newInstaller(cmd, args)
+
+		waitTimeouts, err := timeouts()
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
 		}
-		emoji.Println(cluster.GetPlatform().Describe())
-		inst := kubernetes.NewInstaller()
 
 		d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
 			Version:              version,
 			Eirini:               eirini,
-			Timeout:              1000,
+			Timeouts:             waitTimeouts,
 			Ingress:              ingress,
 			Debug:                debug,
 			ChartURL:             chartURL,
@@ -76,13 +85,37 @@ Then to delete a component, simply run:
 			AdditionalNamespaces: additionalNamespaces,
 		})
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
+		}
+		deleteErr := inst.Delete(cmd.Context(), d, *cluster)
+		if deleteErr != nil {
+			kubernetes.ReportError(deleteErr)
 		}
-		err = inst.Delete(d, *cluster)
+
+		leftovers, err := inst.Leftovers(cmd.Context(), d, *cluster)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
+		}
+		if len(leftovers) != 0 {
+			helpers.Info("", ":broom: Resources left behind by "+args[0]+":")
+			for _, l := range leftovers {
+				helpers.Info("", "   "+l.String())
+			}
+			remove := viper.GetBool("remove-leftovers")
+			if !remove {
+				if remove, err = confirm("Remove them?", "remove-leftovers"); err != nil {
+					os.Exit(kubernetes.ReportError(err))
+				}
+			}
+			if remove {
+				if err := cluster.DeleteResources(cmd.Context(), leftovers); err != nil {
+					os.Exit(kubernetes.ReportError(err))
+				}
+				helpers.Success("", ":heavy_check_mark: Leftovers removed")
+			}
+		}
+		if deleteErr != nil {
+			os.Exit(kubernetes.ExitCode(deleteErr))
 		}
 	},
 }
@@ -95,5 +128,8 @@ func init() {
 	deleteCmd.Flags().String("version", "", "Component version to deploy")
 	deleteCmd.Flags().StringSlice("additional-namespace", []string{}, "Additional namespaces to watch for (optional, required only by Quarks) ")
 
+	deleteCmd.Flags().Bool("remove-leftovers", false, "Remove the resources left behind by the component without asking")
+	deleteCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
+
 	RootCmd.AddCommand(deleteCmd)
 }
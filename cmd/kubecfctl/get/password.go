@@ -29,21 +29,20 @@ var PasswordCmd = &cobra.Command{
 	Short:   "kubecf-password admin password",
 	Aliases: []string{"pw"},
 	Long:    `Retrieve CF admin password from KubeCF deployment`,
+	Args:    cobra.ExactArgs(1),
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
 		}
 
 		kubecf, err := deployments.GlobalCatalog.GetKubeCF(args[0])
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
 		}
 
-		pwd, err := kubecf.GetPassword(kubecf.Namespace, *cluster)
+		pwd, err := kubecf.GetPassword(cmd.Context(), kubecf.Namespace, *cluster)
 		if err != nil {
 			return errors.Wrap(err, "couldn't find password secret")
 		}
@@ -0,0 +1,115 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history [COMPONENT]",
+	Short: "shows the operations run on the cluster",
+	Long: `This command shows who installed, upgraded, deleted, backed up or
+restored the components of the cluster, and when.
+
+Each command changing the cluster records what it did in a ConfigMap of the
+kubecfctl namespace: the local user and host, the user of the kubeconfig
+context, the command line with the secrets masked, the versions installed
+before and after, the duration and the result.
+
+To show the history of KubeCF only:
+
+	$ kubecfctl history kubecf
+
+With --output json, the records are printed one per line as JSON objects.
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("limit", cmd.Flags().Lookup("limit"))
+	},
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		component := ""
+		if len(args) != 0 {
+			component = args[0]
+		}
+		records, err := cluster.AuditHistory(cmd.Context(), component)
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		if limit := viper.GetInt("limit"); limit > 0 && len(records) > limit {
+			records = records[len(records)-limit:]
+		}
+
+		if viper.GetString("output") == helpers.OutputJSON {
+			printHistoryJSON(os.Stdout, records)
+			return
+		}
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Started", "Operation", "Component", "User", "Kube user", "Version", "Duration", "Result", "Command"})
+		for _, r := range records {
+			version := r.VersionAfter
+			if r.VersionBefore != r.VersionAfter {
+				version = orNone(r.VersionBefore) + " -> " + orNone(r.VersionAfter)
+			}
+			result := r.Result
+			if r.Error != "" {
+				result += " (" + r.Error + ")"
+			}
+			t.AppendRow(table.Row{r.Started.Format(time.RFC3339), r.Operation, r.Component, r.User + "@" + r.Host, r.KubeUser, version, r.Duration, result, r.Command})
+		}
+		t.SetStyle(table.StyleColoredBright)
+		t.Render()
+	},
+}
+
+// printHistoryJSON prints a record per line, as JSON objects like the events
+// of the other commands
+func printHistoryJSON(w io.Writer, records []kubernetes.AuditRecord) {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		enc.Encode(struct {
+			kubernetes.AuditRecord
+			Duration string `json:"duration"`
+		}{r, r.Duration.String()})
+	}
+}
+
+func orNone(version string) string {
+	if version == "" {
+		return "none"
+	}
+	return version
+}
+
+func init() {
+	historyCmd.Flags().Int("limit", 0, "Show the latest records only, up to this number")
+
+	RootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+	It("prints a JSON object per record", func() {
+		var out bytes.Buffer
+		printHistoryJSON(&out, []kubernetes.AuditRecord{
+			{Operation: "install", Component: "kubecf", KubeUser: "admin", VersionAfter: "2.6.1", Duration: 90 * time.Second, Result: kubernetes.AuditSuccess},
+			{Operation: "delete", Component: "kubecf", Result: kubernetes.AuditFailure, Error: "timed out", Class: kubernetes.ClassTimeout},
+		})
+
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+		var record map[string]interface{}
+		Expect(json.Unmarshal(lines[0], &record)).To(Succeed())
+		Expect(record).To(HaveKeyWithValue("operation", "install"))
+		Expect(record).To(HaveKeyWithValue("kube_user", "admin"))
+		Expect(record).To(HaveKeyWithValue("version_after", "2.6.1"))
+		Expect(record).To(HaveKeyWithValue("duration", "1m30s"))
+		Expect(record).ToNot(HaveKey("error"))
+		Expect(json.Unmarshal(lines[1], &record)).To(Succeed())
+		Expect(record).To(HaveKeyWithValue("class", "timeout"))
+	})
+})
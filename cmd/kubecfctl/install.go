@@ -14,11 +14,10 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -38,8 +37,13 @@ Then to install a component, simply run:
 
 	$ kubecfctl install [COMPONENT]
 
+KubeCF is installed in phases, and the completed ones are recorded in the
+cluster. To continue an interrupted install from its first incomplete phase:
+
+	$ kubecfctl install [COMPONENT] --resume
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
 		viper.BindPFlag("eirini", cmd.Flags().Lookup("eirini"))
 		viper.BindPFlag("rollback", cmd.Flags().Lookup("rollback"))
 		viper.BindPFlag("ingress", cmd.Flags().Lookup("ingress"))
@@ -52,6 +56,7 @@ Then to install a component, simply run:
 
 		viper.BindPFlag("registry-password", cmd.Flags().Lookup("registry-password"))
 		viper.BindPFlag("additional-namespace", cmd.Flags().Lookup("additional-namespace"))
+		viper.BindPFlag("resume", cmd.Flags().Lookup("resume"))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		eirini := viper.GetBool("eirini")
@@ -67,16 +72,21 @@ Then to install a component, simply run:
 		additionalNamespaces := viper.GetStringSlice("additional-namespace")
 		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", cluster.GetPlatform().Describe())
+		inst := newInstaller(cmd, args)
+		inst.Resume = viper.GetBool("resume")
+
+		waitTimeouts, err := timeouts()
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
 		}
-		fmt.Println(cluster.GetPlatform().Describe())
-		inst := kubernetes.NewInstaller()
 
 		d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
 			Version:              version,
 			Eirini:               eirini,
-			Timeout:              1000,
+			Timeouts:             waitTimeouts,
 			Ingress:              ingress,
 			Debug:                debug,
 			ChartURL:             chartURL,
@@ -87,21 +97,20 @@ Then to install a component, simply run:
 			AdditionalNamespaces: additionalNamespaces,
 		})
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
 		}
 
-		err = inst.Install(d, *cluster)
+		err = inst.Install(cmd.Context(), d, *cluster)
 		if err != nil {
-			fmt.Println(err)
+			code := kubernetes.ReportError(err)
 			if rollback {
-				emoji.Println(":x: Deployment failed, deleting deployment")
-				err = inst.Delete(d, *cluster)
+				helpers.Warning("", ":x: Deployment failed, deleting deployment")
+				err = inst.Delete(cmd.Context(), d, *cluster)
 				if err != nil {
-					fmt.Println(err)
+					kubernetes.ReportError(err)
 				}
 			}
-			os.Exit(1)
+			os.Exit(code)
 		}
 	},
 }
@@ -117,6 +126,9 @@ func init() {
 	installCmd.Flags().String("registry-password", "", "Registry password (optional, required only by Carrier) ")
 	installCmd.Flags().StringSlice("additional-namespace", []string{}, "Additional namespaces to watch for (optional, required only by Quarks) ")
 	installCmd.Flags().String("storage-class", "", "Storage class to be used")
+	installCmd.Flags().Bool("resume", false, "Resume an interrupted install from its first incomplete phase")
+
+	installCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
 
 	RootCmd.AddCommand(installCmd)
 }
@@ -0,0 +1,136 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [FROM] [TO]",
+	Short: "migrates a foundation to a different component",
+	Long: `This command migrates a Cloud Foundry foundation to a different deployment.
+
+Currently only SCF to KubeCF is supported:
+
+	$ kubecfctl migrate scf kubecf
+
+SCF is backed up, KubeCF is deployed with the same settings, system domain and
+CCDB encryption keys, and the UAA, CCDB and blobstore data is loaded into it.
+A report lists what couldn't be carried over. SCF is left running unless
+--delete-scf is given, which is needed when KubeCF has to take over its
+addresses.
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
+		viper.BindPFlag("backup-dir", cmd.Flags().Lookup("backup-dir"))
+		viper.BindPFlag("version", cmd.Flags().Lookup("version"))
+		viper.BindPFlag("from-version", cmd.Flags().Lookup("from-version"))
+		viper.BindPFlag("eirini", cmd.Flags().Lookup("eirini"))
+		viper.BindPFlag("ingress", cmd.Flags().Lookup("ingress"))
+		viper.BindPFlag("debug", cmd.Flags().Lookup("debug"))
+		viper.BindPFlag("chart", cmd.Flags().Lookup("chart"))
+		viper.BindPFlag("quarks-chart", cmd.Flags().Lookup("quarks-chart"))
+		viper.BindPFlag("storage-class", cmd.Flags().Lookup("storage-class"))
+		viper.BindPFlag("domain", cmd.Flags().Lookup("domain"))
+		viper.BindPFlag("delete-scf", cmd.Flags().Lookup("delete-scf"))
+	},
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		debug := viper.GetBool("debug")
+		output := viper.GetString("backup-dir")
+		if output == "" {
+			currentdir, _ := os.Getwd()
+			output = filepath.Join(currentdir, "kubecfctl-migrate-"+time.Now().UTC().Format("20060102150405"))
+		}
+
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", cluster.GetPlatform().Describe())
+
+		waitTimeouts, err := timeouts()
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		from, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
+			Version:  viper.GetString("from-version"),
+			Timeouts: waitTimeouts,
+			Debug:    debug,
+		})
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		to, err := deployments.GlobalCatalog.Deployment(args[1], deployments.DeploymentOptions{
+			Version:      viper.GetString("version"),
+			Eirini:       viper.GetBool("eirini"),
+			Timeouts:     waitTimeouts,
+			Ingress:      viper.GetBool("ingress"),
+			Debug:        debug,
+			ChartURL:     viper.GetString("chart"),
+			QuarksURL:    viper.GetString("quarks-chart"),
+			StorageClass: viper.GetString("storage-class"),
+		})
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		if domain := viper.GetString("domain"); domain != "" {
+			to.SetDomain(domain)
+		}
+
+		// The migration is recorded in the history of the component migrated to
+		inst := newInstaller(cmd, args)
+		inst.Component = args[1]
+		audit := inst.Audit(cmd.Context(), *cluster, "migrate", to)
+		unlock, err := inst.Lock(cmd.Context(), *cluster, "migrate", from.GetNamespace(), to.GetNamespace())
+		if err != nil {
+			audit(err)
+			os.Exit(kubernetes.ReportError(err))
+		}
+		report, err := deployments.Migrate(cmd.Context(), *cluster, from, to, output, viper.GetBool("delete-scf"))
+		unlock()
+		audit(err)
+		report.Print()
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+	},
+}
+
+func init() {
+	migrateCmd.Flags().String("backup-dir", "", "Directory where the source is backed up (defaults to a new directory in the current one)")
+	migrateCmd.Flags().String("version", "", "Version of the component to migrate to")
+	migrateCmd.Flags().String("from-version", "", "Version of the component to migrate from")
+	migrateCmd.Flags().Bool("eirini", false, "Enable Eirini (defaults to the source setting)")
+	migrateCmd.Flags().Bool("ingress", false, "Enable ingress (defaults to the source setting)")
+	migrateCmd.Flags().String("chart", "", "Chart URL (tgz)")
+	migrateCmd.Flags().String("quarks-chart", "", "Quarks Chart URL (tgz)")
+	migrateCmd.Flags().String("storage-class", "", "Storage class to be used (defaults to the source one)")
+	migrateCmd.Flags().String("domain", "", "System domain of the new deployment (defaults to the source one)")
+	migrateCmd.Flags().Bool("delete-scf", false, "Delete SCF once backed up, so KubeCF can take over its addresses")
+
+	migrateCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
+
+	RootCmd.AddCommand(migrateCmd)
+}
@@ -14,11 +14,11 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
+	"io/ioutil"
 	"os"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -37,11 +37,25 @@ To list the available deployments, run:
 Then to restore a component, simply run:
 
 	$ kubecfctl restore [COMPONENT]
+
+All the namespaces found in the backup are restored, to restore only some of them:
+
+	$ kubecfctl restore [COMPONENT] --namespace kubecf --namespace tenant1
+
+To migrate a deployment to a different system domain or namespace, e.g. on a
+new cluster (domain references in the CCDB and UAA are rewritten):
+
+	$ kubecfctl restore [COMPONENT] --domain new.example.com --namespace kubecf=newns
+
+To roll back the data of a running deployment without redeploying it:
+
+	$ kubecfctl restore [COMPONENT] --in-place
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
 
 		viper.BindPFlag("version", cmd.Flags().Lookup("version"))
-		viper.BindPFlag("output", cmd.Flags().Lookup("output"))
+		viper.BindPFlag("location", cmd.Flags().Lookup("location"))
 
 		viper.BindPFlag("eirini", cmd.Flags().Lookup("eirini"))
 		viper.BindPFlag("rollback", cmd.Flags().Lookup("rollback"))
@@ -54,56 +68,94 @@ Then to restore a component, simply run:
 
 		viper.BindPFlag("registry-password", cmd.Flags().Lookup("registry-password"))
 		viper.BindPFlag("additional-namespace", cmd.Flags().Lookup("additional-namespace"))
-
+		viper.BindPFlag("include", cmd.Flags().Lookup("include"))
+		viper.BindPFlag("exclude", cmd.Flags().Lookup("exclude"))
+		viper.BindPFlag("in-place", cmd.Flags().Lookup("in-place"))
+		viper.BindPFlag("safety-backup", cmd.Flags().Lookup("safety-backup"))
+		viper.BindPFlag("domain", cmd.Flags().Lookup("domain"))
+		viper.BindPFlag("namespace", cmd.Flags().Lookup("namespace"))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		eirini := viper.GetBool("eirini")
-		ingress := viper.GetBool("ingress")
-		debug := viper.GetBool("debug")
-		version := viper.GetString("version")
-		chartURL := viper.GetString("chart")
-		storageClass := viper.GetString("storage-class")
-		quarksChart := viper.GetString("quarks-chart")
-		registryUserame := viper.GetString("registry-username")
-		registryPassword := viper.GetString("registry-password")
-		additionalNamespaces := viper.GetStringSlice("additional-namespace")
-		output := viper.GetString("output")
-
-		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if err := restoreComponent(cmd, args); err != nil {
+			os.Exit(kubernetes.ReportError(err))
 		}
-		emoji.Println(cluster.GetPlatform().Describe())
-		inst := kubernetes.NewInstaller()
-
-		d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
-			Version:              version,
-			Eirini:               eirini,
-			Timeout:              1000,
-			Ingress:              ingress,
-			Debug:                debug,
-			ChartURL:             chartURL,
-			QuarksURL:            quarksChart,
-			RegistryUsername:     registryUserame,
-			StorageClass:         storageClass,
-			RegistryPassword:     registryPassword,
-			AdditionalNamespaces: additionalNamespaces,
-		})
+	},
+}
+
+// restoreComponent restores the component in args from the location set
+// with the flags. It returns instead of exiting, so the temporary directory
+// of remote locations is removed
+func restoreComponent(cmd *cobra.Command, args []string) error {
+	eirini := viper.GetBool("eirini")
+	ingress := viper.GetBool("ingress")
+	debug := viper.GetBool("debug")
+	version := viper.GetString("version")
+	chartURL := viper.GetString("chart")
+	storageClass := viper.GetString("storage-class")
+	quarksChart := viper.GetString("quarks-chart")
+	registryUserame := viper.GetString("registry-username")
+	registryPassword := viper.GetString("registry-password")
+	additionalNamespaces := viper.GetStringSlice("additional-namespace")
+	include := viper.GetStringSlice("include")
+	exclude := viper.GetStringSlice("exclude")
+	inPlace := viper.GetBool("in-place")
+	safetyBackup := viper.GetString("safety-backup")
+	output := viper.GetString("location")
+	namespaces := viper.GetStringSlice("namespace")
+
+	if helpers.IsRemote(output) {
+		dir, err := ioutil.TempDir(os.TempDir(), "kubecfctl")
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
-		err = inst.Restore(d, *cluster, output)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		defer os.RemoveAll(dir)
+		if err := helpers.Download(cmd.Context(), output, dir, debug); err != nil {
+			return err
 		}
-	},
+		output = dir
+	}
+
+	cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+	if err != nil {
+		return err
+	}
+	helpers.Info("", cluster.GetPlatform().Describe())
+	inst := newInstaller(cmd, args)
+
+	waitTimeouts, err := timeouts()
+	if err != nil {
+		return err
+	}
+
+	d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
+		Version:              version,
+		Eirini:               eirini,
+		Timeouts:             waitTimeouts,
+		Ingress:              ingress,
+		Debug:                debug,
+		ChartURL:             chartURL,
+		QuarksURL:            quarksChart,
+		RegistryUsername:     registryUserame,
+		StorageClass:         storageClass,
+		RegistryPassword:     registryPassword,
+		AdditionalNamespaces: additionalNamespaces,
+		Include:              include,
+		Exclude:              exclude,
+		InPlace:              inPlace,
+		SafetyBackup:         safetyBackup,
+		RestoreNamespaces:    namespaces,
+	})
+	if err != nil {
+		return err
+	}
+	if domain := viper.GetString("domain"); domain != "" {
+		d.SetDomain(domain)
+	}
+	return inst.Restore(cmd.Context(), d, *cluster, output)
 }
 
 func init() {
-	restoreCmd.Flags().String("output", "", "restore output directory")
+	restoreCmd.Flags().String("location", "", "restore output directory or s3:// URL")
 	restoreCmd.Flags().String("version", "", "Component version")
 	restoreCmd.Flags().Bool("eirini", false, "Enable/Disable Eirini")
 	restoreCmd.Flags().Bool("rollback", false, "Automatically rollback a failed deployment")
@@ -114,6 +166,15 @@ func init() {
 	restoreCmd.Flags().String("registry-password", "", "Registry password (optional, required only by Carrier) ")
 	restoreCmd.Flags().StringSlice("additional-namespace", []string{}, "Additional namespaces to watch for (optional, required only by Quarks) ")
 	restoreCmd.Flags().String("storage-class", "", "Storage class to be used")
+	restoreCmd.Flags().StringSlice("namespace", []string{}, "Namespaces to restore from the backup, as name or old=new to rename them (optional, defaults to all)")
+	restoreCmd.Flags().String("domain", "", "System domain of the restored deployment (optional, defaults to the one in the backup)")
+
+	restoreCmd.Flags().StringSlice("include", []string{}, "Data sets to restore (uaa, ccdb, blobstore, credhub, config, secrets), defaults to all")
+	restoreCmd.Flags().StringSlice("exclude", []string{}, "Data sets to skip")
+	restoreCmd.Flags().Bool("in-place", false, "Restore into the running deployment instead of redeploying it")
+	restoreCmd.Flags().String("safety-backup", "", "Where to backup the running deployment before an in-place restore (defaults to a new directory in the current one)")
+
+	restoreCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
 
 	RootCmd.AddCommand(restoreCmd)
 }
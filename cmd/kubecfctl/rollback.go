@@ -0,0 +1,87 @@
+/*
+Copyright Ettore Di Giacinto <mudler@gentoo.org>.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [COMPONENT]",
+	Short: "rolls back a component to a previous helm revision",
+	Long: `This command rolls back the helm releases of a component, and waits for it to
+be ready again.
+
+To roll back to the revision before the current one, run:
+
+	$ kubecfctl rollback kubecf
+
+The history of the releases is shown, to roll back to a given revision:
+
+	$ kubecfctl rollback kubecf --revision 3
+
+For kubecf, the revision is the one of the release in the primary namespace.
+The tenant namespaces and the Quarks operator are rolled back to the revisions
+they had at that time.
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
+		viper.BindPFlag("debug", cmd.Flags().Lookup("debug"))
+		viper.BindPFlag("revision", cmd.Flags().Lookup("revision"))
+		viper.BindPFlag("additional-namespace", cmd.Flags().Lookup("additional-namespace"))
+	},
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", cluster.GetPlatform().Describe())
+		inst := newInstaller(cmd, args)
+
+		waitTimeouts, err := timeouts()
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
+			Timeouts:             waitTimeouts,
+			Debug:                viper.GetBool("debug"),
+			AdditionalNamespaces: viper.GetStringSlice("additional-namespace"),
+		})
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+
+		err = inst.Rollback(cmd.Context(), d, *cluster, viper.GetInt("revision"))
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
+		}
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().Int("revision", 0, "Revision to roll back to (defaults to the previous one)")
+	rollbackCmd.Flags().StringSlice("additional-namespace", []string{}, "Tenant namespaces to roll back (optional, detected by default)")
+
+	rollbackCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
+
+	RootCmd.AddCommand(rollbackCmd)
+}
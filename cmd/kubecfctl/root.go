@@ -14,10 +14,21 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/mattn/go-isatty"
+	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -60,27 +71,214 @@ To delete a deployment
 
 	$ kubecfctl delete kubecf
 
+Commands changing a component lock its namespace, so they can't run
+concurrently against the same cluster. If a run was killed and left its lock
+behind, use --force-unlock.
+
+They are also recorded in the cluster, with who ran them and how they went:
+
+	$ kubecfctl history kubecf
+
+Helm commands and cluster calls failing because of transient errors, like an
+API server restart, are retried (see --retries and --retry-backoff). Invalid
+chart values and other permanent errors are not.
+
+Waits, e.g. for the pods of a component to be running, last at most --timeout.
+Each phase can have its own timeout, as a Go duration:
+
+	$ kubecfctl install kubecf --timeout 30m --phase-timeout quarks=5m,kubecf=1h
+
+The phases are quarks, ingress, kubecf, scf, stratos, quiesce and delete. The
+flags can also be set in a YAML config file, .kubecfctl.yaml in the current
+directory unless --config is given, e.g.:
+
+	timeout: 30m
+	phase-timeout:
+	  quarks: 5m
+	  kubecf: 1h
+
+The progress is printed with emoji and spinners on a terminal, and as plain
+text otherwise. For CI, --output json prints an event per line instead:
+
+	$ kubecfctl install kubecf --output json
+	{"phase":"kubecf","event":"waiting","message":"...","pods_ready":12,"pods_total":40}
+
+Questions, like the confirmations of cleanup and delete, are asked on stderr.
+Without a terminal, --output json needs them answered with flags instead, e.g.
+cleanup --yes.
+
+Backups and restores take their destination with --location.
+
+Failing commands exit with a code telling why, which is also the exit_code of
+the final "error" event of --output json, along with its class:
+
+	1  any other error
+	2  usage: an invalid command line, like an unknown component or version
+	3  connection: the cluster can't be reached
+	4  preflight: a check failed before changing the cluster, like a held lock
+	5  helm: a helm command failed
+	6  timeout: a wait timed out
+	7  partial: some steps failed, e.g. a delete left resources behind
+
 Each action has its own help, so to show all the available 'install' options, just run:
 
 	$ kubecfctl install --help
 `,
 	Version:       fmt.Sprintf("%s-g%s %s", Version, BuildCommit, BuildTime),
 	SilenceErrors: true,
+	// Validating the arguments of the root command, instead of leaving it to
+	// cobra, makes the unknown commands usage errors too
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return fmt.Errorf("unknown command %q for %q", args[0], cmd.CommandPath())
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+	if err := execute(interruptContext()); err != nil {
+		os.Exit(kubernetes.ReportError(err))
+	}
+}
+
+var usageErrorsOnce sync.Once
+
+// execute runs the root command. The errors of the command line, like
+// unknown flags or missing arguments, are returned as UsageErrors, the
+// other ones as the commands returned them
+func execute(ctx context.Context) error {
+	usageErrorsOnce.Do(func() {
+		RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+			return kubernetes.UsageError{Err: err}
+		})
+		usageArgs(RootCmd)
+	})
+	return RootCmd.ExecuteContext(ctx)
+}
+
+// usageArgs makes the argument validators of cmd and of its subcommands
+// return UsageErrors
+func usageArgs(cmd *cobra.Command) {
+	if validate := cmd.Args; validate != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validate(cmd, args); err != nil {
+				return kubernetes.UsageError{Err: err}
+			}
+			return nil
+		}
+	}
+	for _, c := range cmd.Commands() {
+		usageArgs(c)
+	}
+}
+
+// interruptContext returns a context cancelled on SIGINT or SIGTERM, which
+// stops the running operation and the processes it spawned. A second signal
+// exits right away
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		helpers.Warning("", ":warning: Interrupted, stopping. Interrupt again to exit right away")
+		cancel()
+		<-sigs
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// newInstaller returns an installer recording the command run in the locks
+// it takes, and its component in the audit records
+func newInstaller(cmd *cobra.Command, args []string) *kubernetes.Installer {
+	inst := kubernetes.NewInstaller()
+	inst.ForceUnlock = viper.GetBool("force-unlock")
+	inst.Command = strings.Join(append([]string{cmd.CommandPath()}, args...), " ")
+	if len(args) != 0 {
+		inst.Component = args[0]
 	}
+	return inst
+}
+
+// confirm asks question on stderr, and returns true if the answer read on
+// stdin is yes. With the JSON output and no terminal there's nobody to
+// answer, so it fails asking for the flag answering yes beforehand
+func confirm(question, yesFlag string) (bool, error) {
+	if viper.GetString("output") == helpers.OutputJSON && !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false, kubernetes.UsageError{Err: fmt.Errorf("can't ask %q without a terminal with --output json, pass --%s to answer yes", question, yesFlag)}
+	}
+	helpers.Prompt(question + " [y/N]")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 	pflags := RootCmd.PersistentFlags()
 	pflags.BoolP("debug", "d", false, "verbose output")
+	pflags.StringVar(&cfgFile, "config", "", "Config file (default is .kubecfctl.yaml in the current directory)")
+	pflags.Int("retries", helpers.DefaultRetry.Attempts, "Attempts of the helm commands and cluster calls failing with transient errors")
+	pflags.Duration("retry-backoff", helpers.DefaultRetry.Backoff, "Wait before retrying a failed helm command or cluster call, doubled at each retry")
+	pflags.String("output", helpers.OutputAuto, "Output format: "+strings.Join(helpers.OutputFormats, ", "))
+	pflags.Duration("timeout", kubernetes.DefaultTimeout, "Maximum duration of each wait, e.g. for the pods of a component to be running")
+	pflags.StringToString("phase-timeout", map[string]string{}, "Maximum duration of the waits of a phase, overriding --timeout (e.g. quarks=5m,kubecf=1h)")
+	viper.BindPFlag("retries", pflags.Lookup("retries"))
+	viper.BindPFlag("retry-backoff", pflags.Lookup("retry-backoff"))
+	viper.BindPFlag("output", pflags.Lookup("output"))
+	viper.BindPFlag("timeout", pflags.Lookup("timeout"))
+	viper.BindPFlag("phase-timeout", pflags.Lookup("phase-timeout"))
+}
+
+// timeouts returns the timeouts of the waits set with --timeout and
+// --phase-timeout. It fails with a UsageError on unknown phases and invalid
+// durations
+func timeouts() (kubernetes.Timeouts, error) {
+	t, err := parseTimeouts()
+	if err != nil {
+		return t, kubernetes.UsageError{Err: err}
+	}
+	return t, nil
+}
+
+func parseTimeouts() (kubernetes.Timeouts, error) {
+	t := kubernetes.Timeouts{
+		Default: viper.GetDuration("timeout"),
+		Phases:  map[string]time.Duration{},
+	}
+	for phase, value := range viper.GetStringMapString("phase-timeout") {
+		if !isTimeoutPhase(phase) {
+			return t, fmt.Errorf("unknown phase %q in --phase-timeout, the phases are %s", phase, strings.Join(deployments.TimeoutPhases, ", "))
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return t, errors.Wrapf(err, "invalid timeout of phase %s", phase)
+		}
+		if d <= 0 {
+			return t, fmt.Errorf("invalid timeout of phase %s: %s is not positive", phase, value)
+		}
+		t.Phases[phase] = d
+	}
+	if t.Default <= 0 {
+		return t, fmt.Errorf("invalid --timeout: %s is not positive", t.Default)
+	}
+	return t, nil
+}
+
+func isTimeoutPhase(phase string) bool {
+	for _, p := range deployments.TimeoutPhases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -102,4 +300,43 @@ func initConfig() {
 	replacer := strings.NewReplacer(".", "__")
 	viper.SetEnvKeyReplacer(replacer)
 	viper.SetTypeByDefaultValue(true)
+
+	if err := readConfig(); err != nil {
+		os.Exit(kubernetes.ReportError(kubernetes.UsageError{Err: err}))
+	}
+
+	helpers.DefaultRetry.Attempts = viper.GetInt("retries")
+	helpers.DefaultRetry.Backoff = viper.GetDuration("retry-backoff")
+
+	if err := setReporters(); err != nil {
+		os.Exit(kubernetes.ReportError(err))
+	}
+}
+
+// setReporters sets the reporters of the events and of the questions to the
+// output format set with --output
+func setReporters() error {
+	format := viper.GetString("output")
+	reporter, err := helpers.NewReporter(format, os.Stdout)
+	if err != nil {
+		// Backups and restores took their location with --output before
+		// it set the output format
+		return kubernetes.UsageError{Err: fmt.Errorf("%s, backups and restores take their location with --location", err)}
+	}
+	prompter, err := helpers.NewReporter(format, os.Stderr)
+	if err != nil {
+		return err
+	}
+	helpers.DefaultReporter, helpers.PromptReporter = reporter, prompter
+	return nil
+}
+
+// readConfig reads the config file. The default one is optional, the one
+// given with --config isn't
+func readConfig() error {
+	err := viper.ReadInConfig()
+	if _, notFound := err.(viper.ConfigFileNotFoundError); notFound && cfgFile == "" {
+		return nil
+	}
+	return errors.Wrap(err, "while reading the config file")
 }
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Config file", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "kubecfctl-test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cfgFile = ""
+		os.RemoveAll(dir)
+	})
+
+	It("sets the timeouts of the phases", func() {
+		cfgFile = filepath.Join(dir, "config.yaml")
+		Expect(ioutil.WriteFile(cfgFile, []byte("timeout: 30m\nphase-timeout:\n  quarks: 5m\n  kubecf: 1h\n"), 0644)).To(Succeed())
+		initConfig()
+
+		t, err := timeouts()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Default).To(Equal(30 * time.Minute))
+		Expect(t.Phases).To(Equal(map[string]time.Duration{"quarks": 5 * time.Minute, "kubecf": time.Hour}))
+	})
+
+	It("fails when the given file is missing", func() {
+		cfgFile = filepath.Join(dir, "missing.yaml")
+		viper.SetConfigFile(cfgFile)
+
+		Expect(readConfig()).To(MatchError(ContainSubstring("while reading the config file")))
+	})
+})
+
+var _ = Describe("Output format", func() {
+	It("refuses the backup locations given as output format", func() {
+		viper.Set("output", "s3://bucket/kubecf")
+		defer viper.Set("output", "")
+
+		err := setReporters()
+		Expect(err).To(MatchError(ContainSubstring("take their location with --location")))
+		Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassUsage))
+	})
+})
+
+var _ = Describe("Confirmations", func() {
+	It("are refused with the JSON output and no terminal", func() {
+		viper.Set("output", "json")
+		defer viper.Set("output", "")
+
+		remove, err := confirm("Remove them?", "yes")
+		Expect(remove).To(BeFalse())
+		Expect(err).To(MatchError(ContainSubstring("pass --yes to answer yes")))
+		Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassUsage))
+	})
+})
+
+var _ = Describe("Command line errors", func() {
+	run := func(args ...string) error {
+		RootCmd.SetArgs(args)
+		RootCmd.SetOut(ioutil.Discard)
+		defer RootCmd.SetArgs(nil)
+		return execute(context.Background())
+	}
+
+	It("are usage errors when the command line is invalid", func() {
+		for _, args := range [][]string{
+			{"nope"},
+			{"history", "--nope"},
+			{"backup"},
+			{"get", "kubecf-password"},
+		} {
+			Expect(kubernetes.ErrorClass(run(args...))).To(Equal(kubernetes.ClassUsage), "%v", args)
+		}
+	})
+
+	It("keep the class of the errors returned by the commands", func() {
+		failing := &cobra.Command{
+			Use: "failing",
+			RunE: func(*cobra.Command, []string) error {
+				return kubernetes.ConnectionError{Err: errors.New("connection refused")}
+			},
+		}
+		RootCmd.AddCommand(failing)
+		defer RootCmd.RemoveCommand(failing)
+
+		Expect(kubernetes.ErrorClass(run("failing"))).To(Equal(kubernetes.ClassConnection))
+	})
+})
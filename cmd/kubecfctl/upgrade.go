@@ -14,11 +14,10 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -31,14 +30,30 @@ var upgradeCmd = &cobra.Command{
 	Long: `This command upgrades the specified component in your cluster.
 
 Currently there are available two components, "kubecf" and "ingress".
+
+To backup KubeCF first, and roll it back if the upgrade doesn't become ready:
+
+	$ kubecfctl upgrade kubecf --backup-to s3://bucket/kubecf --auto-rollback
+
+The helm releases are rolled back to their previous revisions, and the data is
+restored from the backup if the databases were migrated by the upgrade.
+
+The installed version is read from the helm release, and only the upgrades
+supported by the catalog are allowed. To go through intermediate versions:
+
+	$ kubecfctl upgrade kubecf --version 2.6.1 --multi-hop
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("force-unlock", cmd.Flags().Lookup("force-unlock"))
 		viper.BindPFlag("eirini", cmd.Flags().Lookup("eirini"))
 		viper.BindPFlag("ingress", cmd.Flags().Lookup("ingress"))
 		viper.BindPFlag("debug", cmd.Flags().Lookup("debug"))
 		viper.BindPFlag("version", cmd.Flags().Lookup("version"))
 		viper.BindPFlag("chart", cmd.Flags().Lookup("chart"))
 		viper.BindPFlag("quarks-chart", cmd.Flags().Lookup("quarks-chart"))
+		viper.BindPFlag("backup-to", cmd.Flags().Lookup("backup-to"))
+		viper.BindPFlag("auto-rollback", cmd.Flags().Lookup("auto-rollback"))
+		viper.BindPFlag("multi-hop", cmd.Flags().Lookup("multi-hop"))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		eirini := viper.GetBool("eirini")
@@ -47,34 +62,41 @@ Currently there are available two components, "kubecf" and "ingress".
 		version := viper.GetString("version")
 		chartURL := viper.GetString("chart")
 		quarksChart := viper.GetString("quarks-chart")
+		backupTo := viper.GetString("backup-to")
+		autoRollback := viper.GetBool("auto-rollback")
+		multiHop := viper.GetBool("multi-hop")
 
 		cluster, err := kubernetes.NewCluster(os.Getenv("KUBECONFIG"))
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
+		}
+		helpers.Info("", cluster.GetPlatform().Describe())
+		inst := newInstaller(cmd, args)
+
+		waitTimeouts, err := timeouts()
+		if err != nil {
+			os.Exit(kubernetes.ReportError(err))
 		}
-		emoji.Println(cluster.GetPlatform().Describe())
-		inst := kubernetes.NewInstaller()
 
 		d, err := deployments.GlobalCatalog.Deployment(args[0], deployments.DeploymentOptions{
-			Version:   version,
-			Eirini:    eirini,
-			Timeout:   1000,
-			Ingress:   ingress,
-			Debug:     debug,
-			ChartURL:  chartURL,
-			QuarksURL: quarksChart,
+			Version:      version,
+			Eirini:       eirini,
+			Timeouts:     waitTimeouts,
+			Ingress:      ingress,
+			Debug:        debug,
+			ChartURL:     chartURL,
+			QuarksURL:    quarksChart,
+			BackupTo:     backupTo,
+			AutoRollback: autoRollback,
+			MultiHop:     multiHop,
 		})
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
 		}
 
-		err = inst.Upgrade(d, *cluster)
+		err = inst.Upgrade(cmd.Context(), d, *cluster)
 		if err != nil {
-			fmt.Println(err)
-
-			os.Exit(1)
+			os.Exit(kubernetes.ReportError(err))
 		}
 	},
 }
@@ -85,6 +107,12 @@ func init() {
 	upgradeCmd.Flags().String("chart", "", "Chart URL (tgz)")
 	upgradeCmd.Flags().String("quarks-chart", "", "Quarks Chart URL (tgz)")
 	upgradeCmd.Flags().String("version", "", "Component version to deploy")
+	upgradeCmd.Flags().String("backup-to", "", "Backup directory or s3:// URL to take a backup in before upgrading")
+	upgradeCmd.Flags().Bool("auto-rollback", false, "Roll back the upgrade if it fails (backs up to a new directory in the current one unless --backup-to is given)")
+
+	upgradeCmd.Flags().Bool("multi-hop", false, "Upgrade through the intermediate versions if the installed one can't be upgraded directly")
+
+	upgradeCmd.Flags().Bool("force-unlock", false, "Remove a lock left behind by an interrupted run before taking it")
 
 	RootCmd.AddCommand(upgradeCmd)
 }
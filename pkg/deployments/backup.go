@@ -0,0 +1,212 @@
+package deployments
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// manifestFile is the file describing the content of a backup
+const manifestFile = "manifest.yaml"
+
+// Data sets which can be selected for backup and restore
+const (
+	DataUAA       = "uaa"
+	DataCCDB      = "ccdb"
+	DataBlobstore = "blobstore"
+	DataCredhub   = "credhub"
+	DataConfig    = "config"
+	DataSecrets   = "secrets"
+)
+
+// DataSets are all the data sets captured by a backup
+var DataSets = []string{DataUAA, DataCCDB, DataBlobstore, DataCredhub, DataConfig, DataSecrets}
+
+// dumpFiles are the files of a backup holding the database dumps of the data
+// sets, the same for SCF and KubeCF
+var dumpFiles = map[string]string{
+	DataUAA:     "uaadb-src.sql",
+	DataCCDB:    "ccdb-src.sql",
+	DataCredhub: "credhub-src.sql",
+}
+
+// dumpFile returns the path of the database dump of dataSet in dir
+func dumpFile(dir, dataSet string) string {
+	return filepath.Join(dir, dumpFiles[dataSet])
+}
+
+// checkDumps returns an error if the database dump or the blobstore archive
+// of one of dataSets is missing from dir, so a restore fails before changing
+// any database
+func checkDumps(dir string, dataSets []string) error {
+	for _, d := range dataSets {
+		file, what := dumpFile(dir, d), "database dump"
+		switch {
+		case d == DataBlobstore:
+			file, what = filepath.Join(dir, blobstoreArchive), "archive"
+		case dumpFiles[d] == "":
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return kubernetes.PreflightError{Err: errors.Wrap(err, "the "+d+" "+what+" is missing from the backup")}
+		}
+	}
+	return nil
+}
+
+// legacyDataSets are the data sets of backups without a data set list
+var legacyDataSets = []string{DataUAA, DataCCDB, DataBlobstore, DataConfig, DataSecrets}
+
+// dataSetRequires lists the data sets which have to be restored along with
+// a data set to make it usable: the CCDB is encrypted with the keys in the
+// CC config, and CredHub with a key stored in the secrets
+var dataSetRequires = map[string][]string{
+	DataCCDB:    {DataConfig},
+	DataCredhub: {DataSecrets},
+}
+
+// SelectDataSets returns the data sets in available selected by include
+// and exclude. An empty include selects all of them
+func SelectDataSets(available, include, exclude []string) ([]string, error) {
+	for _, list := range [][]string{include, exclude} {
+		for _, d := range list {
+			if !contains(DataSets, d) {
+				return nil, kubernetes.UsageError{Err: errors.New("invalid data set " + d + ", valid ones are: " + strings.Join(DataSets, ", "))}
+			}
+		}
+	}
+
+	selected := available
+	if len(include) != 0 {
+		selected = include
+	}
+
+	var res []string
+	for _, d := range selected {
+		if !contains(exclude, d) {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// checkRestorable returns an error if the backup doesn't have one of the
+// selected data sets or the ones they need
+func (m BackupManifest) checkRestorable(selected []string) error {
+	for _, d := range selected {
+		if !contains(m.DataSets, d) {
+			return kubernetes.PreflightError{Err: errors.New(d + " was not captured in the backup")}
+		}
+		for _, r := range dataSetRequires[d] {
+			if !contains(m.DataSets, r) {
+				return kubernetes.PreflightError{Err: errors.New(d + " requires " + r + ", which was not captured in the backup")}
+			}
+			if !contains(selected, r) {
+				return kubernetes.UsageError{Err: errors.New(d + " requires " + r + " to be restored as well")}
+			}
+		}
+	}
+	return nil
+}
+
+// BackupManifest describes the content of a backup. Each namespace is stored
+// in its own subdirectory of the backup
+type BackupManifest struct {
+	Deployment string    `yaml:"deployment"`
+	Version    string    `yaml:"version"`
+	Date       time.Time `yaml:"date"`
+	Namespace  string    `yaml:"namespace"`
+	Namespaces []string  `yaml:"namespaces"`
+	DataSets   []string  `yaml:"data_sets"`
+
+	// legacy is set for backups taken before manifests were introduced,
+	// which hold a single namespace in the backup root
+	legacy bool
+}
+
+// ReadBackupManifest reads the manifest of the backup in dir. Backups without
+// a manifest are assumed to hold only namespace
+func ReadBackupManifest(dir, namespace string) (BackupManifest, error) {
+	dat, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return BackupManifest{
+			Namespace:  namespace,
+			Namespaces: []string{namespace},
+			DataSets:   legacyDataSets,
+			legacy:     true,
+		}, nil
+	}
+	if err != nil {
+		return BackupManifest{}, errors.Wrap(err, "while reading backup manifest")
+	}
+	m := BackupManifest{}
+	if err := yaml.Unmarshal(dat, &m); err != nil {
+		return BackupManifest{}, errors.Wrap(err, "while reading backup manifest")
+	}
+	if len(m.DataSets) == 0 {
+		m.DataSets = legacyDataSets
+	}
+	return m, nil
+}
+
+// Write stores the manifest in the backup directory
+func (m BackupManifest) Write(dir string) error {
+	dat, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, manifestFile), dat, 0644)
+}
+
+// Dir returns the directory holding the backup of namespace
+func (m BackupManifest) Dir(dir, namespace string) string {
+	if m.legacy {
+		return dir
+	}
+	return filepath.Join(dir, namespace)
+}
+
+// Has returns true if the backup contains namespace
+func (m BackupManifest) Has(namespace string) bool {
+	return contains(m.Namespaces, namespace)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// blobstoreArchive is the file holding the blobstore content in a backup
+const blobstoreArchive = "blob.tgz"
+
+// archiveBlobstore streams the content of the blobstore in pod to the
+// archive in dir
+func archiveBlobstore(ctx context.Context, c kubernetes.Cluster, namespace, pod, dir string) error {
+	f, err := os.Create(filepath.Join(dir, blobstoreArchive))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.ExecStream(ctx, namespace, pod, "", "tar cfz - --exclude=/var/vcap/store/shared/tmp /var/vcap/store/shared", nil, f)
+}
+
+// extractBlobstore streams the archive in dir to the blobstore in pod
+func extractBlobstore(ctx context.Context, c kubernetes.Cluster, namespace, pod, dir string) error {
+	f, err := os.Open(filepath.Join(dir, blobstoreArchive))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.ExecStream(ctx, namespace, pod, "", "tar xfz - -C /", f, ioutil.Discard)
+}
@@ -0,0 +1,215 @@
+package deployments_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Data sets", func() {
+	ctx := context.Background()
+
+	Describe("SelectDataSets", func() {
+		It("selects all the available data sets by default", func() {
+			Expect(SelectDataSets(DataSets, nil, nil)).To(Equal(DataSets))
+		})
+
+		It("selects the included data sets, less the excluded ones", func() {
+			Expect(SelectDataSets(DataSets, []string{DataUAA, DataCCDB, DataConfig}, []string{DataCCDB})).To(Equal([]string{DataUAA, DataConfig}))
+			Expect(SelectDataSets(DataSets, nil, []string{DataBlobstore, DataCredhub})).To(Equal([]string{DataUAA, DataCCDB, DataConfig, DataSecrets}))
+		})
+
+		It("refuses invalid data sets as usage errors", func() {
+			_, err := SelectDataSets(DataSets, []string{"routing"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid data set routing")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassUsage))
+		})
+	})
+
+	Describe("Backup", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "kubecfctl-test")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("captures the selected data sets only", func() {
+			cluster, _ := newCluster(kubecfPods("kubecf")...)
+			var commands []string
+			cluster.Executor = func(_ context.Context, _, pod, _, command string, _ bool, _ io.Reader, _, _ io.Writer) error {
+				commands = append(commands, pod+" "+command)
+				return nil
+			}
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Include: []string{DataUAA, DataConfig}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubecf.Backup(ctx, *cluster, dir)).To(Succeed())
+			files, err := ioutil.ReadDir(filepath.Join(dir, "kubecf"))
+			Expect(err).ToNot(HaveOccurred())
+			var names []string
+			for _, f := range files {
+				names = append(names, f.Name())
+			}
+			Expect(names).To(ConsistOf("uaadb-src.sql", "cc_config.yaml"))
+			Expect(commands).To(ContainElement(HavePrefix("database-0 mysqldump --skip-lock-tables uaa")))
+			Expect(commands).ToNot(ContainElement(ContainSubstring(" cloud_controller")))
+			Expect(commands).ToNot(ContainElement(HavePrefix("singleton-blobstore-0 ")))
+
+			manifest, err := ReadBackupManifest(dir, "kubecf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.DataSets).To(Equal([]string{DataUAA, DataConfig}))
+		})
+
+		Describe("consistent", func() {
+			var (
+				cluster *kubernetes.Cluster
+				client  *fake.Clientset
+				exec    *fakeExec
+			)
+
+			// api returns the replicas and the recorded replicas of the api
+			// StatefulSet
+			api := func() (int32, map[string]string) {
+				set, err := client.AppsV1().StatefulSets("kubecf").Get(ctx, "api", metav1.GetOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				return *set.Spec.Replicas, set.Annotations
+			}
+
+			BeforeEach(func() {
+				objects := append(kubecfPods("kubecf"), ccStatefulSets("kubecf")...)
+				cluster, client = newCluster(objects...)
+				scalable(client)
+				exec = newFakeExec(cluster)
+				// The commands can only run in the pods running
+				run := cluster.Executor
+				cluster.Executor = func(ctx context.Context, namespace, pod, container, command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
+					if pod == "api-0" {
+						if _, err := client.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{}); err != nil {
+							return err
+						}
+					}
+					return run(ctx, namespace, pod, container, command, tty, stdin, stdout, stderr)
+				}
+			})
+
+			It("stops the Cloud Controller while dumping the databases, and resumes it", func() {
+				exec.Outputs["api-0 cat /var/vcap/jobs/cloud_controller_ng/config/cloud_controller_ng.yml"] = "system_domain: example.com"
+				var replicas int32 = -1
+				var annotations map[string]string
+				dump := cluster.Executor
+				cluster.Executor = func(ctx context.Context, namespace, pod, container, command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
+					if strings.HasPrefix(command, "mysqldump ") && replicas < 0 {
+						replicas, annotations = api()
+					}
+					return dump(ctx, namespace, pod, container, command, tty, stdin, stdout, stderr)
+				}
+				kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Consistent: true, Include: []string{DataUAA, DataCCDB, DataConfig}})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(kubecf.Backup(ctx, *cluster, dir)).To(Succeed())
+				Expect(replicas).To(BeZero())
+				Expect(annotations).To(HaveKeyWithValue("kubecfctl.io/quiesced-replicas", "1"))
+				config, err := ioutil.ReadFile(filepath.Join(dir, "kubecf", "cc_config.yaml"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(config)).To(Equal("system_domain: example.com"))
+
+				replicas, annotations = api()
+				Expect(replicas).To(Equal(int32(1)))
+				Expect(annotations).ToNot(HaveKey("kubecfctl.io/quiesced-replicas"))
+			})
+
+			It("resumes the Cloud Controller when the backup fails", func() {
+				failing := cluster.Executor
+				cluster.Executor = func(ctx context.Context, namespace, pod, container, command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
+					if strings.HasPrefix(command, "mysqldump ") {
+						return errors.New("mysqldump: Got error: 2013: Lost connection to MySQL server")
+					}
+					return failing(ctx, namespace, pod, container, command, tty, stdin, stdout, stderr)
+				}
+				kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Consistent: true, Include: []string{DataCCDB, DataConfig}})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(kubecf.Backup(ctx, *cluster, dir)).To(MatchError(ContainSubstring("while backing up ccdb db")))
+				for _, name := range []string{"api", "cc-worker", "scheduler"} {
+					set, err := client.AppsV1().StatefulSets("kubecf").Get(ctx, name, metav1.GetOptions{})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(*set.Spec.Replicas).To(Equal(int32(1)))
+					Expect(set.Annotations).ToNot(HaveKey("kubecfctl.io/quiesced-replicas"))
+				}
+			})
+		})
+	})
+
+	Describe("Restore", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "kubecfctl-test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(BackupManifest{
+				Deployment: "kubecf",
+				Version:    "2.6.1",
+				Namespace:  "kubecf",
+				Namespaces: []string{"kubecf"},
+				DataSets:   []string{DataUAA, DataCCDB},
+			}.Write(dir)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		restore := func(opts DeploymentOptions) error {
+			cluster, client := newCluster(kubecfPods("kubecf")...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", opts)
+			Expect(err).ToNot(HaveOccurred())
+			err = kubecf.Restore(ctx, *cluster, dir)
+			// Nothing is changed in the cluster
+			Expect(actions(client)).To(BeEmpty())
+			Expect(runner.Commands()).To(BeEmpty())
+			return err
+		}
+
+		It("refuses data sets missing from the backup", func() {
+			err := restore(DeploymentOptions{Include: []string{DataBlobstore}})
+			Expect(err).To(MatchError(ContainSubstring("blobstore was not captured in the backup")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassPreflight))
+		})
+
+		It("refuses data sets whose requirements are missing from the backup", func() {
+			err := restore(DeploymentOptions{})
+			Expect(err).To(MatchError(ContainSubstring("ccdb requires config, which was not captured in the backup")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassPreflight))
+		})
+
+		It("refuses data sets restored without their requirements", func() {
+			Expect(BackupManifest{
+				Namespace:  "kubecf",
+				Namespaces: []string{"kubecf"},
+				DataSets:   []string{DataCredhub, DataSecrets},
+			}.Write(dir)).To(Succeed())
+
+			err := restore(DeploymentOptions{Include: []string{DataCredhub}})
+			Expect(err).To(MatchError(ContainSubstring("credhub requires secrets to be restored as well")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassUsage))
+		})
+	})
+})
@@ -1,13 +1,13 @@
 package deployments
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 
 	"github.com/hashicorp/go-multierror"
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/pkg/errors"
@@ -22,7 +22,7 @@ type Carrier struct {
 	domain                             string
 	Debug                              bool
 
-	Timeout int
+	Timeouts kubernetes.Timeouts
 }
 
 func (k *Carrier) SetDomain(d string) {
@@ -33,21 +33,26 @@ func (k Carrier) GetDomain() string {
 	return k.domain
 }
 
+func (k Carrier) GetNamespace() string {
+	return k.Namespace
+}
+
 func (k Carrier) GetVersion() string {
 	return k.Version
 }
 
 func (k Carrier) Describe() string {
-	return emoji.Sprintf(":cloud:Carrier version: %s\n:clipboard: url: %s", k.Version, k.ChartURL)
+	return fmt.Sprintf(":cloud:Carrier version: %s\n:clipboard: url: %s", k.Version, k.ChartURL)
 }
 
-func (k Carrier) Delete(c kubernetes.Cluster) error {
+func (k Carrier) Delete(ctx context.Context, c kubernetes.Cluster) error {
 
 	quarks, err := GlobalCatalog.GetQuarks(k.quarksVersion)
 	if err != nil {
 		return err
 	}
-	err = quarks.Delete(c)
+	quarks.Timeouts = k.Timeouts
+	err = quarks.Delete(ctx, c)
 	if err != nil {
 		return err
 	}
@@ -59,38 +64,47 @@ func (k Carrier) Delete(c kubernetes.Cluster) error {
 	defer os.RemoveAll(dir)
 
 	var result error
-	if _, err := helpers.RunProc(fmt.Sprintf("git clone %s ./", k.ChartURL), dir, k.Debug); err != nil {
+	if _, err := helpers.RunProc(ctx, fmt.Sprintf("git clone %s ./", k.ChartURL), dir, k.Debug); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	if _, err := helpers.RunProc("./gitea/uninstall", dir, k.Debug); err != nil {
+	if _, err := helpers.RunProc(ctx, "./gitea/uninstall", dir, k.Debug); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	if _, err := helpers.RunProc("./kpack/uninstall", dir, k.Debug); err != nil {
+	if _, err := helpers.RunProc(ctx, "./kpack/uninstall", dir, k.Debug); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	if _, err := helpers.RunProc("./drone/uninstall", dir, k.Debug); err != nil {
+	if _, err := helpers.RunProc(ctx, "./drone/uninstall", dir, k.Debug); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	if _, err := helpers.RunProc("./eirini/uninstall", dir, k.Debug); err != nil {
+	if _, err := helpers.RunProc(ctx, "./eirini/uninstall", dir, k.Debug); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	emoji.Println(":heavy_check_mark: Carrier deleted")
+	if result != nil {
+		helpers.Warning("", ":warning: Carrier deleted partially, some of its components failed to uninstall")
+		// The other components were uninstalled anyway, so even a single
+		// failure leaves Carrier partially deleted
+		return kubernetes.PartialError{Err: result}
+	}
+	helpers.Success("", ":heavy_check_mark: Carrier deleted")
 
 	return nil
 }
-func (k *Carrier) Backup(c kubernetes.Cluster, d string) error {
+func (k *Carrier) Backup(ctx context.Context, c kubernetes.Cluster, d string) error {
 	return nil
 }
-func (k *Carrier) Restore(c kubernetes.Cluster, d string) error {
+func (k *Carrier) Restore(ctx context.Context, c kubernetes.Cluster, d string) error {
 	return nil
 }
+func (k *Carrier) Rollback(ctx context.Context, c kubernetes.Cluster, revision int) error {
+	return kubernetes.UsageError{Err: errors.New("carrier is not deployed with helm, it can't be rolled back")}
+}
 
-func (k Carrier) Deploy(c kubernetes.Cluster) error {
+func (k Carrier) Deploy(ctx context.Context, c kubernetes.Cluster) error {
 	dir, err := ioutil.TempDir(os.TempDir(), "kubecfctl")
 	if err != nil {
 		log.Fatal(err)
@@ -101,52 +115,56 @@ func (k Carrier) Deploy(c kubernetes.Cluster) error {
 	if err != nil {
 		return err
 	}
-	err = quarks.Deploy(c)
+	quarks.Timeouts = k.Timeouts
+	err = quarks.Deploy(ctx, c)
 	if err != nil {
 		return err
 	}
 
 	var result error
-	out, err := helpers.RunProc(fmt.Sprintf("git clone %s ./", k.ChartURL), dir, k.Debug)
+	out, err := helpers.RunProc(ctx, fmt.Sprintf("git clone %s ./", k.ChartURL), dir, k.Debug)
 	if err != nil {
 		result = multierror.Append(result, err)
 	}
-	fmt.Println(out)
+	helpers.Output("", out)
 
-	out, err = helpers.RunProc(fmt.Sprintf("./gitea/install %s", c.GetPlatform().ExternalIPs()[0]), dir, k.Debug)
+	out, err = helpers.RunProc(ctx, fmt.Sprintf("./gitea/install %s", c.GetPlatform().ExternalIPs()[0]), dir, k.Debug)
 	if err != nil {
 		result = multierror.Append(result, err)
 	}
-	fmt.Println(out)
-	out, err = helpers.RunProc(fmt.Sprintf("./kpack/install %s %s", k.RegistryUsername, k.RegistryPassword), dir, k.Debug)
+	helpers.Output("", out)
+	// The credentials are expanded by the shell, to keep them out of the
+	// command line run and logged
+	out, err = helpers.RunProcEnv(ctx, `./kpack/install "$REGISTRY_USERNAME" "$REGISTRY_PASSWORD"`, dir,
+		[]string{"REGISTRY_USERNAME=" + k.RegistryUsername, "REGISTRY_PASSWORD=" + k.RegistryPassword}, k.Debug)
 	if err != nil {
 		result = multierror.Append(result, err)
 	}
-	fmt.Println(out)
-	out, err = helpers.RunProc(fmt.Sprintf("./drone/install %s", c.GetPlatform().ExternalIPs()[0]), dir, k.Debug)
+	helpers.Output("", out)
+	out, err = helpers.RunProc(ctx, fmt.Sprintf("./drone/install %s", c.GetPlatform().ExternalIPs()[0]), dir, k.Debug)
 	if err != nil {
 		result = multierror.Append(result, err)
 	}
-	fmt.Println(out)
-	out, err = helpers.RunProc("./eirini/install", dir, k.Debug)
+	helpers.Output("", out)
+	out, err = helpers.RunProc(ctx, "./eirini/install", dir, k.Debug)
 	if err != nil {
 		result = multierror.Append(result, err)
 	}
-	fmt.Println(out)
-	out, err = helpers.RunProc(fmt.Sprintf("./drone-gitea/install %s", c.GetPlatform().ExternalIPs()[0]), dir, k.Debug)
+	helpers.Output("", out)
+	out, err = helpers.RunProc(ctx, fmt.Sprintf("./drone-gitea/install %s", c.GetPlatform().ExternalIPs()[0]), dir, k.Debug)
 	if err != nil {
 		result = multierror.Append(result, err)
 	}
-	fmt.Println(out)
-	return err
+	helpers.Output("", out)
+	return kubernetes.Partial(result)
 }
 
-func (k Carrier) Upgrade(c kubernetes.Cluster) error {
+func (k Carrier) Upgrade(ctx context.Context, c kubernetes.Cluster) error {
 
-	if err := k.Delete(c); err != nil {
+	if err := k.Delete(ctx, c); err != nil {
 		return errors.Wrap(err, "while deploying quarks operator")
 	}
-	emoji.Println(":ship:Upgrading kubecf")
+	helpers.Info("", ":ship:Upgrading kubecf")
 
-	return k.Deploy(c)
+	return k.Deploy(ctx, c)
 }
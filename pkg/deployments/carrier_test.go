@@ -0,0 +1,56 @@
+package deployments_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Carrier", func() {
+	ctx := context.Background()
+
+	Describe("Delete", func() {
+		var (
+			out      bytes.Buffer
+			reporter helpers.Reporter
+		)
+
+		BeforeEach(func() {
+			out.Reset()
+			reporter = helpers.DefaultReporter
+			helpers.DefaultReporter, _ = helpers.NewReporter(helpers.OutputPlain, &out)
+		})
+
+		AfterEach(func() {
+			helpers.DefaultReporter = reporter
+		})
+
+		It("reports success when every component is uninstalled", func() {
+			cluster, _ := newCluster()
+			carrier, err := GlobalCatalog.Deployment("carrier", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(carrier.Delete(ctx, *cluster)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("Carrier deleted"))
+		})
+
+		It("fails as partial, without reporting success, when components fail to uninstall", func() {
+			cluster, _ := newCluster()
+			runner.Errors["./kpack/uninstall"] = errors.New("exit status 1")
+			carrier, err := GlobalCatalog.Deployment("carrier", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = carrier.Delete(ctx, *cluster)
+			Expect(err).To(MatchError(ContainSubstring("exit status 1")))
+			Expect(kubernetes.ExitCode(err)).To(Equal(kubernetes.ExitPartial))
+			Expect(out.String()).To(ContainSubstring("WARNING: Carrier deleted partially"))
+			Expect(out.String()).ToNot(ContainSubstring("Carrier deleted\n"))
+		})
+	})
+})
@@ -1,8 +1,10 @@
 package deployments
 
 import (
+	"strconv"
 	"strings"
 
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	kubernetes "github.com/mudler/kubecfctl/pkg/kubernetes"
 
 	"github.com/pkg/errors"
@@ -82,10 +84,81 @@ var GlobalCatalog = Catalog{
 	},
 }
 
+// kubecfUpgrades lists the KubeCF chart versions each version can be
+// upgraded to directly
+var kubecfUpgrades = map[string][]string{
+	"2.5.8": {"2.6.1"},
+}
+
+// quarksCompatibility lists the Quarks versions each KubeCF chart version
+// runs with
+var quarksCompatibility = map[string][]string{
+	"2.5.8": {"6.1.17"},
+	"2.6.1": {"6.1.17"},
+}
+
+// KubeCFUpgradePath returns the KubeCF versions to upgrade through to go
+// from version from to version to, to included. It's empty if they are the
+// same version
+func (c Catalog) KubeCFUpgradePath(from, to string) ([]string, error) {
+	if _, ok := quarksCompatibility[from]; !ok {
+		return nil, kubernetes.PreflightError{Err: errors.New("unknown installed kubecf version " + from)}
+	}
+	if from == to {
+		return nil, nil
+	}
+	if compareVersions(to, from) < 0 {
+		return nil, kubernetes.PreflightError{Err: errors.New("downgrading kubecf from " + from + " to " + to + " is not supported")}
+	}
+
+	// Breadth first, to find the shortest path
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) != 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if v == to {
+			var path []string
+			for ; v != from; v = previous[v] {
+				path = append([]string{v}, path...)
+			}
+			return path, nil
+		}
+		for _, next := range kubecfUpgrades[v] {
+			if _, seen := previous[next]; !seen {
+				previous[next] = v
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil, kubernetes.PreflightError{Err: errors.New("no supported upgrade path from kubecf " + from + " to " + to)}
+}
+
+// compareVersions compares two dotted version numbers, returning -1, 0 or 1
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
 func (c Catalog) GetCAP(version string) (KubeCF, error) {
 	d, ok := c["cap"][version]
 	if !ok {
-		return KubeCF{}, errors.New("version not found")
+		return KubeCF{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*KubeCF)), nil
 }
@@ -93,7 +166,7 @@ func (c Catalog) GetCAP(version string) (KubeCF, error) {
 func (c Catalog) GetSCF(version string) (SCF, error) {
 	d, ok := c["scf"][version]
 	if !ok {
-		return SCF{}, errors.New("version not found")
+		return SCF{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*SCF)), nil
 }
@@ -101,7 +174,7 @@ func (c Catalog) GetSCF(version string) (SCF, error) {
 func (c Catalog) GetKubeCF(version string) (KubeCF, error) {
 	d, ok := c["kubecf"][version]
 	if !ok {
-		return KubeCF{}, errors.New("version not found")
+		return KubeCF{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*KubeCF)), nil
 }
@@ -109,7 +182,7 @@ func (c Catalog) GetKubeCF(version string) (KubeCF, error) {
 func (c Catalog) GetCarrier(version string) (Carrier, error) {
 	d, ok := c["carrier"][version]
 	if !ok {
-		return Carrier{}, errors.New("version not found")
+		return Carrier{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*Carrier)), nil
 }
@@ -117,7 +190,7 @@ func (c Catalog) GetCarrier(version string) (Carrier, error) {
 func (c Catalog) GetQuarks(version string) (Quarks, error) {
 	d, ok := c["quarks"][version]
 	if !ok {
-		return Quarks{}, errors.New("version not found")
+		return Quarks{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*Quarks)), nil
 }
@@ -125,7 +198,7 @@ func (c Catalog) GetQuarks(version string) (Quarks, error) {
 func (c Catalog) GetNginx(version string) (NginxIngress, error) {
 	d, ok := c["nginx"][version]
 	if !ok {
-		return NginxIngress{}, errors.New("version not found")
+		return NginxIngress{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*NginxIngress)), nil
 }
@@ -133,7 +206,7 @@ func (c Catalog) GetNginx(version string) (NginxIngress, error) {
 func (c Catalog) GetStratos(version string) (Stratos, error) {
 	d, ok := c["stratos"][version]
 	if !ok {
-		return Stratos{}, errors.New("version not found")
+		return Stratos{}, kubernetes.UsageError{Err: errors.New("version not found")}
 	}
 	return *(d.(*Stratos)), nil
 }
@@ -165,7 +238,7 @@ func (c Catalog) Search(term string) []interface{} {
 
 type DeploymentOptions struct {
 	Eirini                             bool
-	Timeout                            int
+	Timeouts                           kubernetes.Timeouts
 	Ingress                            bool
 	Debug                              bool
 	Version                            string
@@ -173,9 +246,41 @@ type DeploymentOptions struct {
 	AdditionalNamespaces               []string
 	RegistryUsername, RegistryPassword string
 	StorageClass                       string
+	RestoreNamespaces                  []string
+	Include, Exclude                   []string
+	InPlace                            bool
+	SafetyBackup                       string
+	Consistent                         bool
+	BackupTo                           string
+	AutoRollback                       bool
+	MultiHop                           bool
 }
 
+// The phases of the operations which can have their own timeouts, see
+// kubernetes.Timeouts
+const (
+	// PhaseQuarks waits for the Quarks operator
+	PhaseQuarks = "quarks"
+	// PhaseIngress waits for the Nginx ingress controller
+	PhaseIngress = "ingress"
+	// PhaseKubeCF waits for the KubeCF pods
+	PhaseKubeCF = "kubecf"
+	// PhaseSCF waits for the SCF pods
+	PhaseSCF = "scf"
+	// PhaseStratos waits for the Stratos console
+	PhaseStratos = "stratos"
+	// PhaseQuiesce waits for the Cloud Controller to stop before backups
+	PhaseQuiesce = "quiesce"
+	// PhaseDelete waits for the namespaces being deleted to be gone
+	PhaseDelete = "delete"
+)
+
+// TimeoutPhases are the phases which can have their own timeouts
+var TimeoutPhases = []string{PhaseQuarks, PhaseIngress, PhaseKubeCF, PhaseSCF, PhaseStratos, PhaseQuiesce, PhaseDelete}
+
 func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Deployment, error) {
+	helpers.RegisterSecret(opts.RegistryPassword)
+
 	switch name {
 	case "cap":
 		if opts.ChartURL != "" || opts.QuarksURL != "" { // Return custom version specified
@@ -185,11 +290,20 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				Namespace:            "kubecf",
 				quarksVersion:        opts.QuarksURL,
 				Eirini:               opts.Eirini,
-				Timeout:              opts.Timeout,
+				Timeouts:             opts.Timeouts,
 				Ingress:              opts.Ingress,
 				Debug:                opts.Debug,
 				StorageClass:         opts.StorageClass,
 				AdditionalNamespaces: opts.AdditionalNamespaces,
+				RestoreNamespaces:    opts.RestoreNamespaces,
+				Include:              opts.Include,
+				Exclude:              opts.Exclude,
+				InPlace:              opts.InPlace,
+				SafetyBackup:         opts.SafetyBackup,
+				Consistent:           opts.Consistent,
+				BackupTo:             opts.BackupTo,
+				AutoRollback:         opts.AutoRollback,
+				MultiHop:             opts.MultiHop,
 			}, nil
 		}
 		if len(opts.Version) == 0 { // Get default version if not specified
@@ -198,11 +312,20 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				return nil, err
 			}
 			kubecf.Eirini = opts.Eirini
-			kubecf.Timeout = opts.Timeout
+			kubecf.Timeouts = opts.Timeouts
 			kubecf.Ingress = opts.Ingress
 			kubecf.Debug = opts.Debug
 			kubecf.StorageClass = opts.StorageClass
 			kubecf.AdditionalNamespaces = opts.AdditionalNamespaces
+			kubecf.RestoreNamespaces = opts.RestoreNamespaces
+			kubecf.Include = opts.Include
+			kubecf.Exclude = opts.Exclude
+			kubecf.InPlace = opts.InPlace
+			kubecf.SafetyBackup = opts.SafetyBackup
+			kubecf.Consistent = opts.Consistent
+			kubecf.BackupTo = opts.BackupTo
+			kubecf.AutoRollback = opts.AutoRollback
+			kubecf.MultiHop = opts.MultiHop
 
 			return &kubecf, nil
 		}
@@ -211,11 +334,20 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		kubecf.Eirini = opts.Eirini
-		kubecf.Timeout = opts.Timeout
+		kubecf.Timeouts = opts.Timeouts
 		kubecf.Ingress = opts.Ingress
 		kubecf.StorageClass = opts.StorageClass
 		kubecf.Debug = opts.Debug
 		kubecf.AdditionalNamespaces = opts.AdditionalNamespaces
+		kubecf.RestoreNamespaces = opts.RestoreNamespaces
+		kubecf.Include = opts.Include
+		kubecf.Exclude = opts.Exclude
+		kubecf.InPlace = opts.InPlace
+		kubecf.SafetyBackup = opts.SafetyBackup
+		kubecf.Consistent = opts.Consistent
+		kubecf.BackupTo = opts.BackupTo
+		kubecf.AutoRollback = opts.AutoRollback
+		kubecf.MultiHop = opts.MultiHop
 		return &kubecf, nil
 	case "scf":
 		if opts.ChartURL != "" { // Return custom version specified
@@ -224,11 +356,13 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				ChartURL:             opts.ChartURL,
 				Namespace:            "scf",
 				Eirini:               opts.Eirini,
-				Timeout:              opts.Timeout,
+				Timeouts:             opts.Timeouts,
 				Ingress:              opts.Ingress,
 				Debug:                opts.Debug,
 				StorageClass:         opts.StorageClass,
 				AdditionalNamespaces: opts.AdditionalNamespaces,
+				Include:              opts.Include,
+				Exclude:              opts.Exclude,
 			}, nil
 		}
 		if len(opts.Version) == 0 { // Get default version if not specified
@@ -237,11 +371,13 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				return nil, err
 			}
 			scf.Eirini = opts.Eirini
-			scf.Timeout = opts.Timeout
+			scf.Timeouts = opts.Timeouts
 			scf.Ingress = opts.Ingress
 			scf.Debug = opts.Debug
 			scf.StorageClass = opts.StorageClass
 			scf.AdditionalNamespaces = opts.AdditionalNamespaces
+			scf.Include = opts.Include
+			scf.Exclude = opts.Exclude
 
 			return &scf, nil
 		}
@@ -250,11 +386,13 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		scf.Eirini = opts.Eirini
-		scf.Timeout = opts.Timeout
+		scf.Timeouts = opts.Timeouts
 		scf.Ingress = opts.Ingress
 		scf.StorageClass = opts.StorageClass
 		scf.Debug = opts.Debug
 		scf.AdditionalNamespaces = opts.AdditionalNamespaces
+		scf.Include = opts.Include
+		scf.Exclude = opts.Exclude
 		return &scf, nil
 	case "kubecf":
 		if opts.ChartURL != "" || opts.QuarksURL != "" { // Return custom version specified
@@ -264,11 +402,20 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				Namespace:            "kubecf",
 				quarksVersion:        opts.QuarksURL,
 				Eirini:               opts.Eirini,
-				Timeout:              opts.Timeout,
+				Timeouts:             opts.Timeouts,
 				Ingress:              opts.Ingress,
 				Debug:                opts.Debug,
 				StorageClass:         opts.StorageClass,
 				AdditionalNamespaces: opts.AdditionalNamespaces,
+				RestoreNamespaces:    opts.RestoreNamespaces,
+				Include:              opts.Include,
+				Exclude:              opts.Exclude,
+				InPlace:              opts.InPlace,
+				SafetyBackup:         opts.SafetyBackup,
+				Consistent:           opts.Consistent,
+				BackupTo:             opts.BackupTo,
+				AutoRollback:         opts.AutoRollback,
+				MultiHop:             opts.MultiHop,
 			}, nil
 		}
 		if len(opts.Version) == 0 { // Get default version if not specified
@@ -277,11 +424,20 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				return nil, err
 			}
 			kubecf.Eirini = opts.Eirini
-			kubecf.Timeout = opts.Timeout
+			kubecf.Timeouts = opts.Timeouts
 			kubecf.Ingress = opts.Ingress
 			kubecf.Debug = opts.Debug
 			kubecf.StorageClass = opts.StorageClass
 			kubecf.AdditionalNamespaces = opts.AdditionalNamespaces
+			kubecf.RestoreNamespaces = opts.RestoreNamespaces
+			kubecf.Include = opts.Include
+			kubecf.Exclude = opts.Exclude
+			kubecf.InPlace = opts.InPlace
+			kubecf.SafetyBackup = opts.SafetyBackup
+			kubecf.Consistent = opts.Consistent
+			kubecf.BackupTo = opts.BackupTo
+			kubecf.AutoRollback = opts.AutoRollback
+			kubecf.MultiHop = opts.MultiHop
 
 			return &kubecf, nil
 		}
@@ -290,11 +446,20 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		kubecf.Eirini = opts.Eirini
-		kubecf.Timeout = opts.Timeout
+		kubecf.Timeouts = opts.Timeouts
 		kubecf.Ingress = opts.Ingress
 		kubecf.StorageClass = opts.StorageClass
 		kubecf.Debug = opts.Debug
 		kubecf.AdditionalNamespaces = opts.AdditionalNamespaces
+		kubecf.RestoreNamespaces = opts.RestoreNamespaces
+		kubecf.Include = opts.Include
+		kubecf.Exclude = opts.Exclude
+		kubecf.InPlace = opts.InPlace
+		kubecf.SafetyBackup = opts.SafetyBackup
+		kubecf.Consistent = opts.Consistent
+		kubecf.BackupTo = opts.BackupTo
+		kubecf.AutoRollback = opts.AutoRollback
+		kubecf.MultiHop = opts.MultiHop
 		return &kubecf, nil
 	case "nginx-ingress":
 		if opts.ChartURL != "" {
@@ -303,6 +468,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				ChartURL:  opts.ChartURL,
 				Namespace: "nginx-ingress",
 				Debug:     opts.Debug,
+				Timeouts:  opts.Timeouts,
 			}
 			return &nginx, nil
 		}
@@ -311,6 +477,8 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			if err != nil {
 				return nil, err
 			}
+			nginx.Debug = opts.Debug
+			nginx.Timeouts = opts.Timeouts
 			return &nginx, nil
 		}
 		nginx, err := c.GetNginx(opts.Version)
@@ -318,6 +486,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		nginx.Debug = opts.Debug
+		nginx.Timeouts = opts.Timeouts
 		return &nginx, nil
 	case "quarks":
 		if opts.ChartURL != "" {
@@ -326,6 +495,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				ChartURL:             opts.ChartURL,
 				Debug:                opts.Debug,
 				AdditionalNamespaces: opts.AdditionalNamespaces,
+				Timeouts:             opts.Timeouts,
 			}
 			return &quarks, nil
 		}
@@ -335,6 +505,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				return nil, err
 			}
 			quarks.Debug = opts.Debug
+			quarks.Timeouts = opts.Timeouts
 			quarks.AdditionalNamespaces = opts.AdditionalNamespaces
 			return &quarks, nil
 		}
@@ -343,6 +514,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		quarks.Debug = opts.Debug
+		quarks.Timeouts = opts.Timeouts
 		quarks.AdditionalNamespaces = opts.AdditionalNamespaces
 		return &quarks, nil
 	case "carrier":
@@ -353,6 +525,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				Debug:            opts.Debug,
 				RegistryUsername: opts.RegistryUsername,
 				RegistryPassword: opts.RegistryPassword,
+				Timeouts:         opts.Timeouts,
 			}
 			return &carrier, nil
 		}
@@ -362,6 +535,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				return nil, err
 			}
 			carrier.Debug = opts.Debug
+			carrier.Timeouts = opts.Timeouts
 			carrier.RegistryUsername = opts.RegistryUsername
 			carrier.RegistryPassword = opts.RegistryPassword
 			return &carrier, nil
@@ -371,6 +545,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		carrier.Debug = opts.Debug
+		carrier.Timeouts = opts.Timeouts
 		carrier.RegistryUsername = opts.RegistryUsername
 		carrier.RegistryPassword = opts.RegistryPassword
 		return &carrier, nil
@@ -381,6 +556,7 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 				ChartURL:  opts.ChartURL,
 				Namespace: "stratos",
 				Debug:     opts.Debug,
+				Timeouts:  opts.Timeouts,
 			}
 			return &stratos, nil
 		}
@@ -389,6 +565,8 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			if err != nil {
 				return nil, err
 			}
+			stratos.Debug = opts.Debug
+			stratos.Timeouts = opts.Timeouts
 			return &stratos, nil
 		}
 		stratos, err := c.GetStratos(opts.Version)
@@ -396,8 +574,9 @@ func (c Catalog) Deployment(name string, opts DeploymentOptions) (kubernetes.Dep
 			return nil, err
 		}
 		stratos.Debug = opts.Debug
+		stratos.Timeouts = opts.Timeouts
 		return &stratos, nil
 	default:
-		return nil, errors.New("Invalid deployment. Run 'kubecfctl list' to show available deployments")
+		return nil, kubernetes.UsageError{Err: errors.New("Invalid deployment. Run 'kubecfctl list' to show available deployments")}
 	}
 }
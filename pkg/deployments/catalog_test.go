@@ -0,0 +1,37 @@
+package deployments_test
+
+import (
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Catalog", func() {
+	It("classifies unknown deployments as usage errors", func() {
+		_, err := GlobalCatalog.Deployment("foo", DeploymentOptions{})
+		Expect(err).To(HaveOccurred())
+		Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassUsage))
+		Expect(kubernetes.ExitCode(err)).To(Equal(kubernetes.ExitUsage))
+	})
+
+	Describe("Upgrade path", func() {
+		It("goes through the supported upgrades", func() {
+			Expect(GlobalCatalog.KubeCFUpgradePath("2.5.8", "2.6.1")).To(Equal([]string{"2.6.1"}))
+		})
+
+		It("is empty for the installed version", func() {
+			Expect(GlobalCatalog.KubeCFUpgradePath("2.6.1", "2.6.1")).To(BeEmpty())
+		})
+
+		It("refuses downgrades and unknown versions", func() {
+			_, err := GlobalCatalog.KubeCFUpgradePath("2.6.1", "2.5.8")
+			Expect(err).To(MatchError(ContainSubstring("is not supported")))
+			_, err = GlobalCatalog.KubeCFUpgradePath("2.2.3", "2.6.1")
+			Expect(err).To(MatchError(ContainSubstring("unknown installed kubecf version")))
+			_, err = GlobalCatalog.KubeCFUpgradePath("2.5.8", "2.7.0")
+			Expect(err).To(MatchError(ContainSubstring("no supported upgrade path from kubecf 2.5.8 to 2.7.0")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassPreflight))
+		})
+	})
+})
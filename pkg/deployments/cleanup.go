@@ -0,0 +1,92 @@
+package deployments
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// orphanOwner returns the helm release creating the resource, if it is one
+// of the resources left behind by failed installs of KubeCF or Quarks
+func orphanOwner(kind string, meta metav1.ObjectMeta) string {
+	if release := meta.Annotations["meta.helm.sh/release-name"]; release == "kubecf" || release == "cf-operator" {
+		return release
+	}
+	switch kind {
+	case kubernetes.KindPodSecurityPolicy:
+		if meta.Name == "kubecf-default" || strings.HasPrefix(meta.Name, "eirini-") {
+			return "kubecf"
+		}
+	case kubernetes.KindClusterRole, kubernetes.KindClusterRoleBinding:
+		if strings.HasPrefix(meta.Name, "eirini-cluster-role") {
+			return "kubecf"
+		}
+	case kubernetes.KindCustomResourceDefinition:
+		if strings.HasSuffix(meta.Name, ".quarks.cloudfoundry.org") {
+			return "cf-operator"
+		}
+	case kubernetes.KindMutatingWebhookConfiguration, kubernetes.KindValidatingWebhookConfiguration:
+		if strings.HasPrefix(meta.Name, "cf-operator") || strings.Contains(meta.Name, "quarks") {
+			return "cf-operator"
+		}
+	}
+	return ""
+}
+
+// cfoSuffix is the suffix prepareAdditionalNamespace appends to the
+// namespace to name its service accounts and role bindings
+var cfoSuffix = regexp.MustCompile(`^cfo[a-z0-9]{5}$`)
+
+// cfoAccount matches the name of the service accounts and role bindings
+// created in namespace by prepareAdditionalNamespace
+func cfoAccount(namespace, name string) bool {
+	return strings.HasPrefix(name, namespace) && cfoSuffix.MatchString(name[len(namespace):])
+}
+
+// Orphans returns the resources left behind by past installs of KubeCF and
+// of the Quarks operator, when they aren't installed anymore
+func Orphans(ctx context.Context, c kubernetes.Cluster) ([]kubernetes.Resource, error) {
+	installed := map[string]bool{}
+	for _, release := range []string{"kubecf", "cf-operator"} {
+		namespaces, err := helmReleaseNamespaces(ctx, release)
+		if err != nil {
+			return nil, err
+		}
+		installed[release] = len(namespaces) != 0
+	}
+
+	orphans, err := c.FindResources(ctx, func(kind string, meta metav1.ObjectMeta) bool {
+		owner := orphanOwner(kind, meta)
+		return owner != "" && !installed[owner]
+	})
+	if err != nil {
+		return nil, err
+	}
+	if installed["cf-operator"] {
+		return orphans, nil
+	}
+
+	accounts, err := c.Kubectl.CoreV1().ServiceAccounts("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing service accounts")
+	}
+	for _, sa := range accounts.Items {
+		if cfoAccount(sa.Namespace, sa.Name) {
+			orphans = append(orphans, kubernetes.Resource{Kind: kubernetes.KindServiceAccount, Namespace: sa.Namespace, Name: sa.Name})
+		}
+	}
+	bindings, err := c.Kubectl.RbacV1().RoleBindings("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing role bindings")
+	}
+	for _, rb := range bindings.Items {
+		if rb.RoleRef.Name == "qjob-persist-output" && cfoAccount(rb.Namespace, rb.Name) {
+			orphans = append(orphans, kubernetes.Resource{Kind: kubernetes.KindRoleBinding, Namespace: rb.Namespace, Name: rb.Name})
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,55 @@
+package deployments_test
+
+import (
+	"context"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var _ = Describe("Orphans", func() {
+	ctx := context.Background()
+	var objects []runtime.Object
+
+	BeforeEach(func() {
+		objects = []runtime.Object{
+			&policyv1beta1.PodSecurityPolicy{ObjectMeta: metav1.ObjectMeta{Name: "kubecf-default"}},
+			&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "eirini-cluster-role"}},
+			&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}},
+			&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "tenantcfoab12c", Namespace: "tenant"}},
+			&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "tenant"}},
+		}
+		runner.Outputs["helm list --all-namespaces --all --filter '^kubecf$'"] = "[]"
+		runner.Outputs["helm list --all-namespaces --all --filter '^cf-operator$'"] = "[]"
+	})
+
+	It("finds the resources of past installs", func() {
+		cluster, _ := newCluster(objects...)
+
+		orphans, err := Orphans(ctx, *cluster)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(ConsistOf(
+			kubernetes.Resource{Kind: kubernetes.KindPodSecurityPolicy, Name: "kubecf-default"},
+			kubernetes.Resource{Kind: kubernetes.KindClusterRole, Name: "eirini-cluster-role"},
+			kubernetes.Resource{Kind: kubernetes.KindServiceAccount, Namespace: "tenant", Name: "tenantcfoab12c"},
+		))
+	})
+
+	It("keeps the resources of installed releases", func() {
+		cluster, _ := newCluster(objects...)
+		runner.Outputs["helm list --all-namespaces --all --filter '^kubecf$'"] = `[{"namespace":"kubecf"}]`
+
+		orphans, err := Orphans(ctx, *cluster)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(ConsistOf(
+			kubernetes.Resource{Kind: kubernetes.KindServiceAccount, Namespace: "tenant", Name: "tenantcfoab12c"},
+		))
+	})
+})
@@ -0,0 +1,213 @@
+package deployments_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDeployments(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Deployments Suite")
+}
+
+// fakePlatform is a platform with a single external IP
+type fakePlatform struct{}
+
+func (fakePlatform) Detect(k8s.Interface) bool { return true }
+func (fakePlatform) Describe() string          { return "fake platform" }
+func (fakePlatform) String() string            { return "fake" }
+func (fakePlatform) Load(k8s.Interface) error  { return nil }
+func (fakePlatform) ExternalIPs() []string     { return []string{"10.0.0.1"} }
+
+// newCluster returns a cluster backed by fake clients holding objects
+func newCluster(objects ...runtime.Object) (*kubernetes.Cluster, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinitionList"}, &unstructured.UnstructuredList{})
+	return kubernetes.NewClusterWithClients(client, dynamicfake.NewSimpleDynamicClient(scheme), fakePlatform{}), client
+}
+
+func namespace(name string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+// runningPod returns a running pod with labels
+func runningPod(namespace, name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// kubecfPods returns the pods KubeCF waits for in namespace
+func kubecfPods(namespace string) []runtime.Object {
+	var pods []runtime.Object
+	for _, s := range []string{"api", "nats", "cc-worker", "doppler"} {
+		pods = append(pods, runningPod(namespace, s+"-0", map[string]string{
+			"quarks.cloudfoundry.org/quarks-statefulset-name": s,
+			"app.kubernetes.io/name":                          "kubecf",
+		}))
+	}
+	return append(pods, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "var-cf-admin-password", Namespace: namespace},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+}
+
+// ccStatefulSets returns the StatefulSets of the Cloud Controller of a
+// KubeCF deployment in namespace, with a replica each
+func ccStatefulSets(namespace string) []runtime.Object {
+	var sets []runtime.Object
+	for _, s := range []string{"api", "cc-worker", "scheduler"} {
+		replicas := int32(1)
+		sets = append(sets, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: s, Namespace: namespace, Labels: map[string]string{
+				"quarks.cloudfoundry.org/quarks-statefulset-name": s,
+			}},
+			Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+		})
+	}
+	return sets
+}
+
+// scalable makes the StatefulSets of client scale like in a cluster:
+// scaling one to zero deletes its pod, scaling it up brings the pod back
+func scalable(client *fake.Clientset) {
+	sts := appsv1.SchemeGroupVersion.WithResource("statefulsets")
+	pods := v1.SchemeGroupVersion.WithResource("pods")
+	client.PrependReactor("get", "statefulsets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		o, err := client.Tracker().Get(sts, a.GetNamespace(), a.(k8stesting.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		set := o.(*appsv1.StatefulSet)
+		return true, &autoscalingv1.Scale{ObjectMeta: set.ObjectMeta, Spec: autoscalingv1.ScaleSpec{Replicas: *set.Spec.Replicas}}, nil
+	})
+	client.PrependReactor("update", "statefulsets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		scale := a.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+		o, err := client.Tracker().Get(sts, a.GetNamespace(), scale.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		set := o.(*appsv1.StatefulSet)
+		set.Spec.Replicas = &scale.Spec.Replicas
+		if err := client.Tracker().Update(sts, set, a.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		pod := runningPod(a.GetNamespace(), set.Name+"-0", set.Labels)
+		if scale.Spec.Replicas == 0 {
+			client.Tracker().Delete(pods, pod.Namespace, pod.Name)
+		} else if _, err := client.Tracker().Get(pods, pod.Namespace, pod.Name); err != nil {
+			client.Tracker().Add(pod)
+		}
+		return true, scale, nil
+	})
+}
+
+// actions returns the verb and resource of the API calls changing the
+// cluster, e.g. "delete clusterroles/eirini-cluster-role"
+func actions(client *fake.Clientset) []string {
+	var result []string
+	for _, a := range client.Actions() {
+		switch a.GetVerb() {
+		case "create":
+			name := ""
+			if o, ok := a.(k8stesting.CreateAction).GetObject().(metav1.Object); ok {
+				name = o.GetName()
+			}
+			result = append(result, "create "+a.GetResource().Resource+"/"+name)
+		case "delete":
+			result = append(result, "delete "+a.GetResource().Resource+"/"+a.(k8stesting.DeleteAction).GetName())
+		}
+	}
+	return result
+}
+
+// fakeExec runs the commands in the pods of a cluster. It records them as
+// "<pod> <command>", and answers them with the output of the longest prefix
+// of Outputs matching
+type fakeExec struct {
+	mu       sync.Mutex
+	Outputs  map[string]string
+	commands []string
+	stdins   map[string]string
+}
+
+func newFakeExec(cluster *kubernetes.Cluster) *fakeExec {
+	f := &fakeExec{Outputs: map[string]string{}, stdins: map[string]string{}}
+	cluster.Executor = f.exec
+	return f
+}
+
+func (f *fakeExec) exec(ctx context.Context, namespace, pod, container, command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := pod + " " + command
+	f.commands = append(f.commands, cmd)
+	if stdin != nil {
+		in, _ := ioutil.ReadAll(stdin)
+		f.stdins[cmd] = string(in)
+	}
+	var out, prefix string
+	for p, o := range f.Outputs {
+		if strings.HasPrefix(cmd, p) && len(p) >= len(prefix) {
+			out, prefix = o, p
+		}
+	}
+	_, err := io.WriteString(stdout, out)
+	return err
+}
+
+// Commands returns the commands run so far
+func (f *fakeExec) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+// Stdin returns the input of the last run of cmd
+func (f *fakeExec) Stdin(cmd string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stdins[cmd]
+}
+
+var runner *helpers.RecordingRunner
+
+var _ = BeforeEach(func() {
+	runner = helpers.NewRecordingRunner()
+	helpers.DefaultRunner = runner
+	helpers.DefaultRetry.Attempts = 3
+	helpers.DefaultRetry.Backoff = time.Millisecond
+})
+
+var _ = AfterEach(func() {
+	helpers.DefaultRunner = helpers.ProcRunner{}
+})
@@ -0,0 +1,142 @@
+package deployments
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+var validDomain = regexp.MustCompile(`^[a-zA-Z0-9.-]+$`)
+
+// domainChange is a change done while rewriting a domain
+type domainChange struct {
+	What  string
+	Count string
+}
+
+// readSystemDomain returns the system domain from the CC config backed up
+// in dir, or an empty string if it can't be read
+func (k KubeCF) readSystemDomain(dir string) string {
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "cc_config.yaml"))
+	if err != nil {
+		return ""
+	}
+	config := ccConfig{}
+	if err := yaml.Unmarshal(dat, &config); err != nil {
+		return ""
+	}
+	return config.SystemDomain
+}
+
+// filterCertificates drops from secrets the certificates issued for the old
+// domain of t, so Quarks generates them again for the new one
+func (k KubeCF) filterCertificates(secrets []v1.Secret, t restoreTarget) []v1.Secret {
+	if t.oldDomain == "" || t.oldDomain == t.domain {
+		return secrets
+	}
+
+	var res []v1.Secret
+	for _, s := range secrets {
+		if certificateForDomain(s.Data["certificate"], t.oldDomain) {
+			helpers.Info("", ":recycle: "+s.Name+" is issued for "+t.oldDomain+", it will be generated again")
+			continue
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func certificateForDomain(dat []byte, domain string) bool {
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	for _, name := range append(cert.DNSNames, cert.Subject.CommonName) {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteDomain replaces oldDomain with domain in the restored databases of
+// namespace: the shared and private domains (and so the routes using them),
+// the service broker URLs and the UAA redirect URIs
+func (k KubeCF) rewriteDomain(ctx context.Context, c kubernetes.Cluster, namespace, oldDomain, domain string, dataSets []string) ([]domainChange, error) {
+	if !validDomain.MatchString(oldDomain) || !validDomain.MatchString(domain) {
+		return nil, kubernetes.UsageError{Err: errors.New("invalid domain " + oldDomain + " or " + domain)}
+	}
+
+	var changes []domainChange
+	if contains(dataSets, DataCCDB) {
+		out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", "mysql -N cloud_controller", fmt.Sprintf(`UPDATE domains SET name = CONCAT(LEFT(name, CHAR_LENGTH(name) - CHAR_LENGTH('%[1]s')), '%[2]s') WHERE name = '%[1]s' OR name LIKE '%%.%[1]s';
+SELECT ROW_COUNT();
+UPDATE service_brokers SET broker_url = REPLACE(broker_url, '.%[1]s', '.%[2]s') WHERE broker_url LIKE '%%.%[1]s%%';
+SELECT ROW_COUNT();
+SELECT COUNT(*) FROM routes JOIN domains ON routes.domain_id = domains.id WHERE domains.name = '%[2]s' OR domains.name LIKE '%%.%[2]s';
+quit;
+`, oldDomain, domain))
+		if err != nil {
+			helpers.Output("", stderr)
+			return nil, errors.Wrap(err, "while rewriting ccdb")
+		}
+		counts := strings.Fields(out)
+		for i, what := range []string{"ccdb domains", "ccdb service broker URLs", "ccdb routes now under " + domain} {
+			count := "?"
+			if i < len(counts) {
+				count = counts[i]
+			}
+			changes = append(changes, domainChange{What: what, Count: count})
+		}
+	}
+
+	if contains(dataSets, DataUAA) {
+		out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", "mysql -N uaa", fmt.Sprintf(`UPDATE oauth_client_details SET web_server_redirect_uri = %[2]s WHERE web_server_redirect_uri LIKE '%%%[1]s%%';
+SELECT ROW_COUNT();
+quit;
+`, oldDomain, rewriteRedirectURIs(oldDomain, domain)))
+		if err != nil {
+			helpers.Output("", stderr)
+			return nil, errors.Wrap(err, "while rewriting uaa")
+		}
+		changes = append(changes, domainChange{What: "uaa client redirect URIs", Count: strings.TrimSpace(out)})
+	}
+
+	return changes, nil
+}
+
+// rewriteRedirectURIs returns the SQL expression of the UAA redirect URIs
+// with oldDomain replaced by domain where it ends a host name: after :// or a
+// dot, and before a slash, a port, the next URI of the list or the end
+func rewriteRedirectURIs(oldDomain, domain string) string {
+	// The comma appended marks the end of the last URI
+	expr := "CONCAT(web_server_redirect_uri, ',')"
+	for _, before := range []string{"://", "."} {
+		for _, after := range []string{"/", ":", ","} {
+			expr = fmt.Sprintf("REPLACE(%s, '%s', '%s')", expr, before+oldDomain+after, before+domain+after)
+		}
+	}
+	return fmt.Sprintf("LEFT(%[1]s, CHAR_LENGTH(%[1]s) - 1)", expr)
+}
+
+func printDomainChanges(namespace string, changes []domainChange) {
+	helpers.Info("", ":pencil2: Domain rewritten in "+namespace+":")
+	for _, c := range changes {
+		helpers.Info("", fmt.Sprintf("   %s: %s", c.What, c.Count))
+	}
+}
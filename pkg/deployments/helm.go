@@ -0,0 +1,287 @@
+package deployments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+)
+
+// helmRelease is a helm release at a given revision
+type helmRelease struct {
+	Name, Namespace string
+	Revision        int
+}
+
+// helmRevision is an entry of the history of a helm release
+type helmRevision struct {
+	Revision    int       `json:"revision"`
+	Updated     time.Time `json:"updated"`
+	Status      string    `json:"status"`
+	Chart       string    `json:"chart"`
+	AppVersion  string    `json:"app_version"`
+	Description string    `json:"description"`
+}
+
+// HelmError is returned when a helm command fails
+type HelmError struct {
+	// Command is the helm command failed, without its arguments, e.g.
+	// "helm install cf-operator"
+	Command string
+	Err     error
+}
+
+func (e HelmError) Error() string { return e.Command + " failed: " + e.Err.Error() }
+func (e HelmError) Cause() error  { return e.Err }
+func (e HelmError) Unwrap() error { return e.Err }
+
+// Class is kubernetes.ClassHelm
+func (HelmError) Class() string { return kubernetes.ClassHelm }
+
+// helmPermanentErrors are fragments of the messages of the helm failures
+// which retrying can't fix, like invalid chart values
+var helmPermanentErrors = []string{
+	"values don't meet the specifications of the schema",
+	"parse error",
+	"template:",
+	"unknown flag",
+	"cannot re-use a name that is still in use",
+	"release: not found",
+	"has no deployed releases",
+	"chart requires kubeVersion",
+}
+
+// transientHelmFailure tells whether helm failed with err, printing out,
+// because of a transient error worth retrying
+func transientHelmFailure(err error, out string) bool {
+	msg := strings.ToLower(out + "\n" + err.Error())
+	for _, p := range helmPermanentErrors {
+		if strings.Contains(msg, strings.ToLower(p)) {
+			return false
+		}
+	}
+	return helpers.IsTransientMessage(msg)
+}
+
+// retryHelm calls run, which runs helm with args, until it succeeds or fails
+// because of an error which isn't transient. The failures are returned as
+// HelmError
+func retryHelm(ctx context.Context, args string, run func() (string, error)) (string, error) {
+	what := strings.Fields(args)
+	if len(what) > 2 {
+		what = what[:2]
+	}
+
+	var out string
+	policy := helpers.DefaultRetry
+	policy.Retryable = func(err error) bool {
+		return transientHelmFailure(err, out)
+	}
+	command := "helm " + strings.Join(what, " ")
+	err := policy.Do(ctx, command, func() (err error) {
+		out, err = run()
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
+		err = HelmError{Command: command, Err: err}
+	}
+	return out, err
+}
+
+// runHelm runs helm with args in dir, retrying it on transient failures.
+// Installs are retried as upgrades, since the failed attempt may have
+// created the release, and helm refuses to install it again
+func runHelm(ctx context.Context, args, dir string, debug bool) (string, error) {
+	attempt := args
+	return retryHelm(ctx, args, func() (string, error) {
+		out, err := helpers.RunProc(ctx, "helm "+attempt, dir, debug)
+		if strings.HasPrefix(attempt, "install ") {
+			attempt = "upgrade --install " + strings.TrimPrefix(attempt, "install ")
+		}
+		return out, err
+	})
+}
+
+// helmOutput runs helm with args, retrying it on transient failures, and
+// returns its standard output only, e.g. the JSON it was asked for
+func helmOutput(ctx context.Context, args string) (string, error) {
+	return retryHelm(ctx, args, func() (string, error) {
+		return helpers.RunProcNoErr(ctx, "helm "+args, "", false)
+	})
+}
+
+// helmHistory returns the revisions of release, the latest last
+func helmHistory(ctx context.Context, release, namespace string) ([]helmRevision, error) {
+	out, err := helmOutput(ctx, "history "+release+" --namespace "+namespace+" --output json")
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading the history of "+release+" in "+namespace)
+	}
+	var history []helmRevision
+	if err := json.Unmarshal([]byte(out), &history); err != nil {
+		return nil, errors.Wrap(err, "while reading the history of "+release+" in "+namespace)
+	}
+	return history, nil
+}
+
+// helmReleaseNamespaces returns the namespaces where release is installed,
+// in any state
+func helmReleaseNamespaces(ctx context.Context, release string) ([]string, error) {
+	out, err := helmOutput(ctx, "list --all-namespaces --all --filter '^"+release+"$' --output json")
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing the releases of "+release)
+	}
+	var releases []struct {
+		Namespace string `json:"namespace"`
+	}
+	if err := json.Unmarshal([]byte(out), &releases); err != nil {
+		return nil, errors.Wrap(err, "while listing the releases of "+release)
+	}
+	var namespaces []string
+	for _, r := range releases {
+		namespaces = append(namespaces, r.Namespace)
+	}
+	return namespaces, nil
+}
+
+var chartVersion = regexp.MustCompile(`-v?(\d.*)$`)
+
+// releaseVersion returns the chart version of release in namespace, or an
+// empty string if it isn't installed
+func releaseVersion(ctx context.Context, release, namespace string) (string, error) {
+	out, err := helmOutput(ctx, "list --namespace "+namespace+" --all --filter '^"+release+"$' --output json")
+	if err != nil {
+		return "", errors.Wrap(err, "while reading the version of "+release+" in "+namespace)
+	}
+	var releases []struct {
+		Chart string `json:"chart"`
+	}
+	if err := json.Unmarshal([]byte(out), &releases); err != nil {
+		return "", errors.Wrap(err, "while reading the version of "+release+" in "+namespace)
+	}
+	if len(releases) == 0 {
+		return "", nil
+	}
+	if m := chartVersion.FindStringSubmatch(releases[0].Chart); m != nil {
+		return m[1], nil
+	}
+	return releases[0].Chart, nil
+}
+
+// currentRelease returns release at its current revision
+func currentRelease(ctx context.Context, release, namespace string) (helmRelease, error) {
+	history, err := helmHistory(ctx, release, namespace)
+	if err != nil {
+		return helmRelease{}, err
+	}
+	if len(history) == 0 {
+		return helmRelease{}, kubernetes.PreflightError{Err: fmt.Errorf("release %s not found in %s", release, namespace)}
+	}
+	return helmRelease{Name: release, Namespace: namespace, Revision: history[len(history)-1].Revision}, nil
+}
+
+// helmRollback rolls r back to its revision
+func helmRollback(ctx context.Context, r helmRelease, wait, debug bool) error {
+	currentdir, _ := os.Getwd()
+	args := "rollback " + r.Name + " " + strconv.Itoa(r.Revision) + " --namespace " + r.Namespace
+	if wait {
+		args += " --wait"
+	}
+	out, err := runHelm(ctx, args, currentdir, debug)
+	if err != nil {
+		helpers.Output("", out)
+		return errors.Wrap(err, "while rolling back "+r.Name+" in "+r.Namespace)
+	}
+	return nil
+}
+
+func printHistory(release, namespace string, history []helmRevision) {
+	helpers.Info("", ":scroll: History of "+release+" in "+namespace+":")
+	for _, h := range history {
+		helpers.Info("", fmt.Sprintf("   %d\t%s\t%s\t%s\t%s", h.Revision, h.Updated.Format(time.ANSIC), h.Status, h.Chart, h.Description))
+	}
+}
+
+// rollbackPlan returns the releases to roll back to bring a component back
+// to the state it had at revision of main (the previous one if 0). The
+// releases in before are upgraded before main, so they are rolled back to
+// their last revision deployed before that revision of main. The ones in
+// after are upgraded after it, so they go back to their last revision
+// deployed before main moved past it. Releases already there are left out
+func rollbackPlan(ctx context.Context, main helmRelease, revision int, before, after []helmRelease) ([]helmRelease, error) {
+	history, err := helmHistory(ctx, main.Name, main.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	printHistory(main.Name, main.Namespace, history)
+
+	if revision == 0 && len(history) > 1 {
+		revision = history[len(history)-2].Revision
+	}
+	target := -1
+	for i, h := range history {
+		if h.Revision == revision {
+			target = i
+		}
+	}
+	if target == -1 || target == len(history)-1 {
+		return nil, kubernetes.PreflightError{Err: fmt.Errorf("no previous revision %d of %s in %s to roll back to", revision, main.Name, main.Namespace)}
+	}
+	deployed, superseded := history[target].Updated, history[target+1].Updated
+
+	// Roll back in the reverse of the upgrade order
+	main.Revision = revision
+	afterPlan, err := rollbackTo(ctx, after, superseded)
+	if err != nil {
+		return nil, err
+	}
+	beforePlan, err := rollbackTo(ctx, before, deployed)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(afterPlan, main), beforePlan...), nil
+}
+
+// rollbackTo returns releases at the last revision they had before until,
+// leaving out the ones which are already there
+func rollbackTo(ctx context.Context, releases []helmRelease, until time.Time) ([]helmRelease, error) {
+	var plan []helmRelease
+	for _, r := range releases {
+		history, err := helmHistory(ctx, r.Name, r.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		printHistory(r.Name, r.Namespace, history)
+
+		r.Revision = 0
+		for _, h := range history {
+			if h.Updated.Before(until) {
+				r.Revision = h.Revision
+			}
+		}
+		if r.Revision == 0 || r.Revision == history[len(history)-1].Revision {
+			continue
+		}
+		plan = append(plan, r)
+	}
+	return plan, nil
+}
+
+// rollbackReleases rolls back the releases of plan in order, waiting with
+// helm for the ones in wait
+func rollbackReleases(ctx context.Context, plan []helmRelease, wait map[string]bool, debug bool) error {
+	for _, r := range plan {
+		helpers.Info("", ":rewind: Rolling back "+r.Name+" in "+r.Namespace+" to revision "+strconv.Itoa(r.Revision))
+		if err := helmRollback(ctx, r, wait[r.Name], debug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
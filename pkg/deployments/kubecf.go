@@ -7,17 +7,20 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/briandowns/spinner"
-	"github.com/kyokomi/emoji"
+	"github.com/hashicorp/go-multierror"
 	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "sigs.k8s.io/yaml"
 )
 
 type KubeCF struct {
@@ -29,13 +32,36 @@ type KubeCF struct {
 	domain        string
 	Debug         bool
 
-	ccdbEncKey, currentKey string
-	encKeys                map[string]string
+	// encryption holds the CCDB encryption keys of each namespace
+	encryption map[string]ccEncryption
+
+	// secrets are restored in each namespace before deploying, so Quarks
+	// doesn't generate new credentials
+	secrets map[string][]v1.Secret
 
 	AdditionalNamespaces []string
+	// RestoreNamespaces selects which namespaces of a backup are restored
+	RestoreNamespaces []string
+	// Include and Exclude select the data sets to backup and restore
+	Include, Exclude []string
+	// InPlace restores into the running deployment instead of redeploying,
+	// after backing it up to SafetyBackup
+	InPlace      bool
+	SafetyBackup string
+	// Consistent stops the Cloud Controller while backing up, so the
+	// databases and the blobstore agree with each other
+	Consistent bool
+	// BackupTo is where the deployment is backed up before upgrading it,
+	// AutoRollback rolls back the helm releases, and the data if it was
+	// migrated, when the upgrade fails
+	BackupTo     string
+	AutoRollback bool
+	// MultiHop walks through the intermediate versions when the installed
+	// one can't be upgraded directly
+	MultiHop bool
 
 	Eirini, Ingress, Autoscaler, LB bool
-	Timeout                         int
+	Timeouts                        kubernetes.Timeouts
 }
 
 func (k *KubeCF) SetDomain(d string) {
@@ -46,71 +72,93 @@ func (k KubeCF) GetDomain() string {
 	return k.domain
 }
 
+func (k KubeCF) GetNamespace() string {
+	return k.Namespace
+}
+
 func (k KubeCF) GetVersion() string {
 	return k.Version
 }
 
+// InstalledVersion returns the version of the kubecf release in the cluster
+func (k KubeCF) InstalledVersion(ctx context.Context, c kubernetes.Cluster) (string, error) {
+	return releaseVersion(ctx, "kubecf", k.Namespace)
+}
+
 func (k KubeCF) Describe() string {
-	return emoji.Sprintf(":cloud: KubeCF version: %s\n:clipboard:Quarks version: %s\n:clipboard:KubeCF chart: %s", k.Version, k.quarksVersion, k.ChartURL)
+	return fmt.Sprintf(":cloud: KubeCF version: %s\n:clipboard:Quarks version: %s\n:clipboard:KubeCF chart: %s", k.Version, k.quarksVersion, k.ChartURL)
 }
 
-func (k KubeCF) Delete(c kubernetes.Cluster) error {
-	currentdir, _ := os.Getwd()
+// eiriniClusterRoles are created by the releases in every namespace even
+// without Eirini, see https://github.com/cloudfoundry-incubator/kubecf/issues/1582
+var eiriniClusterRoles = []kubernetes.Resource{
+	{Kind: kubernetes.KindClusterRoleBinding, Name: "eirini-cluster-rolebinding"},
+	{Kind: kubernetes.KindClusterRole, Name: "eirini-cluster-role"},
+}
+
+func (k KubeCF) Delete(ctx context.Context, c kubernetes.Cluster) error {
+	var result error
 
 	quarks, err := GlobalCatalog.GetQuarks(k.quarksVersion)
 	if err != nil {
 		return err
 	}
-	err = quarks.Delete(c)
-	if err != nil {
-		return err
+	quarks.Timeouts = k.Timeouts
+	if err := quarks.Delete(ctx, c); err != nil {
+		result = multierror.Append(result, err)
 	}
 
-	for _, ns := range k.AdditionalNamespaces {
-		c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), ns, metav1.DeleteOptions{})
-		c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), ns+"-eirini", metav1.DeleteOptions{})
+	var namespaces []string
+	for _, ns := range k.kubecfNamespaces() {
+		namespaces = append(namespaces, ns, ns+"-eirini")
+	}
+	if err := c.DeleteNamespaces(ctx, k.Timeouts.Phase(PhaseDelete), namespaces...); err != nil {
+		result = multierror.Append(result, err)
 	}
 
-	c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace, metav1.DeleteOptions{})
-	c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace+"-eirini", metav1.DeleteOptions{})
-
-	helpers.RunProc("kubectl delete psp kubecf-default", currentdir, k.Debug)
-	// workaround for: https://github.com/cloudfoundry-incubator/kubecf/issues/1582
-	helpers.RunProc("kubectl delete clusterrolebinding eirini-cluster-rolebinding", currentdir, k.Debug)
-	helpers.RunProc("kubectl delete clusterrole eirini-cluster-role", currentdir, k.Debug)
-	emoji.Println(":heavy_check_mark: KubeCF deleted")
+	err = c.DeleteResources(ctx, append([]kubernetes.Resource{
+		{Kind: kubernetes.KindPodSecurityPolicy, Name: "kubecf-default"},
+	}, eiriniClusterRoles...))
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	if result != nil {
+		return kubernetes.Partial(result)
+	}
+	helpers.Success(PhaseKubeCF, ":heavy_check_mark: KubeCF deleted")
 
 	return nil
 }
 
-func (k KubeCF) GetPassword(namespace string, c kubernetes.Cluster) (string, error) {
-	secret, err := c.Kubectl.CoreV1().Secrets(namespace).Get(context.TODO(), "var-cf-admin-password", metav1.GetOptions{})
+// Owned selects the cluster wide resources of KubeCF and of its Quarks
+// operator
+func (k KubeCF) Owned() kubernetes.Owned {
+	o := kubernetes.Owned{Names: []string{"kubecf"}, Prefixes: []string{"kubecf-", "eirini-"}}
+	for _, ns := range k.kubecfNamespaces() {
+		o.Namespaces = append(o.Namespaces, ns, ns+"-eirini")
+	}
+	if quarks, err := GlobalCatalog.GetQuarks(k.quarksVersion); err == nil {
+		q := quarks.Owned()
+		o.Namespaces = append(o.Namespaces, q.Namespaces...)
+		o.Names = append(o.Names, q.Names...)
+		o.Prefixes = append(o.Prefixes, q.Prefixes...)
+		o.Suffixes = append(o.Suffixes, q.Suffixes...)
+	}
+	return o
+}
+
+func (k KubeCF) GetPassword(ctx context.Context, namespace string, c kubernetes.Cluster) (string, error) {
+	secret, err := c.Kubectl.CoreV1().Secrets(namespace).Get(ctx, "var-cf-admin-password", metav1.GetOptions{})
 	if err != nil {
 		return "", errors.Wrap(err, "couldn't find password secret")
 	}
 	return string(secret.Data["password"]), nil
 }
 
-func (k KubeCF) genHelmSettings(c kubernetes.Cluster, domain, ns string) []string {
+func (k KubeCF) genHelmSettings(ctx context.Context, c kubernetes.Cluster, domain, ns string) []string {
 	var helmArgs []string
 	helmArgs = append(helmArgs, "--set system_domain="+domain)
 
-	if len(k.ccdbEncKey) != 0 {
-		helmArgs = append(helmArgs, "--set credentials.cc_db_encryption_key="+k.ccdbEncKey)
-	}
-	if len(k.encKeys) != 0 {
-		i := 0
-		for label, key := range k.encKeys {
-			helmArgs = append(helmArgs, "--set ccdb.encryption.rotation.key_labels["+strconv.Itoa(i)+"]="+label)
-			helmArgs = append(helmArgs, "--set credentials.ccdb_key_label_"+label+"="+key)
-			i++
-		}
-		helmArgs = append(helmArgs, "--set credentials.cc_db_encryption_key="+k.ccdbEncKey)
-	}
-
-	if len(k.currentKey) != 0 {
-		helmArgs = append(helmArgs, "--set ccdb.encryption.rotation.current_key_label="+k.currentKey)
-	}
 	if k.Eirini {
 		helmArgs = append(helmArgs, "--set features.eirini.enabled=true")
 		helmArgs = append(helmArgs, "--set install_stacks[0]=sle15")
@@ -140,6 +188,47 @@ func (k KubeCF) genHelmSettings(c kubernetes.Cluster, domain, ns string) []strin
 	return helmArgs
 }
 
+// encryptionValues writes the CCDB encryption keys of namespace ns to a
+// values file in dir, and returns its path. It returns an empty path when
+// there are no keys to set. The keys are kept out of the helm command line
+func (k KubeCF) encryptionValues(dir, ns string) (string, error) {
+	enc := k.encryption[ns]
+	if len(enc.dbKey) == 0 && len(enc.keys) == 0 && len(enc.currentKey) == 0 {
+		return "", nil
+	}
+
+	credentials := map[string]string{}
+	rotation := map[string]interface{}{}
+	if len(enc.dbKey) != 0 {
+		credentials["cc_db_encryption_key"] = enc.dbKey
+	}
+	if len(enc.keys) != 0 {
+		var labels []string
+		for label, key := range enc.keys {
+			labels = append(labels, label)
+			credentials["ccdb_key_label_"+label] = key
+		}
+		sort.Strings(labels)
+		rotation["key_labels"] = labels
+	}
+	if len(enc.currentKey) != 0 {
+		rotation["current_key_label"] = enc.currentKey
+	}
+
+	values := map[string]interface{}{
+		"credentials": credentials,
+		"ccdb": map[string]interface{}{
+			"encryption": map[string]interface{}{"rotation": rotation},
+		},
+	}
+	dat, err := k8syaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, "encryption-"+ns+".yaml")
+	return file, ioutil.WriteFile(file, dat, 0600)
+}
+
 // db_encryption_key: EzmCLwwF6eV0QxyjrRD4w3QkNaVzQO4echeHyLzNMqoQ8cGiNt2CDpPIxWpYPz8i
 // database_encryption:
 //   keys: {"encryption_key_0":"rMNnJcQ8Gb8DJc9hkEuICJOOgTJrc8lSfMoOCA5sRQIeYsMFfI5XqMvcJhZKFeUZ"}
@@ -151,179 +240,636 @@ type ccEnc struct {
 	Keys    string `yaml:"keys"`
 }
 type ccConfig struct {
-	Encryption ccEnc  `yaml:"database_encryption"`
-	DbKey      string `yaml:"db_encryption_key"`
+	Encryption   ccEnc  `yaml:"database_encryption"`
+	DbKey        string `yaml:"db_encryption_key"`
+	SystemDomain string `yaml:"system_domain"`
+}
+
+// ccEncryption is the CCDB encryption setup of a KubeCF deployment
+type ccEncryption struct {
+	dbKey, currentKey string
+	keys              map[string]string
+}
+
+// secretsBackup is the file holding the Quarks generated secrets
+const secretsBackup = "secrets.yaml"
+
+// backupSecrets stores the Quarks generated secrets (var-*) of namespace,
+// stripped from the metadata bound to the running cluster
+func (k KubeCF) backupSecrets(ctx context.Context, c kubernetes.Cluster, namespace, output string) error {
+	secrets, err := c.Kubectl.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	list := v1.SecretList{}
+	list.APIVersion = "v1"
+	list.Kind = "List"
+	for _, s := range secrets.Items {
+		if !strings.HasPrefix(s.Name, "var-") {
+			continue
+		}
+		list.Items = append(list.Items, v1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        s.Name,
+				Labels:      s.Labels,
+				Annotations: s.Annotations,
+			},
+			Type: s.Type,
+			Data: s.Data,
+		})
+	}
+
+	dat, err := k8syaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(output, secretsBackup), dat, 0600)
+}
+
+// readSecrets reads the secrets stored by backupSecrets. Backups taken
+// before secrets were captured don't have any, and an empty list is returned
+func (k KubeCF) readSecrets(output string) ([]v1.Secret, error) {
+	dat, err := ioutil.ReadFile(filepath.Join(output, secretsBackup))
+	if os.IsNotExist(err) {
+		helpers.Warning(PhaseKubeCF, ":warning: No secrets found in the backup, credentials will be regenerated")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := v1.SecretList{}
+	if err := k8syaml.Unmarshal(dat, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// seedSecrets creates the restored secrets in namespace. Quarks doesn't
+// regenerate secrets which exist already, so the deployment keeps its
+// credentials and certificates
+func (k KubeCF) seedSecrets(ctx context.Context, c kubernetes.Cluster, namespace string) error {
+	for _, s := range k.secrets[namespace] {
+		s.Namespace = namespace
+		_, err := c.Kubectl.CoreV1().Secrets(namespace).Create(ctx, &s, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = c.Kubectl.CoreV1().Secrets(namespace).Update(ctx, &s, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return errors.Wrap(err, "while restoring secret "+s.Name)
+		}
+	}
+	return nil
+}
+
+// tenantNamespaces returns the additional namespaces where KubeCF is
+// deployed. If none were specified, they are detected from the namespaces
+// watched by Quarks which hold a KubeCF deployment
+func (k KubeCF) tenantNamespaces(ctx context.Context, c kubernetes.Cluster) ([]string, error) {
+	if len(k.AdditionalNamespaces) != 0 {
+		return k.AdditionalNamespaces, nil
+	}
+
+	namespaces, err := c.Kubectl.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: "quarks.cloudfoundry.org/monitored=cfo",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, ns := range namespaces.Items {
+		if ns.Name == k.Namespace {
+			continue
+		}
+		if _, err := k.GetPassword(ctx, ns.Name, c); err == nil {
+			res = append(res, ns.Name)
+		}
+	}
+	return res, nil
+}
+
+// restoreTarget maps a namespace of a backup to the one it is restored into
+type restoreTarget struct {
+	source, target string
+	// dir is the backup directory of the source namespace
+	dir string
+	// oldDomain and domain are the system domains of the backup and of the
+	// restored deployment, the data is rewritten if they differ
+	oldDomain, domain string
+}
+
+// restorePlan returns the manifest of the backup in output, along with the
+// data sets and the namespaces selected for restore. Namespaces can be
+// restored with a different name, specifying them as old=new
+func (k KubeCF) restorePlan(output string) (BackupManifest, []string, []restoreTarget, error) {
+	manifest, err := ReadBackupManifest(output, k.Namespace)
+	if err != nil {
+		return manifest, nil, nil, err
+	}
+
+	// Restore everything captured in the backup, unless asked otherwise
+	dataSets, err := SelectDataSets(manifest.DataSets, k.Include, k.Exclude)
+	if err != nil {
+		return manifest, nil, nil, err
+	}
+	if err := manifest.checkRestorable(dataSets); err != nil {
+		return manifest, nil, nil, errors.Wrap(err, "cannot restore the backup")
+	}
+
+	var targets []restoreTarget
+	for _, ns := range k.RestoreNamespaces {
+		t := restoreTarget{source: ns, target: ns}
+		if i := strings.Index(ns, "="); i != -1 {
+			t.source, t.target = ns[:i], ns[i+1:]
+		} else if len(manifest.Namespaces) == 1 && len(k.RestoreNamespaces) == 1 {
+			// A single namespace backup is restored in the given one
+			t.source = manifest.Namespaces[0]
+		}
+		if !manifest.Has(t.source) {
+			return manifest, nil, nil, kubernetes.PreflightError{Err: errors.New("namespace " + t.source + " not found in the backup")}
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		for _, ns := range manifest.Namespaces {
+			targets = append(targets, restoreTarget{source: ns, target: ns})
+		}
+	}
+
+	for i := range targets {
+		targets[i].dir = manifest.Dir(output, targets[i].source)
+		if err := checkDumps(targets[i].dir, dataSets); err != nil {
+			return manifest, nil, nil, err
+		}
+	}
+	return manifest, dataSets, targets, nil
+}
+
+// planDomains sets the system domain of the restored namespaces. If no
+// domain was specified, the one of the primary namespace of the backup is
+// kept, even if that namespace isn't restored
+func (k *KubeCF) planDomains(manifest BackupManifest, output string, dataSets []string, targets []restoreTarget) error {
+	if !contains(dataSets, DataConfig) {
+		return nil
+	}
+	if k.domain == "" {
+		k.domain = k.readSystemDomain(manifest.Dir(output, manifest.Namespace))
+	}
+	if k.domain == "" {
+		return kubernetes.UsageError{Err: errors.New("the system domain of " + manifest.Namespace + " can't be read from the backup, specify it with --domain")}
+	}
+	for i, t := range targets {
+		targets[i].oldDomain = k.readSystemDomain(t.dir)
+		targets[i].domain = k.domain
+		if t.target != k.Namespace {
+			targets[i].domain = t.target + "." + k.domain
+		}
+		if targets[i].oldDomain != "" && targets[i].oldDomain != targets[i].domain {
+			helpers.Info(PhaseKubeCF, ":earth_americas: "+t.target+" domain changes from "+targets[i].oldDomain+" to "+targets[i].domain)
+		}
+	}
+	return nil
 }
 
-func (k KubeCF) Restore(c kubernetes.Cluster, output string) error {
+func (k KubeCF) Restore(ctx context.Context, c kubernetes.Cluster, output string) error {
+	manifest, dataSets, targets, err := k.restorePlan(output)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if t.source == manifest.Namespace {
+			k.Namespace = t.target
+		}
+	}
+	if err := k.planDomains(manifest, output, dataSets, targets); err != nil {
+		return err
+	}
+
+	k.secrets = map[string][]v1.Secret{}
+	if contains(dataSets, DataSecrets) {
+		for _, t := range targets {
+			secrets, err := k.readSecrets(t.dir)
+			if err != nil {
+				return errors.Wrap(err, "while reading secrets")
+			}
+			k.secrets[t.target] = k.filterCertificates(secrets, t)
+		}
+	}
+
+	if k.InPlace {
+		return k.restoreInPlace(ctx, c, dataSets, targets)
+	}
+
+	for _, t := range targets {
+		if t.target != k.Namespace && !contains(k.AdditionalNamespaces, t.target) {
+			k.AdditionalNamespaces = append(k.AdditionalNamespaces, t.target)
+		}
+	}
 
-	err := k.Deploy(c)
+	err = k.Deploy(ctx, c)
 	if err != nil {
 		return errors.Wrap(err, "while deploying kubecf")
 	}
 
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
-	s.Suffix = " Extracting encryption configuration"
+	k.encryption = map[string]ccEncryption{}
+	for _, t := range targets {
+		helpers.Info(PhaseKubeCF, ":floppy_disk:Restoring namespace "+t.target)
+		if err := k.restoreNamespace(ctx, c, t, dataSets); err != nil {
+			return errors.Wrap(err, "while restoring namespace "+t.target)
+		}
+	}
 
+	err = k.upgrade(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "while deploying kubecf")
+	}
+	return nil
+}
+
+// readEncryption reads the CCDB encryption keys from the backed up CC config
+func (k KubeCF) readEncryption(output string) (ccEncryption, error) {
 	dat, err := ioutil.ReadFile(filepath.Join(output, "cc_config.yaml"))
 	if err != nil {
-		return errors.Wrap(err, "while reading cc_config.yaml")
+		return ccEncryption{}, errors.Wrap(err, "while reading cc_config.yaml")
 	}
 	config := ccConfig{}
 	err = yaml.Unmarshal(dat, &config)
 	if err != nil {
-		return errors.Wrap(err, "while unmarshalling cc_config.yaml")
+		return ccEncryption{}, errors.Wrap(err, "while unmarshalling cc_config.yaml")
 	}
 
 	var keys map[string]string
-
-	err = json.Unmarshal([]byte(config.Encryption.Keys), &keys)
-	if err != nil {
-		return errors.Wrap(err, "while unmarshalling encryption keys")
+	if config.Encryption.Keys != "" {
+		err = json.Unmarshal([]byte(config.Encryption.Keys), &keys)
+		if err != nil {
+			return ccEncryption{}, errors.Wrap(err, "while unmarshalling encryption keys")
+		}
+	}
+	helpers.RegisterSecret(config.DbKey)
+	for _, key := range keys {
+		helpers.RegisterSecret(key)
 	}
-	k.encKeys = keys
-	k.ccdbEncKey = config.DbKey
-	k.currentKey = config.Encryption.Current
+	return ccEncryption{
+		dbKey:      config.DbKey,
+		currentKey: config.Encryption.Current,
+		keys:       keys,
+	}, nil
+}
 
-	s.Suffix = " Disable db restrictions"
-	out, stderr, err := c.Exec(k.Namespace, "database-0", "database", "mysql", `SET GLOBAL pxc_strict_mode=PERMISSIVE;
+func (k KubeCF) disableDBRestrictions(ctx context.Context, c kubernetes.Cluster, namespace string) error {
+	out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", "mysql", `SET GLOBAL pxc_strict_mode=PERMISSIVE;
 SET GLOBAL
 sql_mode='STRICT_ALL_TABLES,NO_AUTO_CREATE_USER,NO_ENGINE_SUBSTITUTION';
 set GLOBAL innodb_strict_mode='OFF';
 quit;
 `)
 	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
+		helpers.Output(PhaseKubeCF, out)
+		helpers.Output(PhaseKubeCF, stderr)
 		return errors.Wrap(err, "while disabling db restrictions")
 	}
+	return nil
+}
 
-	dat, err = ioutil.ReadFile(filepath.Join(output, "uaadb-src.sql"))
+// loadDatabase loads the dump in file into the database. If recreate is
+// set, the database is dropped first, once the dump was read
+func (k KubeCF) loadDatabase(ctx context.Context, c kubernetes.Cluster, namespace, database, file string, recreate bool) error {
+	dat, err := ioutil.ReadFile(file)
 	if err != nil {
-		return errors.Wrap(err, "while reading up uaa backup")
-	}
-	s.Suffix = " Restoring UAA"
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql uaa", string(dat))
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up uaa db")
+		return errors.Wrap(err, "while reading up "+database+" backup")
 	}
 
-	s.Suffix = " Restoring Blobstore"
+	if recreate {
+		out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", "mysql", `drop database `+database+`;
+create database	`+database+`;
+quit;
+`)
+		if err != nil {
+			helpers.Output(PhaseKubeCF, out)
+			helpers.Output(PhaseKubeCF, stderr)
+			return errors.Wrap(err, "while pruning "+database+" db")
+		}
+	}
 
-	_, err = helpers.RunProcNoErr("kubectl exec --namespace "+k.Namespace+" singleton-blobstore-0 -- tar xfz - -C < blob.tgz", output, k.Debug)
+	out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", "mysql "+database, string(dat))
 	if err != nil {
+		helpers.Output(PhaseKubeCF, out)
+		helpers.Output(PhaseKubeCF, stderr)
+		return errors.Wrap(err, "while restoring "+database+" db")
+	}
+	return nil
+}
+
+// restoreBlobstore extracts blob.tgz from dir in the blobstore, and restarts it
+func (k KubeCF) restoreBlobstore(ctx context.Context, c kubernetes.Cluster, namespace, dir string) error {
+	if err := extractBlobstore(ctx, c, namespace, "singleton-blobstore-0", dir); err != nil {
 		return errors.Wrap(err, "while restoring up blobstore")
 	}
-	_, err = helpers.RunProcNoErr("kubectl delete pod --namespace "+k.Namespace+" singleton-blobstore-0", output, k.Debug)
-	if err != nil {
+	if err := c.DeletePod(ctx, namespace, "singleton-blobstore-0"); err != nil {
 		return errors.Wrap(err, "while restarting blobstore")
 	}
+	return nil
+}
 
-	s.Suffix = " Restoring CCDB"
+func (k KubeCF) restoreNamespace(ctx context.Context, c kubernetes.Cluster, t restoreTarget, dataSets []string) error {
+	namespace, output := t.target, t.dir
 
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql", `drop database cloud_controller; 
-create database	cloud_controller;
-quit;
-`)
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while pruning cc db")
+	s := helpers.Wait(PhaseKubeCF, "")
+	defer s.Done()
+
+	if contains(dataSets, DataConfig) {
+		s.Update(helpers.Event{Message: "Extracting encryption configuration"})
+		enc, err := k.readEncryption(output)
+		if err != nil {
+			return err
+		}
+		k.encryption[namespace] = enc
 	}
 
-	dat, err = ioutil.ReadFile(filepath.Join(output, "ccdb-src.sql"))
-	if err != nil {
-		return errors.Wrap(err, "while reading up ccdb backup")
+	if contains(dataSets, DataUAA) || contains(dataSets, DataCCDB) || contains(dataSets, DataCredhub) {
+		s.Update(helpers.Event{Message: "Disable db restrictions"})
+		if err := k.disableDBRestrictions(ctx, c, namespace); err != nil {
+			return err
+		}
 	}
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql cloud_controller", string(dat))
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up ccdb db")
+
+	if contains(dataSets, DataUAA) {
+		s.Update(helpers.Event{Message: "Restoring UAA"})
+		if err := k.loadDatabase(ctx, c, namespace, "uaa", dumpFile(output, DataUAA), false); err != nil {
+			return err
+		}
 	}
 
-	err = k.Upgrade(c)
-	if err != nil {
-		return errors.Wrap(err, "while deploying kubecf")
+	if contains(dataSets, DataBlobstore) {
+		s.Update(helpers.Event{Message: "Restoring Blobstore"})
+		if err := k.restoreBlobstore(ctx, c, namespace, output); err != nil {
+			return err
+		}
 	}
-	return nil
 
+	if contains(dataSets, DataCCDB) {
+		s.Update(helpers.Event{Message: "Restoring CCDB"})
+		if err := k.loadDatabase(ctx, c, namespace, "cloud_controller", dumpFile(output, DataCCDB), true); err != nil {
+			return err
+		}
+	}
+
+	if contains(dataSets, DataCredhub) {
+		s.Update(helpers.Event{Message: "Restoring CredHub"})
+		if err := k.loadDatabase(ctx, c, namespace, "credhub", dumpFile(output, DataCredhub), true); err != nil {
+			return err
+		}
+	}
+
+	if t.oldDomain != "" && t.oldDomain != t.domain {
+		s.Update(helpers.Event{Message: "Rewriting domain " + t.oldDomain + " to " + t.domain})
+		changes, err := k.rewriteDomain(ctx, c, namespace, t.oldDomain, t.domain, dataSets)
+		if err != nil {
+			return errors.Wrap(err, "while rewriting domain")
+		}
+		s.Done()
+		printDomainChanges(namespace, changes)
+	}
+
+	return nil
 }
 
-func (k KubeCF) Backup(c kubernetes.Cluster, output string) error {
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
+// restoreInPlace restores the backup into the running deployment: the
+// Cloud Controller is stopped while data is restored, and the encryption
+// keys are applied afterwards with an upgrade. A backup of the current state
+// is taken first, so it can be rolled back
+func (k KubeCF) restoreInPlace(ctx context.Context, c kubernetes.Cluster, dataSets []string, targets []restoreTarget) error {
+	for _, t := range targets {
+		sets, err := c.ListStatefulSets(ctx, t.target, "quarks.cloudfoundry.org/quarks-statefulset-name=api")
+		if err != nil || len(sets.Items) == 0 {
+			return kubernetes.PreflightError{Err: errors.New("no running KubeCF deployment found in namespace " + t.target + ", restore without --in-place")}
+		}
+		if t.target != k.Namespace && !contains(k.AdditionalNamespaces, t.target) {
+			k.AdditionalNamespaces = append(k.AdditionalNamespaces, t.target)
+		}
+	}
+
+	// The restored configuration is applied with an upgrade to the running
+	// version, there's nothing to apply without it
+	config := contains(dataSets, DataConfig)
+	if config {
+		if err := k.pinInstalledVersion(ctx); err != nil {
+			return err
+		}
+	}
 
-	s.Suffix = " Backing up uaa"
-	out, stderr, err := c.Exec(k.Namespace, "database-0", "database", "mysqldump --skip-lock-tables uaa > uaa.sql && cat uaa.sql && rm -rf uaa.sql", "")
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up uaa db")
+	safetyBackup := k.SafetyBackup
+	if safetyBackup == "" {
+		currentdir, _ := os.Getwd()
+		safetyBackup = filepath.Join(currentdir, "kubecfctl-pre-restore-"+time.Now().UTC().Format("20060102150405"))
 	}
-	err = ioutil.WriteFile(filepath.Join(output, "uaadb-src.sql"), []byte(out), 0644)
-	if err != nil {
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up uaa db")
+	helpers.Info(PhaseKubeCF, ":floppy_disk:Backing up current state to "+safetyBackup)
+	safe := k
+	safe.Include, safe.Exclude = nil, nil
+	if err := os.MkdirAll(safetyBackup, os.ModePerm); err != nil {
+		return err
+	}
+	if err := safe.Backup(ctx, c, safetyBackup); err != nil {
+		return errors.Wrap(err, "while taking the pre-restore backup")
+	}
+
+	k.encryption = map[string]ccEncryption{}
+	for _, t := range targets {
+		helpers.Info(PhaseKubeCF, ":floppy_disk:Restoring namespace "+t.target)
+		if err := k.restoreNamespaceInPlace(ctx, c, t, dataSets); err != nil {
+			return errors.Wrap(err, "while restoring namespace "+t.target+", the previous state is saved in "+safetyBackup)
+		}
 	}
 
-	s.Suffix = " Backing up ccdb"
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysqldump --max_allowed_packet=1G --single-transaction --quick --lock-tables=false cloud_controller > cc.sql; cat cc.sql && rm -rf cc.sql", "")
+	if !config {
+		helpers.Info(PhaseKubeCF, ":information_source: Configuration not restored, keeping the running release as it is")
+		return nil
+	}
+	err := k.upgrade(ctx, c)
 	if err != nil {
-		fmt.Println(out)
+		return errors.Wrap(err, "while applying the restored configuration, the previous state is saved in "+safetyBackup)
+	}
+	return nil
+}
 
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up ccdb db")
+// pinInstalledVersion sets the chart of k to the one of the running release,
+// so applying a restored configuration doesn't upgrade KubeCF behind the
+// back of the upgrade path checks
+func (k *KubeCF) pinInstalledVersion(ctx context.Context) error {
+	installed, err := k.installedVersion(ctx)
+	if err != nil {
+		return err
 	}
-	err = ioutil.WriteFile(filepath.Join(output, "ccdb-src.sql"), []byte(out), 0644)
+	running, err := GlobalCatalog.GetKubeCF(installed)
 	if err != nil {
-		return errors.Wrap(err, "while backing up ccdb db")
+		return kubernetes.PreflightError{Err: errors.New("kubecf " + installed + " running in namespace " + k.Namespace + " is not in the catalog, restore without --in-place")}
+	}
+	k.Version, k.ChartURL, k.quarksVersion = running.Version, running.ChartURL, running.quarksVersion
+	return nil
+}
+
+// restoreNamespaceInPlace restores namespace with the Cloud Controller stopped
+func (k KubeCF) restoreNamespaceInPlace(ctx context.Context, c kubernetes.Cluster, t restoreTarget, dataSets []string) error {
+	if len(k.secrets[t.target]) != 0 {
+		helpers.Info(PhaseKubeCF, ":key:Restoring secrets for "+t.target)
+		if err := k.seedSecrets(ctx, c, t.target); err != nil {
+			return err
+		}
 	}
 
-	s.Suffix = " Backing up blobstore"
-	_, err = helpers.RunProcNoErr("kubectl exec --namespace "+k.Namespace+" singleton-blobstore-0 -- tar cfz - --exclude=/var/vcap/store/shared/tmp /var/vcap/store/shared > blob.tgz", output, k.Debug)
+	return whileQuiesced(ctx, c, t.target, k.Timeouts.Phase(PhaseQuiesce), func() error {
+		return k.restoreNamespace(ctx, c, t, dataSets)
+	})
+}
+
+func (k KubeCF) Backup(ctx context.Context, c kubernetes.Cluster, output string) error {
+	tenants, err := k.tenantNamespaces(ctx, c)
 	if err != nil {
-		return errors.Wrap(err, "while backing up blobstore")
+		return errors.Wrap(err, "while detecting tenant namespaces")
 	}
 
-	s.Suffix = " Disable db restrictions"
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql", `SET GLOBAL pxc_strict_mode=PERMISSIVE;
-SET GLOBAL
-sql_mode='STRICT_ALL_TABLES,NO_AUTO_CREATE_USER,NO_ENGINE_SUBSTITUTION';
-set GLOBAL innodb_strict_mode='OFF';
-quit;
-`)
+	dataSets, err := SelectDataSets(DataSets, k.Include, k.Exclude)
 	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while disabling db restrictions")
+		return err
+	}
+
+	// CredHub is optional in KubeCF, skip it unless explicitly requested
+	if contains(dataSets, DataCredhub) && !contains(k.Include, DataCredhub) {
+		out, _, err := c.Exec(ctx, k.Namespace, "database-0", "database", "mysql -N -e \"SHOW DATABASES LIKE 'credhub'\"", "")
+		if err != nil || !strings.Contains(out, "credhub") {
+			helpers.Warning(PhaseKubeCF, ":warning: CredHub database not found, skipping it")
+			dataSets, _ = SelectDataSets(dataSets, nil, []string{DataCredhub})
+		}
+	}
+
+	manifest := BackupManifest{
+		Deployment: "kubecf",
+		Version:    k.Version,
+		Date:       time.Now().UTC(),
+		Namespace:  k.Namespace,
+		Namespaces: append([]string{k.Namespace}, tenants...),
+		DataSets:   dataSets,
+	}
+
+	for _, ns := range manifest.Namespaces {
+		dir := manifest.Dir(output, ns)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		if err := recoverCC(ctx, c, ns); err != nil {
+			return errors.Wrap(err, "while resuming cloud controller")
+		}
+
+		helpers.Info(PhaseKubeCF, ":floppy_disk:Backing up namespace "+ns)
+		// The config is read from the API pod, which is stopped while the
+		// Cloud Controller is quiesced
+		if contains(dataSets, DataConfig) {
+			if err := k.backupCCConfig(ctx, c, ns, dir); err != nil {
+				return errors.Wrap(err, "while backing up namespace "+ns)
+			}
+		}
+		backup := func() error { return k.backupNamespace(ctx, c, ns, dir, dataSets) }
+		if k.Consistent {
+			err = whileQuiesced(ctx, c, ns, k.Timeouts.Phase(PhaseQuiesce), backup)
+		} else {
+			err = backup()
+		}
+		if err != nil {
+			return errors.Wrap(err, "while backing up namespace "+ns)
+		}
+	}
+
+	return manifest.Write(output)
+}
+
+func (k KubeCF) backupNamespace(ctx context.Context, c kubernetes.Cluster, namespace, output string, dataSets []string) error {
+	s := helpers.Wait(PhaseKubeCF, "")
+	defer s.Done()
+
+	if contains(dataSets, DataUAA) {
+		s.Update(helpers.Event{Message: "Backing up uaa"})
+		if err := k.dumpDatabase(ctx, c, namespace, "uaa", "mysqldump --skip-lock-tables uaa", dumpFile(output, DataUAA)); err != nil {
+			return err
+		}
+	}
+	if contains(dataSets, DataCCDB) {
+		s.Update(helpers.Event{Message: "Backing up ccdb"})
+		if err := k.dumpDatabase(ctx, c, namespace, "ccdb", "mysqldump --max_allowed_packet=1G --single-transaction --quick --lock-tables=false cloud_controller", dumpFile(output, DataCCDB)); err != nil {
+			return err
+		}
+	}
+	if contains(dataSets, DataCredhub) {
+		s.Update(helpers.Event{Message: "Backing up credhub"})
+		if err := k.dumpDatabase(ctx, c, namespace, "credhub", "mysqldump --single-transaction --quick --lock-tables=false credhub", dumpFile(output, DataCredhub)); err != nil {
+			return err
+		}
+	}
+
+	if contains(dataSets, DataBlobstore) {
+		s.Update(helpers.Event{Message: "Backing up blobstore"})
+		if err := archiveBlobstore(ctx, c, namespace, "singleton-blobstore-0", output); err != nil {
+			return errors.Wrap(err, "while backing up blobstore")
+		}
+	}
+
+	if contains(dataSets, DataUAA) || contains(dataSets, DataCCDB) || contains(dataSets, DataCredhub) {
+		s.Update(helpers.Event{Message: "Disable db restrictions"})
+		if err := k.disableDBRestrictions(ctx, c, namespace); err != nil {
+			return err
+		}
+	}
+
+	if contains(dataSets, DataSecrets) {
+		s.Update(helpers.Event{Message: "Backing up secrets"})
+		if err := k.backupSecrets(ctx, c, namespace, output); err != nil {
+			return errors.Wrap(err, "while backing up secrets")
+		}
 	}
 
-	s.Suffix = " Backing up cloud_controller_ng.yml"
-	out, stderr, err = c.Exec(k.Namespace, "api-0", "cloud-controller-ng-cloud-controller-ng", "cat /var/vcap/jobs/cloud_controller_ng/config/cloud_controller_ng.yml", "")
+	return nil
+}
+
+// backupCCConfig stores the configuration of the Cloud Controller of
+// namespace in output
+func (k KubeCF) backupCCConfig(ctx context.Context, c kubernetes.Cluster, namespace, output string) error {
+	s := helpers.Wait(PhaseKubeCF, "Backing up cloud_controller_ng.yml")
+	defer s.Done()
+
+	out, stderr, err := c.Exec(ctx, namespace, "api-0", "cloud-controller-ng-cloud-controller-ng", "cat /var/vcap/jobs/cloud_controller_ng/config/cloud_controller_ng.yml", "")
 	if err != nil {
-		fmt.Println(stderr)
+		helpers.Output(PhaseKubeCF, stderr)
 		return errors.Wrap(err, "while backing up cc config")
 	}
 	err = ioutil.WriteFile(filepath.Join(output, "cc_config.yaml"), []byte(out), 0644)
 	if err != nil {
-		fmt.Println(stderr)
 		return errors.Wrap(err, "while backing up cc config")
 	}
+	return nil
+}
 
+// dumpDatabase runs dump in the database pod and stores its output in file
+func (k KubeCF) dumpDatabase(ctx context.Context, c kubernetes.Cluster, namespace, name, dump, file string) error {
+	out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", dump+" > "+name+".sql && cat "+name+".sql && rm -rf "+name+".sql", "")
+	if err != nil {
+		helpers.Output(PhaseKubeCF, out)
+		helpers.Output(PhaseKubeCF, stderr)
+		return errors.Wrap(err, "while backing up "+name+" db")
+	}
+	err = ioutil.WriteFile(file, []byte(out), 0644)
+	if err != nil {
+		return errors.Wrap(err, "while backing up "+name+" db")
+	}
 	return nil
 }
 
-func (k KubeCF) applyKubeCF(namespace, domain string, c kubernetes.Cluster, upgrade, psp bool) error {
+func (k KubeCF) applyKubeCF(ctx context.Context, namespace, domain string, c kubernetes.Cluster, upgrade, psp bool) error {
 	currentdir, _ := os.Getwd()
 
 	// Setup KubeCF helm values
-	helmArgs := k.genHelmSettings(c, domain, namespace)
+	helmArgs := k.genHelmSettings(ctx, c, domain, namespace)
 
 	action := "install"
 	if upgrade {
@@ -333,156 +879,255 @@ func (k KubeCF) applyKubeCF(namespace, domain string, c kubernetes.Cluster, upgr
 		helmArgs = append(helmArgs, "--set kube.psp.default=kubecf-default")
 	}
 
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	out, err := helpers.RunProc("helm "+action+" kubecf --namespace "+namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug)
+	dir, err := ioutil.TempDir(os.TempDir(), "kubecfctl")
 	if err != nil {
-		fmt.Println(string(out))
-		return errors.New("Failed installing kubecf")
+		return err
+	}
+	defer os.RemoveAll(dir)
+	values, err := k.encryptionValues(dir, namespace)
+	if err != nil {
+		return errors.Wrap(err, "while writing encryption values")
+	}
+	if values != "" {
+		helmArgs = append(helmArgs, "-f "+values)
 	}
 
-	s.Stop()
+	s := helpers.Wait(PhaseKubeCF, "")
+	out, err := runHelm(ctx, action+" kubecf --namespace "+namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug)
+	s.Done()
+	if err != nil {
+		helpers.Output(PhaseKubeCF, out)
+		return errors.Wrap(err, "Failed installing kubecf")
+	}
 	// Wait for components to be up
 	for _, s := range []string{"api", "nats", "cc-worker", "doppler"} {
-		err = c.WaitUntilPodBySelectorExist(namespace, "quarks.cloudfoundry.org/quarks-statefulset-name="+s, k.Timeout)
+		err = c.WaitUntilPodBySelectorExist(ctx, namespace, "quarks.cloudfoundry.org/quarks-statefulset-name="+s, k.Timeouts.Phase(PhaseKubeCF))
 		if err != nil {
 			return errors.Wrap(err, "Failed waiting for api")
 		}
 	}
 
-	err = c.WaitForPodBySelectorRunning(namespace, "app.kubernetes.io/name=kubecf", k.Timeout)
+	err = c.WaitForPodBySelectorRunning(ctx, namespace, "app.kubernetes.io/name=kubecf", k.Timeouts.Phase(PhaseKubeCF))
 	if err != nil {
 		return errors.Wrap(err, "failed waiting for kubecf to be ready")
 	}
-	emoji.Println(":heavy_check_mark: KubeCF deployed correctly to the :rainbow: :cloud:")
+	helpers.Success(PhaseKubeCF, ":heavy_check_mark: KubeCF deployed correctly to the :rainbow: :cloud:")
 	return nil
 }
 
-func (k KubeCF) Deploy(c kubernetes.Cluster) error {
-	currentdir, _ := os.Getwd()
+func (k KubeCF) Component() string {
+	return "kubecf"
+}
+
+// Phases returns the steps of a KubeCF install: Quarks, the ingress
+// controller, KubeCF in each namespace and the post-install cleanup
+func (k KubeCF) Phases(c kubernetes.Cluster) []kubernetes.Phase {
+	phases := []kubernetes.Phase{
+		{Name: "quarks", Run: func(ctx context.Context) error { return k.deployQuarks(ctx, c) }},
+	}
+	if k.Ingress {
+		phases = append(phases, kubernetes.Phase{Name: "ingress", Run: func(ctx context.Context) error { return k.deployIngress(ctx, c) }})
+	}
+	for _, ns := range k.kubecfNamespaces() {
+		ns := ns
+		phases = append(phases, kubernetes.Phase{Name: "kubecf/" + ns, Run: func(ctx context.Context) error { return k.deployNamespace(ctx, c, ns) }})
+	}
+	return append(phases, kubernetes.Phase{Name: "post-install", Run: func(ctx context.Context) error { return k.postInstall(ctx, c) }})
+}
+
+func (k KubeCF) Deploy(ctx context.Context, c kubernetes.Cluster) error {
+	for _, p := range k.Phases(c) {
+		if err := p.Run(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		"cf-operator",
-		metav1.GetOptions{},
-	)
+func (k KubeCF) deployQuarks(ctx context.Context, c kubernetes.Cluster) error {
+	exists, err := c.NamespaceExists(ctx, "cf-operator")
 	if err != nil {
+		return err
+	}
+	if !exists {
 		quarks, err := GlobalCatalog.GetQuarks(k.quarksVersion)
 		if err != nil {
 			return err
 		}
+		quarks.Timeouts = k.Timeouts
 
+		quarks.Namespace = k.Namespace
 		quarks.AdditionalNamespaces = k.AdditionalNamespaces
-		err = quarks.Deploy(c)
+		return quarks.Deploy(ctx, c)
+	}
+	helpers.Info(PhaseKubeCF, ":ship:Quarks operator already present. Delete if you want to test cleanly")
+	return nil
+}
+
+func (k KubeCF) deployIngress(ctx context.Context, c kubernetes.Cluster) error {
+	exists, err := c.NamespaceExists(ctx, "nginx-ingress")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		nginx, err := GlobalCatalog.GetNginx("3.7.1")
 		if err != nil {
 			return err
 		}
-	} else {
-		emoji.Println(":ship:Quarks operator already present. Delete if you want to test cleanly")
+		nginx.Timeouts = k.Timeouts
+
+		return nginx.Deploy(ctx, c)
 	}
+	helpers.Info(PhaseKubeCF, ":ship:Nginx already present. Delete if you want to test cleanly")
+	return nil
+}
 
-	if k.Ingress {
-		_, err = c.Kubectl.CoreV1().Namespaces().Get(
-			context.Background(),
-			"nginx-ingress",
-			metav1.GetOptions{},
-		)
-		if err != nil {
-			nginx, err := GlobalCatalog.GetNginx("3.7.1")
-			if err != nil {
-				return err
-			}
+// releaseInstalled returns true if the KubeCF release of namespace was
+// installed, e.g. by an interrupted install. A release left pending by an
+// interrupted helm install is removed, so it can be installed again
+func (k KubeCF) releaseInstalled(ctx context.Context, namespace string) (bool, error) {
+	history, err := helmHistory(ctx, "kubecf", namespace)
+	if err != nil || len(history) == 0 {
+		// helm history fails if there is no release
+		return false, nil
+	}
+	if history[len(history)-1].Status != "pending-install" {
+		return true, nil
+	}
+	helpers.Info(PhaseKubeCF, ":broom: Removing the kubecf release left pending in "+namespace)
+	currentdir, _ := os.Getwd()
+	if out, err := runHelm(ctx, "uninstall kubecf --namespace "+namespace, currentdir, k.Debug); err != nil {
+		helpers.Output(PhaseKubeCF, out)
+		return false, errors.Wrap(err, "while removing pending kubecf release")
+	}
+	return false, nil
+}
 
-			err = nginx.Deploy(c)
-			if err != nil {
-				return err
-			}
-		} else {
-			emoji.Println(":ship:Nginx already present. Delete if you want to test cleanly")
+// deployNamespace installs KubeCF in namespace, or upgrades the release an
+// interrupted install left behind
+func (k KubeCF) deployNamespace(ctx context.Context, c kubernetes.Cluster, ns string) error {
+	primary := ns == k.Namespace
+
+	if !primary && k.Eirini {
+		// The cluster wide resources of Eirini are created again by the
+		// release of each namespace
+		var resources []kubernetes.Resource
+		for _, psp := range []string{
+			"bits-service", "eirini",
+			"eirini-events", "eirini-metrics",
+			"eirini-routing", "eirini-staging-reporter", "kubecf-eirini-app-psp",
+		} {
+			resources = append(resources, kubernetes.Resource{Kind: kubernetes.KindPodSecurityPolicy, Name: psp})
+		}
+		resources = append(resources, eiriniClusterRoles...)
+		resources = append(resources, kubernetes.Resource{Kind: kubernetes.KindClusterRole, Name: "eirini-nodes-policy"})
+		if err := c.DeleteResources(ctx, resources); err != nil {
+			return err
 		}
 	}
 
-	emoji.Println(":ship:Deploying kubecf")
-
-	if err := k.applyKubeCF(k.Namespace, k.domain, c, false, true); err != nil {
-		return errors.Wrap(err, "while deploying kubecf")
+	if len(k.secrets[ns]) != 0 {
+		helpers.Info(PhaseKubeCF, ":key:Restoring secrets for "+ns)
+		if err := k.seedSecrets(ctx, c, ns); err != nil {
+			return err
+		}
 	}
 
-	pwd, err := k.GetPassword(k.Namespace, c)
+	installed, err := k.releaseInstalled(ctx, ns)
 	if err != nil {
-		return errors.Wrap(err, "couldn't find password")
+		return err
 	}
+	domain := k.domain
+	if !primary {
+		domain = ns + "." + k.domain
+	}
+	helpers.Info(PhaseKubeCF, ":ship:Deploying kubecf in "+ns)
+	if err := k.applyKubeCF(ctx, ns, domain, c, installed, primary); err != nil {
+		return errors.Wrap(err, "while deploying kubecf for namespace "+ns)
+	}
+
 	// workaround for: https://github.com/cloudfoundry-incubator/kubecf/issues/1582
 	if !k.Eirini {
-		helpers.RunProc("kubectl delete clusterrolebinding eirini-cluster-rolebinding", currentdir, k.Debug)
-		helpers.RunProc("kubectl delete clusterrole eirini-cluster-role", currentdir, k.Debug)
+		if err := c.DeleteResources(ctx, eiriniClusterRoles); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// postInstall prints how to login to each namespace
+func (k KubeCF) postInstall(ctx context.Context, c kubernetes.Cluster) error {
 	for _, ns := range k.AdditionalNamespaces {
+		pwd, err := k.GetPassword(ctx, ns, c)
+		if err != nil {
+			return errors.Wrap(err, "couldn't find password")
+		}
+		helpers.Info(PhaseKubeCF, ":lock: "+ns+" CF Deployment ready, now you can login with: cf login --skip-ssl-validation -a https://api."+ns+"."+k.domain+" -u admin -p "+string(pwd))
+	}
 
-		if k.Eirini {
-			for _, psp := range []string{
-				"bits-service", "eirini",
-				"eirini-events", "eirini-metrics",
-				"eirini-routing", "eirini-staging-reporter", "kubecf-eirini-app-psp",
-			} {
-				helpers.RunProc("kubectl delete psp "+psp, currentdir, k.Debug)
+	pwd, err := k.GetPassword(ctx, k.Namespace, c)
+	if err != nil {
+		return errors.Wrap(err, "couldn't find password")
+	}
+	helpers.Info(PhaseKubeCF, ":lock:CF Deployment ready, now you can login with: cf login --skip-ssl-validation -a https://api."+k.domain+" -u admin -p "+string(pwd))
+	return nil
+}
 
-			}
-			helpers.RunProc("kubectl delete clusterrole eirini-nodes-policy", currentdir, k.Debug)
+func (k KubeCF) Upgrade(ctx context.Context, c kubernetes.Cluster) error {
+	path, err := k.upgradePath(ctx)
+	if err != nil {
+		return err
+	}
 
-			helpers.RunProc("kubectl delete clusterrolebinding eirini-cluster-rolebinding", currentdir, k.Debug)
-			helpers.RunProc("kubectl delete clusterrole eirini-cluster-role", currentdir, k.Debug)
+	for i, step := range path {
+		if len(path) > 1 {
+			helpers.Info(PhaseKubeCF, ":arrow_up: Upgrading to "+step.Version+" (step "+strconv.Itoa(i+1)+" of "+strconv.Itoa(len(path))+")")
 		}
-
-		if err := k.applyKubeCF(ns, ns+"."+k.domain, c, false, false); err != nil {
-			return errors.Wrap(err, "while deploying kubecf for namespace "+ns)
+		// The backup taken before the first step covers the whole upgrade
+		if i > 0 {
+			step.BackupTo = ""
 		}
-		pwd, err := k.GetPassword(ns, c)
-		if err != nil {
-			return errors.Wrap(err, "couldn't find password")
+		if step.BackupTo != "" || step.AutoRollback {
+			err = step.guardedUpgrade(ctx, c)
+		} else {
+			err = step.upgrade(ctx, c)
 		}
-		// workaround for: https://github.com/cloudfoundry-incubator/kubecf/issues/1582
-		if !k.Eirini {
-			helpers.RunProc("kubectl delete clusterrolebinding eirini-cluster-rolebinding", currentdir, k.Debug)
-			helpers.RunProc("kubectl delete clusterrole eirini-cluster-role", currentdir, k.Debug)
+		if err != nil {
+			return errors.Wrap(err, "while upgrading to "+step.Version)
 		}
-
-		emoji.Println(":lock: " + ns + " CF Deployment ready, now you can login with: cf login --skip-ssl-validation -a https://api." + ns + "." + k.domain + " -u admin -p " + string(pwd))
 	}
-
-	emoji.Println(":lock:CF Deployment ready, now you can login with: cf login --skip-ssl-validation -a https://api." + k.domain + " -u admin -p " + string(pwd))
 	return nil
 }
 
-func (k KubeCF) Upgrade(c kubernetes.Cluster) error {
-	emoji.Println(":ship:Upgrading Quarks Operator")
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		"cf-operator",
-		metav1.GetOptions{},
-	)
+func (k KubeCF) upgrade(ctx context.Context, c kubernetes.Cluster) error {
+	helpers.Info(PhaseKubeCF, ":ship:Upgrading Quarks Operator")
+	exists, err := c.NamespaceExists(ctx, "cf-operator")
 	if err != nil {
-		return errors.New("Namespace 'cf-operator' not present")
+		return err
+	}
+	if !exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace 'cf-operator' not present")}
 	}
 
 	quarks, err := GlobalCatalog.GetQuarks(k.quarksVersion)
 	if err != nil {
 		return err
 	}
+	quarks.Timeouts = k.Timeouts
+	quarks.Namespace = k.Namespace
 	quarks.AdditionalNamespaces = k.AdditionalNamespaces
-	err = quarks.Upgrade(c)
+	err = quarks.Upgrade(ctx, c)
 	if err != nil {
 		return err
 	}
-	emoji.Println(":ship:Upgrading kubecf")
+	helpers.Info(PhaseKubeCF, ":ship:Upgrading kubecf")
 
-	if err := k.applyKubeCF(k.Namespace, k.domain, c, true, true); err != nil {
+	if err := k.applyKubeCF(ctx, k.Namespace, k.domain, c, true, true); err != nil {
 		return errors.Wrap(err, "while upgrading kubecf")
 	}
 
 	for _, ns := range k.AdditionalNamespaces {
-		if err := k.applyKubeCF(ns, ns+"."+k.domain, c, true, false); err != nil {
+		if err := k.applyKubeCF(ctx, ns, ns+"."+k.domain, c, true, false); err != nil {
 			return errors.Wrap(err, "while upgrading kubecf for namespace "+ns)
 		}
 	}
@@ -0,0 +1,427 @@
+package deployments_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const kubecfChart = "https://github.com/cloudfoundry-incubator/kubecf/releases/download/v2.6.1/kubecf-v2.6.1.tgz"
+
+var _ = Describe("KubeCF", func() {
+	ctx := context.Background()
+
+	Describe("Deploy", func() {
+		It("installs Quarks and KubeCF with the platform addresses", func() {
+			objects := append(kubecfPods("kubecf"), runningPod("cf-operator", "cf-operator-0", nil))
+			cluster, client := newCluster(objects...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			Expect(kubecf.Deploy(ctx, *cluster)).To(Succeed())
+
+			Expect(runner.Commands()).To(Equal([]string{
+				"helm install cf-operator --create-namespace --namespace cf-operator --wait https://s3.amazonaws.com/cf-operators/release/helm-charts/cf-operator-6.1.17%2B0.gec409fd7.tgz --set global.singleNamespace.name=kubecf",
+				"helm history kubecf --namespace kubecf --output json",
+				"helm install kubecf --namespace kubecf " + kubecfChart + " --set system_domain=example.com" +
+					" --set services.router.type=LoadBalancer --set services.router.externalIPs[0]=10.0.0.1" +
+					" --set services.tcp-router.type=LoadBalancer --set services.tcp-router.externalIPs[0]=10.0.0.1" +
+					" --set services.ssh-proxy.type=LoadBalancer --set services.ssh-proxy.externalIPs[0]=10.0.0.1",
+			}))
+			// workaround for https://github.com/cloudfoundry-incubator/kubecf/issues/1582
+			Expect(actions(client)).To(ContainElements(
+				"delete clusterrolebindings/eirini-cluster-rolebinding",
+				"delete clusterroles/eirini-cluster-role",
+			))
+		})
+
+		It("enables eirini and the ingress", func() {
+			objects := append(kubecfPods("kubecf"),
+				runningPod("cf-operator", "cf-operator-0", nil),
+				runningPod("nginx-ingress", "nginx-ingress-0", nil))
+			cluster, _ := newCluster(objects...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}, Eirini: true, Ingress: true})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			Expect(kubecf.Deploy(ctx, *cluster)).To(Succeed())
+
+			commands := runner.Commands()
+			Expect(commands).To(HaveLen(4))
+			Expect(commands[1]).To(HavePrefix("helm install nginx-ingress --create-namespace --wait --namespace nginx-ingress "))
+			Expect(commands[3]).To(HavePrefix("helm install kubecf --namespace kubecf " + kubecfChart + " --set system_domain=example.com"))
+			Expect(commands[3]).To(ContainSubstring("--set features.eirini.enabled=true"))
+			Expect(commands[3]).To(ContainSubstring("--set eirini.opi.namespace=kubecf-eirini"))
+			Expect(commands[3]).To(HaveSuffix("--set features.ingress.enabled=true"))
+		})
+
+		It("upgrades the release left behind by an interrupted install", func() {
+			objects := append(kubecfPods("kubecf"), namespace("cf-operator"))
+			cluster, _ := newCluster(objects...)
+			runner.Outputs["helm history kubecf --namespace kubecf"] = `[{"revision":1,"status":"failed","chart":"kubecf-v2.6.1"}]`
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			Expect(kubecf.Deploy(ctx, *cluster)).To(Succeed())
+
+			commands := runner.Commands()
+			Expect(commands).To(HaveLen(2))
+			Expect(commands[1]).To(HavePrefix("helm upgrade kubecf --namespace kubecf "))
+		})
+
+		It("fails when KubeCF doesn't come up", func() {
+			cluster, _ := newCluster(runningPod("cf-operator", "cf-operator-0", nil))
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			Expect(kubecf.Deploy(ctx, *cluster)).To(MatchError(ContainSubstring("while deploying kubecf for namespace kubecf")))
+		})
+
+		It("names the phase and the pods not ready when the wait times out", func() {
+			objects := append(kubecfPods("kubecf"), runningPod("cf-operator", "cf-operator-0", nil))
+			objects[3].(*v1.Pod).Status = v1.PodStatus{
+				Phase: v1.PodPending,
+				ContainerStatuses: []v1.ContainerStatus{{
+					State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+				}},
+			}
+			cluster, _ := newCluster(objects...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{
+				Default: time.Hour,
+				Phases:  map[string]time.Duration{PhaseKubeCF: time.Second},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			err = kubecf.Deploy(ctx, *cluster)
+			Expect(err).To(MatchError(ContainSubstring("phase kubecf timed out after 1s")))
+			Expect(err).To(MatchError(ContainSubstring("pods not ready: doppler-0 (ImagePullBackOff)")))
+			Expect(kubernetes.ExitCode(err)).To(Equal(kubernetes.ExitTimeout))
+		})
+
+		It("reports the pods ready while waiting", func() {
+			var out bytes.Buffer
+			previous := helpers.DefaultReporter
+			defer func() { helpers.DefaultReporter = previous }()
+			reporter, err := helpers.NewReporter(helpers.OutputJSON, &out)
+			Expect(err).ToNot(HaveOccurred())
+			helpers.DefaultReporter = reporter
+
+			objects := append(kubecfPods("kubecf"), runningPod("cf-operator", "cf-operator-0", nil))
+			objects[3].(*v1.Pod).Status.Phase = v1.PodPending
+			cluster, _ := newCluster(objects...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			Expect(kubecf.Deploy(ctx, *cluster)).ToNot(Succeed())
+
+			var waits []helpers.Event
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var e helpers.Event
+				Expect(json.Unmarshal([]byte(line), &e)).To(Succeed(), line)
+				if e.Event == helpers.EventWaiting && e.PodsTotal != nil {
+					waits = append(waits, e)
+				}
+			}
+			Expect(waits).ToNot(BeEmpty())
+			last := waits[len(waits)-1]
+			Expect(last.Phase).To(Equal(PhaseKubeCF))
+			Expect(*last.PodsReady).To(Equal(3))
+			Expect(*last.PodsTotal).To(Equal(4))
+		})
+	})
+
+	Describe("Upgrade", func() {
+		var objects []runtime.Object
+
+		BeforeEach(func() {
+			objects = append(kubecfPods("kubecf"), namespace("cf-operator"), runningPod("cf-operator", "cf-operator-0", nil))
+		})
+
+		It("upgrades Quarks, then KubeCF", func() {
+			cluster, _ := newCluster(objects...)
+			runner.Outputs["helm list --namespace kubecf"] = `[{"name":"kubecf","chart":"kubecf-v2.5.8"}]`
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Version: "2.6.1", Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain("example.com")
+
+			Expect(kubecf.Upgrade(ctx, *cluster)).To(Succeed())
+
+			commands := runner.Commands()
+			Expect(commands).To(HaveLen(3))
+			Expect(commands[0]).To(Equal("helm list --namespace kubecf --all --filter '^kubecf$' --output json"))
+			Expect(commands[1]).To(HavePrefix("helm upgrade cf-operator --create-namespace --namespace cf-operator --wait "))
+			Expect(commands[2]).To(HavePrefix("helm upgrade kubecf --namespace kubecf " + kubecfChart + " --set system_domain=example.com"))
+		})
+
+		It("refuses downgrades", func() {
+			cluster, _ := newCluster(objects...)
+			runner.Outputs["helm list --namespace kubecf"] = `[{"name":"kubecf","chart":"kubecf-v2.6.1"}]`
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Version: "2.5.8", Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubecf.Upgrade(ctx, *cluster)).To(MatchError(ContainSubstring("downgrading kubecf from 2.6.1 to 2.5.8 is not supported")))
+			Expect(runner.Commands()).To(Equal([]string{"helm list --namespace kubecf --all --filter '^kubecf$' --output json"}))
+		})
+
+		It("refuses upgrades from versions it doesn't know", func() {
+			cluster, _ := newCluster(objects...)
+			runner.Outputs["helm list --namespace kubecf"] = `[{"name":"kubecf","chart":"kubecf-v2.2.3"}]`
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Version: "2.6.1", Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = kubecf.Upgrade(ctx, *cluster)
+			Expect(err).To(MatchError(ContainSubstring("unknown installed kubecf version 2.2.3")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassPreflight))
+			Expect(runner.Commands()).To(HaveLen(1))
+		})
+
+		It("refuses upgrades when kubecf isn't installed", func() {
+			cluster, _ := newCluster(objects...)
+			runner.Outputs["helm list --namespace kubecf"] = `[]`
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Version: "2.6.1", Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubecf.Upgrade(ctx, *cluster)).To(MatchError(ContainSubstring("kubecf is not installed in namespace kubecf")))
+			Expect(runner.Commands()).To(HaveLen(1))
+		})
+	})
+
+	Describe("Rollback", func() {
+		BeforeEach(func() {
+			// Quarks was upgraded first, then KubeCF
+			runner.Outputs["helm history cf-operator --namespace cf-operator"] = `[
+				{"revision":1,"updated":"2020-10-01T12:00:00.482163214+02:00","status":"superseded","chart":"cf-operator-6.1.16","app_version":"","description":"Install complete"},
+				{"revision":2,"updated":"2020-10-02T12:00:00.127365108+02:00","status":"deployed","chart":"cf-operator-6.1.17","app_version":"","description":"Upgrade complete"}]`
+			runner.Outputs["helm history kubecf --namespace kubecf"] = `[
+				{"revision":1,"updated":"2020-10-01T10:05:00.736402617Z","status":"superseded","chart":"kubecf-v2.5.8","app_version":"","description":"Install complete"},
+				{"revision":2,"updated":"2020-10-02T10:05:00.310932451Z","status":"deployed","chart":"kubecf-v2.6.1","app_version":"","description":"Upgrade complete"}]`
+		})
+
+		It("rolls back KubeCF, then Quarks to the revision it had then", func() {
+			cluster, _ := newCluster(kubecfPods("kubecf")...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubecf.Rollback(ctx, *cluster, 0)).To(Succeed())
+			Expect(runner.Commands()).To(Equal([]string{
+				"helm history kubecf --namespace kubecf --output json",
+				"helm history cf-operator --namespace cf-operator --output json",
+				"helm rollback kubecf 1 --namespace kubecf",
+				"helm rollback cf-operator 1 --namespace cf-operator --wait",
+			}))
+		})
+
+		It("refuses to roll back to the current revision", func() {
+			cluster, _ := newCluster(kubecfPods("kubecf")...)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = kubecf.Rollback(ctx, *cluster, 2)
+			Expect(err).To(MatchError("no previous revision 2 of kubecf in kubecf to roll back to"))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassPreflight))
+			Expect(runner.Commands()).ToNot(ContainElement(HavePrefix("helm rollback")))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("removes the namespaces and the cluster wide resources", func() {
+			cluster, client := newCluster(
+				namespace("kubecf"), namespace("kubecf-eirini"), namespace("cf-operator"),
+				&policyv1beta1.PodSecurityPolicy{ObjectMeta: metav1.ObjectMeta{Name: "kubecf-default"}},
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "eirini-cluster-role"}},
+			)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubecf.Delete(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(BeEmpty())
+			// Quarks deletes the namespace it watches first, the second delete
+			// finds it gone
+			Expect(actions(client)).To(Equal([]string{
+				"delete namespaces/kubecf",
+				"delete namespaces/cf-operator",
+				"delete namespaces/kubecf",
+				"delete namespaces/kubecf-eirini",
+				"delete podsecuritypolicies/kubecf-default",
+				"delete clusterrolebindings/eirini-cluster-rolebinding",
+				"delete clusterroles/eirini-cluster-role",
+			}))
+
+			namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(namespaces.Items).To(BeEmpty())
+		})
+	})
+
+	Describe("Restore in place", func() {
+		var dir string
+
+		// running returns a cluster running KubeCF, whose Cloud Controller
+		// can be stopped
+		running := func(tenants ...string) (*kubernetes.Cluster, *fakeExec) {
+			var objects []runtime.Object
+			for _, ns := range append([]string{"kubecf"}, tenants...) {
+				blobstore := runningPod(ns, "singleton-blobstore-0", nil)
+				blobstore.Spec.Containers = []v1.Container{{Name: "blobstore"}}
+				objects = append(objects, kubecfPods(ns)...)
+				objects = append(objects, ccStatefulSets(ns)...)
+				objects = append(objects, blobstore)
+			}
+			objects = append(objects, namespace("cf-operator"), runningPod("cf-operator", "cf-operator-0", nil))
+			cluster, client := newCluster(objects...)
+			scalable(client)
+			exec := newFakeExec(cluster)
+			exec.Outputs["api-0 cat /var/vcap/jobs/cloud_controller_ng/config/cloud_controller_ng.yml"] = "system_domain: example.com\ndb_encryption_key: kubecf-restore-db-key\n"
+			exec.Outputs["database-0 mysqldump --max_allowed_packet"] = "CCDB DUMP"
+			return cluster, exec
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "kubecfctl-test")
+			Expect(err).ToNot(HaveOccurred())
+
+			cluster, _ := running()
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(kubecf.Backup(ctx, *cluster, filepath.Join(dir, "backup"))).To(Succeed())
+			runner = helpers.NewRecordingRunner()
+			helpers.DefaultRunner = runner
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		restore := func(opts DeploymentOptions, domain string) (*fakeExec, error) {
+			cluster, exec := running()
+			runner.Outputs["helm list --namespace kubecf"] = `[{"name":"kubecf","chart":"kubecf-v2.5.8"}]`
+			opts.InPlace = true
+			opts.SafetyBackup = filepath.Join(dir, "safety")
+			opts.Timeouts = kubernetes.Timeouts{Default: time.Second}
+			kubecf, err := GlobalCatalog.Deployment("kubecf", opts)
+			Expect(err).ToNot(HaveOccurred())
+			kubecf.SetDomain(domain)
+			return exec, kubecf.Restore(ctx, *cluster, filepath.Join(dir, "backup"))
+		}
+
+		It("applies the restored configuration to the running version", func() {
+			running, err := GlobalCatalog.GetKubeCF("2.5.8")
+			Expect(err).ToNot(HaveOccurred())
+
+			exec, err := restore(DeploymentOptions{}, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exec.Stdin("database-0 mysql cloud_controller")).To(Equal("CCDB DUMP"))
+			Expect(runner.Commands()).To(ContainElement(And(
+				HavePrefix("helm upgrade kubecf --namespace kubecf "+running.ChartURL+" --set system_domain=example.com "),
+				ContainSubstring("-f "),
+			)))
+		})
+
+		It("rewrites the domain in the URIs of the UAA clients only where it ends a host name", func() {
+			exec, err := restore(DeploymentOptions{}, "new.example.org")
+			Expect(err).ToNot(HaveOccurred())
+
+			update := exec.Stdin("database-0 mysql -N uaa")
+			for _, anchored := range []string{"'://example.com/', '://new.example.org/'", "'.example.com:', '.new.example.org:'", "'.example.com,', '.new.example.org,'"} {
+				Expect(update).To(ContainSubstring(anchored))
+			}
+			Expect(update).ToNot(ContainSubstring("'example.com', 'new.example.org'"))
+			Expect(runner.Commands()).To(ContainElement(HavePrefix("helm upgrade kubecf --namespace kubecf ")))
+			Expect(runner.Commands()).To(ContainElement(ContainSubstring("--set system_domain=new.example.org ")))
+		})
+
+		It("keeps the running release when the configuration isn't restored", func() {
+			exec, err := restore(DeploymentOptions{Exclude: []string{DataConfig, DataCCDB}}, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exec.Commands()).To(ContainElement(HavePrefix("database-0 mysql uaa")))
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+
+		It("keeps the domain of the backup for the tenants restored without the primary namespace", func() {
+			backup := filepath.Join(dir, "backup")
+			manifest, err := ReadBackupManifest(backup, "kubecf")
+			Expect(err).ToNot(HaveOccurred())
+			manifest.Namespaces = append(manifest.Namespaces, "tenant")
+			Expect(manifest.Write(backup)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(backup, "tenant"), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(backup, "tenant", "cc_config.yaml"), []byte("system_domain: tenant.example.com\n"), 0644)).To(Succeed())
+
+			cluster, _ := running("tenant")
+			runner.Outputs["helm list --namespace kubecf"] = `[{"name":"kubecf","chart":"kubecf-v2.5.8"}]`
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{
+				InPlace:           true,
+				SafetyBackup:      filepath.Join(dir, "safety"),
+				Timeouts:          kubernetes.Timeouts{Default: time.Second},
+				RestoreNamespaces: []string{"tenant"},
+				Include:           []string{DataConfig},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubecf.Restore(ctx, *cluster, backup)).To(Succeed())
+			Expect(runner.Commands()).To(ContainElement(And(
+				HavePrefix("helm upgrade kubecf --namespace tenant "),
+				ContainSubstring("--set system_domain=tenant.example.com "),
+			)))
+		})
+
+		It("asks for the domain when the backup doesn't have the one of the primary namespace", func() {
+			backup := filepath.Join(dir, "backup")
+			Expect(os.Remove(filepath.Join(backup, "kubecf", "cc_config.yaml"))).To(Succeed())
+
+			cluster, _ := running()
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{InPlace: true, Include: []string{DataConfig}})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = kubecf.Restore(ctx, *cluster, backup)
+			Expect(err).To(MatchError(ContainSubstring("specify it with --domain")))
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassUsage))
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+	})
+
+	Describe("Leftovers", func() {
+		It("finds the cluster wide resources of KubeCF and Quarks only", func() {
+			managed := map[string]string{"app.kubernetes.io/managed-by": "kubecfctl"}
+			cluster, _ := newCluster(
+				&policyv1beta1.PodSecurityPolicy{ObjectMeta: metav1.ObjectMeta{Name: "kubecf-default"}},
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "eirini-cluster-role"}},
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "cf-operator-quarks-job"}},
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "kubecfctl-backup", Labels: managed}},
+				&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "kubecfctl-backup-kubecf", Labels: managed}},
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "my-kubecf-viewer"}},
+			)
+			kubecf, err := GlobalCatalog.Deployment("kubecf", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			leftovers, err := kubernetes.NewInstaller().Leftovers(ctx, kubecf, *cluster)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(leftovers).To(ConsistOf(
+				kubernetes.Resource{Kind: kubernetes.KindPodSecurityPolicy, Name: "kubecf-default"},
+				kubernetes.Resource{Kind: kubernetes.KindClusterRole, Name: "eirini-cluster-role"},
+				kubernetes.Resource{Kind: kubernetes.KindClusterRole, Name: "cf-operator-quarks-job"},
+			))
+		})
+	})
+})
@@ -0,0 +1,228 @@
+package deployments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MigrationReport lists what a migration carried over to the new
+// deployment, and what has to be taken care of by hand
+type MigrationReport struct {
+	Migrated    []string
+	NotMigrated []string
+}
+
+func (r *MigrationReport) migrated(format string, a ...interface{}) {
+	r.Migrated = append(r.Migrated, fmt.Sprintf(format, a...))
+}
+
+func (r *MigrationReport) notMigrated(format string, a ...interface{}) {
+	r.NotMigrated = append(r.NotMigrated, fmt.Sprintf(format, a...))
+}
+
+// Print writes the report to stdout
+func (r MigrationReport) Print() {
+	helpers.Info("", ":clipboard: Migration report")
+	helpers.Success("", ":heavy_check_mark: Carried over:")
+	for _, m := range r.Migrated {
+		helpers.Info("", "   "+m)
+	}
+	if len(r.NotMigrated) == 0 {
+		return
+	}
+	helpers.Warning("", ":warning: Not carried over:")
+	for _, m := range r.NotMigrated {
+		helpers.Info("", "   "+m)
+	}
+}
+
+// Migrate moves the foundation of from to a new deployment of to, going
+// through a backup stored in output. Only SCF to KubeCF is supported. If
+// deleteSource is set, from is deleted once backed up, so to can take over
+// its addresses
+func Migrate(ctx context.Context, c kubernetes.Cluster, from, to kubernetes.Deployment, output string, deleteSource bool) (MigrationReport, error) {
+	scf, ok := from.(*SCF)
+	kubecf, ok2 := to.(*KubeCF)
+	if !ok || !ok2 {
+		return MigrationReport{}, kubernetes.UsageError{Err: errors.New("only migrations from scf to kubecf are supported")}
+	}
+	return kubecf.migrateSCF(ctx, c, *scf, output, deleteSource)
+}
+
+// scfSettings reads from the values of the SCF helm release the settings
+// which have an equivalent in KubeCF
+func (k *KubeCF) scfSettings(ctx context.Context, scf SCF, report *MigrationReport) error {
+	out, err := helmOutput(ctx, "get values scf --namespace "+scf.Namespace+" --output json")
+	if err != nil {
+		return errors.Wrap(err, "while reading the scf release values")
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(out), &values); err != nil {
+		return errors.Wrap(err, "while reading the scf release values")
+	}
+
+	value := func(path ...string) interface{} {
+		var v interface{} = values
+		for _, p := range path {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[p]
+		}
+		return v
+	}
+	enabled := func(path ...string) bool {
+		b, _ := value(path...).(bool)
+		return b
+	}
+
+	k.Eirini = k.Eirini || enabled("enable", "eirini")
+	k.Ingress = k.Ingress || enabled("ingress", "enabled")
+	k.Autoscaler = k.Autoscaler || enabled("enable", "autoscaler")
+	if sc, ok := value("kube", "storage_class", "persistent").(string); ok && k.StorageClass == "" {
+		k.StorageClass = sc
+	}
+	report.migrated("settings: eirini=%t, ingress=%t, autoscaler=%t, storage class=%q", k.Eirini, k.Ingress, k.Autoscaler, k.StorageClass)
+
+	// Everything else set on the release is SCF specific
+	known := map[string]bool{"enable": true, "ingress": true, "kube": true, "env": true, "secrets": true, "services": true}
+	for key := range values {
+		if !known[key] {
+			report.notMigrated("scf helm values under %q, set their KubeCF equivalent by hand", key)
+		}
+	}
+	return nil
+}
+
+// migrateSCF backs up scf to output, deploys KubeCF with the same settings,
+// domain and CCDB encryption keys, and loads the SCF data into it
+func (k KubeCF) migrateSCF(ctx context.Context, c kubernetes.Cluster, scf SCF, output string, deleteSource bool) (MigrationReport, error) {
+	report := MigrationReport{}
+
+	if _, err := c.Kubectl.CoreV1().Pods(scf.Namespace).Get(ctx, "api-group-0", metav1.GetOptions{}); err != nil {
+		return report, errors.Wrap(err, "no running SCF deployment found in namespace "+scf.Namespace)
+	}
+	if err := k.scfSettings(ctx, scf, &report); err != nil {
+		return report, err
+	}
+
+	helpers.Info("", ":floppy_disk:Backing up SCF to "+output)
+	scf.Include, scf.Exclude = nil, nil
+	if err := scf.Backup(ctx, c, output); err != nil {
+		return report, errors.Wrap(err, "while backing up scf")
+	}
+	manifest, err := ReadBackupManifest(output, scf.Namespace)
+	if err != nil {
+		return report, err
+	}
+	dir := manifest.Dir(output, scf.Namespace)
+	// Nothing is changed if the backup can't be loaded whole
+	if err := checkDumps(dir, []string{DataUAA, DataCCDB, DataBlobstore}); err != nil {
+		return report, errors.Wrap(err, "the scf backup in "+output+" is incomplete")
+	}
+
+	enc, err := k.readEncryption(dir)
+	if err != nil {
+		return report, err
+	}
+	oldDomain := k.readSystemDomain(dir)
+	if k.domain == "" {
+		k.domain = oldDomain
+	}
+
+	if deleteSource {
+		if err := scf.Delete(ctx, c); err != nil {
+			return report, errors.Wrap(err, "while deleting scf, its backup is in "+output)
+		}
+	} else if !k.Ingress {
+		helpers.Warning("", ":warning: SCF is still running and exposes the same addresses KubeCF is going to use, consider migrating with --delete-scf")
+	}
+
+	// The SCF keys are set from the start, so the Cloud Controller never
+	// encrypts data with different ones
+	k.encryption = map[string]ccEncryption{k.Namespace: enc}
+	if err := k.Deploy(ctx, c); err != nil {
+		return report, errors.Wrap(err, "while deploying kubecf, the scf backup is in "+output)
+	}
+	report.migrated("CCDB encryption keys: %d key labels, current one %q", len(enc.keys), enc.currentKey)
+
+	helpers.Info("", ":floppy_disk:Loading SCF data into "+k.Namespace)
+	if err := k.disableDBRestrictions(ctx, c, k.Namespace); err != nil {
+		return report, err
+	}
+	err = whileQuiesced(ctx, c, k.Namespace, k.Timeouts.Phase(PhaseQuiesce), func() error {
+		// The databases are recreated, so the Cloud Controller and UAA
+		// migrate them from the SCF schema when they start again
+		if err := k.loadDatabase(ctx, c, k.Namespace, "uaa", dumpFile(dir, DataUAA), true); err != nil {
+			return err
+		}
+		report.migrated("UAA users, groups and clients (uaadb to uaa)")
+
+		if err := k.loadDatabase(ctx, c, k.Namespace, "cloud_controller", dumpFile(dir, DataCCDB), true); err != nil {
+			return err
+		}
+		report.migrated("CCDB orgs, spaces, apps, routes and services (ccdb to cloud_controller)")
+
+		if err := k.restoreBlobstore(ctx, c, k.Namespace, dir); err != nil {
+			return err
+		}
+		report.migrated("blobstore packages, droplets and buildpacks")
+
+		if oldDomain != "" && oldDomain != k.domain {
+			changes, err := k.rewriteDomain(ctx, c, k.Namespace, oldDomain, k.domain, []string{DataUAA, DataCCDB})
+			if err != nil {
+				return errors.Wrap(err, "while rewriting domain")
+			}
+			printDomainChanges(k.Namespace, changes)
+			report.migrated("system domain, rewritten from %s to %s", oldDomain, k.domain)
+		} else {
+			report.migrated("system domain %s", k.domain)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, errors.Wrap(err, "while loading scf data, the scf backup is in "+output)
+	}
+
+	helpers.Info("", ":arrows_counterclockwise: Restarting UAA")
+	uaa := "quarks.cloudfoundry.org/quarks-statefulset-name=uaa"
+	if err := c.Kubectl.CoreV1().Pods(k.Namespace).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: uaa}); err != nil {
+		return report, errors.Wrap(err, "while restarting uaa")
+	}
+	for _, selector := range []string{uaa, "quarks.cloudfoundry.org/quarks-statefulset-name=api"} {
+		if err := c.WaitUntilPodBySelectorExist(ctx, k.Namespace, selector, k.Timeouts.Phase(PhaseKubeCF)); err != nil {
+			return report, errors.Wrap(err, "failed waiting for "+selector)
+		}
+		if err := c.WaitForPodBySelectorRunning(ctx, k.Namespace, selector, k.Timeouts.Phase(PhaseKubeCF)); err != nil {
+			return report, errors.Wrap(err, "failed waiting for "+selector)
+		}
+	}
+
+	report.notMigrated("credentials and certificates: KubeCF generated new ones, including the admin password (kubecfctl get password kubecf)")
+	report.notMigrated("custom certificates configured in SCF: set them again in KubeCF")
+	report.notMigrated("data of service brokers and of services running outside of the CCDB (e.g. minibroker databases)")
+	if k.Autoscaler {
+		report.notMigrated("autoscaler policies and history, the app autoscaler databases are not migrated")
+	}
+	if k.Eirini {
+		report.notMigrated("apps running on Eirini in " + scf.Namespace + "-eirini: restart them with cf restart so they are scheduled by KubeCF")
+	}
+	for _, ns := range scf.AdditionalNamespaces {
+		report.notMigrated("additional SCF namespace " + ns)
+	}
+	if deleteSource {
+		report.migrated("scf deleted, its backup is in " + output)
+	} else {
+		report.notMigrated("scf is still running in namespace " + scf.Namespace + ", delete it with kubecfctl delete scf once KubeCF is verified. Its backup is in " + output)
+	}
+	report.migrated("namespace %s to %s", scf.Namespace, k.Namespace)
+
+	return report, nil
+}
@@ -2,15 +2,14 @@ package deployments
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/pkg/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type NginxIngress struct {
@@ -21,14 +20,28 @@ type NginxIngress struct {
 
 	Debug bool
 
-	LB      bool
-	Timeout int
+	LB       bool
+	Timeouts kubernetes.Timeouts
 }
 
-func (k *NginxIngress) Backup(c kubernetes.Cluster, d string) error {
+func (k *NginxIngress) Backup(ctx context.Context, c kubernetes.Cluster, d string) error {
 	return nil
 }
-func (k *NginxIngress) Restore(c kubernetes.Cluster, d string) error {
+func (k *NginxIngress) Restore(ctx context.Context, c kubernetes.Cluster, d string) error {
+	return nil
+}
+func (k NginxIngress) Rollback(ctx context.Context, c kubernetes.Cluster, revision int) error {
+	plan, err := rollbackPlan(ctx, helmRelease{Name: "nginx-ingress", Namespace: k.Namespace}, revision, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := rollbackReleases(ctx, plan, map[string]bool{"nginx-ingress": true}, k.Debug); err != nil {
+		return err
+	}
+	if err := c.WaitForPodBySelectorRunning(ctx, k.Namespace, "", k.Timeouts.Phase(PhaseIngress)); err != nil {
+		return errors.Wrap(err, "failed waiting for nginx-ingress to be ready")
+	}
+	helpers.Success(PhaseIngress, ":heavy_check_mark: NginxIngress rolled back")
 	return nil
 }
 
@@ -40,15 +53,24 @@ func (k NginxIngress) GetDomain() string {
 	return k.domain
 }
 
+func (k NginxIngress) GetNamespace() string {
+	return k.Namespace
+}
+
 func (k NginxIngress) Describe() string {
-	return emoji.Sprintf(":cloud:Nginx Ingress version: %s\n:clipboard:Nginx Ingress chart: %s", k.Version, k.ChartURL)
+	return fmt.Sprintf(":cloud:Nginx Ingress version: %s\n:clipboard:Nginx Ingress chart: %s", k.Version, k.ChartURL)
 }
 
-func (k NginxIngress) Delete(c kubernetes.Cluster) error {
-	return c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace, metav1.DeleteOptions{})
+func (k NginxIngress) Delete(ctx context.Context, c kubernetes.Cluster) error {
+	return c.DeleteNamespaces(ctx, k.Timeouts.Phase(PhaseDelete), k.Namespace)
 }
 
-func (k NginxIngress) apply(c kubernetes.Cluster, upgrade bool) error {
+// Owned selects the cluster wide resources installed by the ingress controller
+func (k NginxIngress) Owned() kubernetes.Owned {
+	return kubernetes.Owned{Namespaces: []string{k.Namespace}}
+}
+
+func (k NginxIngress) apply(ctx context.Context, c kubernetes.Cluster, upgrade bool) error {
 
 	action := "install"
 	if upgrade {
@@ -67,15 +89,15 @@ func (k NginxIngress) apply(c kubernetes.Cluster, upgrade bool) error {
 		}
 	}
 
-	if _, err := helpers.RunProc("helm "+action+" nginx-ingress --create-namespace --wait --namespace "+k.Namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug); err != nil {
-		return errors.New("Failed installing NginxIngress")
+	if _, err := runHelm(ctx, action+" nginx-ingress --create-namespace --wait --namespace "+k.Namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug); err != nil {
+		return errors.Wrap(err, "Failed installing NginxIngress")
 	}
 
-	if err := c.WaitForPodBySelectorRunning(k.Namespace, "", k.Timeout); err != nil {
+	if err := c.WaitForPodBySelectorRunning(ctx, k.Namespace, "", k.Timeouts.Phase(PhaseIngress)); err != nil {
 		return errors.Wrap(err, "failed waiting Nginx Ingress deployment to come up")
 	}
 
-	emoji.Println(":heavy_check_mark: Nginx Ingress deployed")
+	helpers.Success(PhaseIngress, ":heavy_check_mark: Nginx Ingress deployed")
 
 	return nil
 }
@@ -84,31 +106,34 @@ func (k NginxIngress) GetVersion() string {
 	return k.Version
 }
 
-func (k NginxIngress) Deploy(c kubernetes.Cluster) error {
+// InstalledVersion returns the version of the nginx-ingress release in the cluster
+func (k NginxIngress) InstalledVersion(ctx context.Context, c kubernetes.Cluster) (string, error) {
+	return releaseVersion(ctx, "nginx-ingress", k.Namespace)
+}
+
+func (k NginxIngress) Deploy(ctx context.Context, c kubernetes.Cluster) error {
 
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		k.Namespace,
-		metav1.GetOptions{},
-	)
-	if err == nil {
-		return errors.New("Namespace " + k.Namespace + " present already, run 'kubecfctl nginx-ingress delete " + k.Version + "' first")
+	exists, err := c.NamespaceExists(ctx, k.Namespace)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace " + k.Namespace + " present already, run 'kubecfctl nginx-ingress delete " + k.Version + "' first")}
 	}
 
-	emoji.Println(":ship:Deploying Nginx Ingress")
-	return k.apply(c, false)
+	helpers.Info(PhaseIngress, ":ship:Deploying Nginx Ingress")
+	return k.apply(ctx, c, false)
 }
 
-func (k NginxIngress) Upgrade(c kubernetes.Cluster) error {
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		k.Namespace,
-		metav1.GetOptions{},
-	)
+func (k NginxIngress) Upgrade(ctx context.Context, c kubernetes.Cluster) error {
+	exists, err := c.NamespaceExists(ctx, k.Namespace)
 	if err != nil {
-		return errors.New("Namespace " + k.Namespace + " not present")
+		return err
+	}
+	if !exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace " + k.Namespace + " not present")}
 	}
 
-	emoji.Println(":ship:Upgrade Nginx Ingress")
-	return k.apply(c, true)
+	helpers.Info(PhaseIngress, ":ship:Upgrade Nginx Ingress")
+	return k.apply(ctx, c, true)
 }
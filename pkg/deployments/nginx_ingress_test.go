@@ -0,0 +1,87 @@
+package deployments_test
+
+import (
+	"context"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NginxIngress", func() {
+	ctx := context.Background()
+
+	Describe("Deploy", func() {
+		It("installs the controller on the platform addresses", func() {
+			cluster, _ := newCluster(runningPod("nginx-ingress", "nginx-ingress-0", nil))
+			nginx, err := GlobalCatalog.Deployment("nginx-ingress", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(nginx.Deploy(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(Equal([]string{
+				"helm install nginx-ingress --create-namespace --wait --namespace nginx-ingress https://github.com/kubernetes/ingress-nginx/releases/download/ingress-nginx-3.7.1/ingress-nginx-3.7.1.tgz --set controller.service.externalIPs[0]=10.0.0.1",
+			}))
+		})
+
+		It("refuses to install over an existing namespace", func() {
+			cluster, _ := newCluster(namespace("nginx-ingress"))
+			nginx, err := GlobalCatalog.Deployment("nginx-ingress", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(nginx.Deploy(ctx, *cluster)).To(MatchError(ContainSubstring("present already")))
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+	})
+
+	Describe("Upgrade", func() {
+		It("fails when the controller isn't installed", func() {
+			cluster, _ := newCluster()
+			nginx, err := GlobalCatalog.Deployment("nginx-ingress", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(nginx.Upgrade(ctx, *cluster)).To(MatchError("Namespace nginx-ingress not present"))
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+
+		It("is audited with the versions installed and the secrets masked", func() {
+			cluster, _ := newCluster(namespace("nginx-ingress"), runningPod("nginx-ingress", "nginx-ingress-0", nil))
+			cluster.User = "admin"
+			runner.Outputs["helm list --namespace nginx-ingress"] = `[{"name":"nginx-ingress","chart":"ingress-nginx-3.7.1"}]`
+			helpers.RegisterSecret("s3cr3t-audit")
+			nginx, err := GlobalCatalog.Deployment("nginx-ingress", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			inst := kubernetes.NewInstaller()
+			inst.Component = "nginx-ingress"
+			inst.CommandLine = "kubecfctl upgrade nginx-ingress --registry-password s3cr3t-audit"
+			Expect(inst.Upgrade(ctx, nginx, *cluster)).To(Succeed())
+
+			records, err := cluster.AuditHistory(ctx, "nginx-ingress")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records).To(HaveLen(1))
+			r := records[0]
+			Expect(r.Operation).To(Equal("upgrade"))
+			Expect(r.KubeUser).To(Equal("admin"))
+			Expect(r.Command).To(HavePrefix("kubecfctl upgrade nginx-ingress "))
+			Expect(r.Command).To(HaveSuffix(" " + helpers.Mask))
+			Expect(r.Command).ToNot(ContainSubstring("s3cr3t-audit"))
+			Expect(r.VersionBefore).To(Equal("3.7.1"))
+			Expect(r.VersionAfter).To(Equal("3.7.1"))
+			Expect(r.Result).To(Equal(kubernetes.AuditSuccess))
+			Expect(r.Started).ToNot(BeZero())
+		})
+	})
+
+	Describe("Delete", func() {
+		It("removes the namespace", func() {
+			cluster, client := newCluster(namespace("nginx-ingress"))
+			nginx, err := GlobalCatalog.Deployment("nginx-ingress", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(nginx.Delete(ctx, *cluster)).To(Succeed())
+			Expect(actions(client)).To(Equal([]string{"delete namespaces/nginx-ingress"}))
+		})
+	})
+})
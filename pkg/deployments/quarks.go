@@ -7,12 +7,12 @@ import (
 	"os"
 	"time"
 
-	"github.com/briandowns/spinner"
-	"github.com/kyokomi/emoji"
+	"github.com/hashicorp/go-multierror"
 	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,7 +24,7 @@ type Quarks struct {
 	AdditionalNamespaces []string
 	Debug                bool
 
-	Timeout int
+	Timeouts kubernetes.Timeouts
 }
 
 func (k *Quarks) SetDomain(d string) {
@@ -35,33 +35,48 @@ func (k Quarks) GetDomain() string {
 	return k.domain
 }
 
-func (k Quarks) Describe() string {
-	return emoji.Sprintf(":cloud:Quarks version: %s\n:clipboard:Quarks chart: %s", k.Version, k.ChartURL)
+func (k Quarks) GetNamespace() string {
+	return k.Namespace
 }
 
-func (k Quarks) Delete(c kubernetes.Cluster) error {
-	currentdir, _ := os.Getwd()
-
-	helpers.RunProc("kubectl delete crds boshdeployments.quarks.cloudfoundry.org", currentdir, k.Debug)
-	helpers.RunProc("kubectl delete crds quarksjobs.quarks.cloudfoundry.org", currentdir, k.Debug)
-	helpers.RunProc("kubectl delete crds quarkssecrets.quarks.cloudfoundry.org", currentdir, k.Debug)
-	helpers.RunProc("kubectl delete crds quarksstatefulsets.quarks.cloudfoundry.org", currentdir, k.Debug)
+func (k Quarks) Describe() string {
+	return fmt.Sprintf(":cloud:Quarks version: %s\n:clipboard:Quarks chart: %s", k.Version, k.ChartURL)
+}
 
-	if len(k.AdditionalNamespaces) != 0 {
-		for _, ns := range k.AdditionalNamespaces {
-			c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), ns, metav1.DeleteOptions{})
+func (k Quarks) Delete(ctx context.Context, c kubernetes.Cluster) error {
+	var result error
 
-		}
+	var crds []kubernetes.Resource
+	for _, crd := range []string{"boshdeployments", "quarksjobs", "quarkssecrets", "quarksstatefulsets"} {
+		crds = append(crds, kubernetes.Resource{Kind: kubernetes.KindCustomResourceDefinition, Name: crd + ".quarks.cloudfoundry.org"})
+	}
+	if err := c.DeleteResources(ctx, crds); err != nil {
+		result = multierror.Append(result, err)
 	}
 
-	c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace, metav1.DeleteOptions{})
-	c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), "cf-operator", metav1.DeleteOptions{})
+	namespaces := append([]string{k.Namespace, "cf-operator"}, k.AdditionalNamespaces...)
+	if err := c.DeleteNamespaces(ctx, k.Timeouts.Phase(PhaseDelete), namespaces...); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if result != nil {
+		return kubernetes.Partial(result)
+	}
 
-	emoji.Println(":heavy_check_mark: Quarks Operator deleted")
+	helpers.Success(PhaseQuarks, ":heavy_check_mark: Quarks Operator deleted")
 
 	return nil
 }
 
+// Owned selects the cluster wide resources of the Quarks operator: its
+// CRDs, webhooks and the roles of its jobs
+func (k Quarks) Owned() kubernetes.Owned {
+	return kubernetes.Owned{
+		Namespaces: append([]string{k.Namespace, "cf-operator"}, k.AdditionalNamespaces...),
+		Prefixes:   []string{"cf-operator", "quarks-", "qjob-"},
+		Suffixes:   []string{".quarks.cloudfoundry.org"},
+	}
+}
+
 const charset = "abcdefghijklmnopqrstuvwxyz" +
 	"0123456789"
 
@@ -80,13 +95,13 @@ func String(length int) string {
 	return StringWithCharset(length, charset)
 }
 
-func (q Quarks) prepareAdditionalNamespace(c kubernetes.Cluster, namespace string) error {
-	emoji.Println(":clipboard:Preparing namespace ", namespace)
+func (q Quarks) prepareAdditionalNamespace(ctx context.Context, c kubernetes.Cluster, namespace string) error {
+	helpers.Info(PhaseQuarks, ":clipboard:Preparing namespace "+namespace)
 
 	roleName := namespace + "cfo" + String(5)
 	saName := namespace + "cfo" + String(5)
 
-	_, err := c.Kubectl.CoreV1().Namespaces().Create(context.Background(),
+	_, err := c.Kubectl.CoreV1().Namespaces().Create(ctx,
 		&v1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: namespace,
@@ -101,7 +116,7 @@ func (q Quarks) prepareAdditionalNamespace(c kubernetes.Cluster, namespace strin
 		return err
 	}
 
-	_, err = c.Kubectl.CoreV1().ServiceAccounts(namespace).Create(context.Background(),
+	_, err = c.Kubectl.CoreV1().ServiceAccounts(namespace).Create(ctx,
 		&v1.ServiceAccount{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: saName,
@@ -111,52 +126,74 @@ func (q Quarks) prepareAdditionalNamespace(c kubernetes.Cluster, namespace strin
 	if err != nil {
 		return err
 	}
-	currentdir, _ := os.Getwd()
-
-	out, err := helpers.RunProc(fmt.Sprintf("kubectl --namespace %s create rolebinding --clusterrole %s --serviceaccount %s %s", namespace, "qjob-persist-output", namespace+":"+saName, roleName), currentdir, q.Debug)
-	if err != nil {
-		fmt.Println(string(out))
-		return err
-	}
-
+	_, err = c.Kubectl.RbacV1().RoleBindings(namespace).Create(ctx,
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: roleName,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     "qjob-persist-output",
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      saName,
+				Namespace: namespace,
+			}},
+		},
+		metav1.CreateOptions{})
+	return err
+}
+func (k *Quarks) Backup(ctx context.Context, c kubernetes.Cluster, d string) error {
 	return nil
 }
-func (k *Quarks) Backup(c kubernetes.Cluster, d string) error {
+func (k *Quarks) Restore(ctx context.Context, c kubernetes.Cluster, d string) error {
 	return nil
 }
-func (k *Quarks) Restore(c kubernetes.Cluster, d string) error {
+func (k Quarks) Rollback(ctx context.Context, c kubernetes.Cluster, revision int) error {
+	plan, err := rollbackPlan(ctx, helmRelease{Name: "cf-operator", Namespace: "cf-operator"}, revision, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := rollbackReleases(ctx, plan, map[string]bool{"cf-operator": true}, k.Debug); err != nil {
+		return err
+	}
+	if err := c.WaitForPodBySelectorRunning(ctx, "cf-operator", "", k.Timeouts.Phase(PhaseQuarks)); err != nil {
+		return errors.Wrap(err, "failed waiting for quarks-operator to be ready")
+	}
+	helpers.Success(PhaseQuarks, ":heavy_check_mark: Quarks Operator rolled back")
 	return nil
 }
-func (k Quarks) ApplyOperator(c kubernetes.Cluster, upgrade bool) error {
+func (k Quarks) ApplyOperator(ctx context.Context, c kubernetes.Cluster, upgrade bool) error {
 	currentdir, _ := os.Getwd()
 	action := "install"
 	if upgrade {
 		action = "upgrade"
 	}
 
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
+	s := helpers.Wait(PhaseQuarks, "")
+	defer s.Done()
 
-	out, err := helpers.RunProc("helm "+action+" cf-operator --create-namespace --namespace cf-operator --wait "+k.ChartURL+" --set global.singleNamespace.name="+k.Namespace, currentdir, k.Debug)
+	out, err := runHelm(ctx, action+" cf-operator --create-namespace --namespace cf-operator --wait "+k.ChartURL+" --set global.singleNamespace.name="+k.Namespace, currentdir, k.Debug)
 	if err != nil {
-		fmt.Println(out)
-		return errors.New("Failed installing quarks-operator")
+		helpers.Output(PhaseQuarks, out)
+		return errors.Wrap(err, "Failed installing quarks-operator")
 	}
 
-	if err := c.WaitForPodBySelectorRunning("cf-operator", "", 900); err != nil {
+	if err := c.WaitForPodBySelectorRunning(ctx, "cf-operator", "", k.Timeouts.Phase(PhaseQuarks)); err != nil {
 		return errors.Wrap(err, "failed waiting")
 	}
 
 	if len(k.AdditionalNamespaces) != 0 && action == "install" {
 		for _, ns := range k.AdditionalNamespaces {
-			err := k.prepareAdditionalNamespace(c, ns)
+			err := k.prepareAdditionalNamespace(ctx, c, ns)
 			if err != nil {
 				return errors.Wrap(err, "Failed preparing additional NS "+ns)
 			}
 		}
 	}
-	emoji.Println(":heavy_check_mark: Quarks Operator deployed correctly to the :rainbow: :cloud:")
+	helpers.Success(PhaseQuarks, ":heavy_check_mark: Quarks Operator deployed correctly to the :rainbow: :cloud:")
 
 	return nil
 }
@@ -165,36 +202,39 @@ func (k Quarks) GetVersion() string {
 	return k.Version
 }
 
-func (k Quarks) Deploy(c kubernetes.Cluster) error {
-	emoji.Println(":ship:Deploying Quarks Operator")
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		"cf-operator",
-		metav1.GetOptions{},
-	)
-	if err == nil {
-		return errors.New("Namespace 'cf-operator' present already, run 'kubecfctl delete " + k.Version + "' first")
+// InstalledVersion returns the version of the cf-operator release in the cluster
+func (k Quarks) InstalledVersion(ctx context.Context, c kubernetes.Cluster) (string, error) {
+	return releaseVersion(ctx, "cf-operator", "cf-operator")
+}
+
+func (k Quarks) Deploy(ctx context.Context, c kubernetes.Cluster) error {
+	helpers.Info(PhaseQuarks, ":ship:Deploying Quarks Operator")
+	exists, err := c.NamespaceExists(ctx, "cf-operator")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace 'cf-operator' present already, run 'kubecfctl delete " + k.Version + "' first")}
 	}
 
-	if err := k.ApplyOperator(c, false); err != nil {
+	if err := k.ApplyOperator(ctx, c, false); err != nil {
 		return errors.Wrap(err, "while deploying quarks operator")
 	}
 
 	return nil
 }
 
-func (k Quarks) Upgrade(c kubernetes.Cluster) error {
-	emoji.Println(":ship:Upgrading Quarks Operator")
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		"cf-operator",
-		metav1.GetOptions{},
-	)
+func (k Quarks) Upgrade(ctx context.Context, c kubernetes.Cluster) error {
+	helpers.Info(PhaseQuarks, ":ship:Upgrading Quarks Operator")
+	exists, err := c.NamespaceExists(ctx, "cf-operator")
 	if err != nil {
-		return errors.New("Namespace 'cf-operator' not present")
+		return err
+	}
+	if !exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace 'cf-operator' not present")}
 	}
 
-	if err := k.ApplyOperator(c, true); err != nil {
+	if err := k.ApplyOperator(ctx, c, true); err != nil {
 		return errors.Wrap(err, "while deploying quarks operator")
 	}
 	return nil
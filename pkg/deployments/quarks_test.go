@@ -0,0 +1,188 @@
+package deployments_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Quarks", func() {
+	ctx := context.Background()
+	const install = "helm install cf-operator "
+
+	Describe("Deploy", func() {
+		It("installs the operator watching the namespace", func() {
+			cluster, _ := newCluster(runningPod("cf-operator", "cf-operator-0", nil))
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Deploy(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(Equal([]string{
+				"helm install cf-operator --create-namespace --namespace cf-operator --wait https://s3.amazonaws.com/cf-operators/release/helm-charts/cf-operator-6.1.17%2B0.gec409fd7.tgz --set global.singleNamespace.name=kubecf",
+			}))
+		})
+
+		It("prepares the additional namespaces", func() {
+			cluster, client := newCluster(runningPod("cf-operator", "cf-operator-0", nil))
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{AdditionalNamespaces: []string{"tenant"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Deploy(ctx, *cluster)).To(Succeed())
+
+			ns, err := client.CoreV1().Namespaces().Get(ctx, "tenant", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ns.Labels).To(HaveKeyWithValue("quarks.cloudfoundry.org/monitored", "cfo"))
+			sa := ns.Labels["quarks.cloudfoundry.org/qjob-service-account"]
+			Expect(sa).To(MatchRegexp("^tenantcfo[a-z0-9]{5}$"))
+
+			_, err = client.CoreV1().ServiceAccounts("tenant").Get(ctx, sa, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			bindings, err := client.RbacV1().RoleBindings("tenant").List(ctx, metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bindings.Items).To(HaveLen(1))
+			Expect(bindings.Items[0].RoleRef.Name).To(Equal("qjob-persist-output"))
+			Expect(bindings.Items[0].Subjects[0].Name).To(Equal(sa))
+		})
+
+		It("refuses to install over an existing operator", func() {
+			cluster, _ := newCluster(namespace("cf-operator"))
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = quarks.Deploy(ctx, *cluster)
+			Expect(err).To(MatchError(ContainSubstring("present already")))
+			Expect(kubernetes.ExitCode(err)).To(Equal(kubernetes.ExitPreflight))
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+
+		It("retries helm on transient failures", func() {
+			cluster, _ := newCluster(runningPod("cf-operator", "cf-operator-0", nil))
+			runner.Errors["helm "] = errors.New("Error: Kubernetes cluster unreachable: dial tcp 10.0.0.1:6443: connect: connection refused")
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Deploy(ctx, *cluster)).ToNot(Succeed())
+			Expect(runner.Commands()).To(HaveLen(3))
+		})
+
+		It("retries the installs as upgrades, as the release may have been created", func() {
+			cluster, _ := newCluster(runningPod("cf-operator", "cf-operator-0", nil))
+			runner.Errors[install] = errors.New("Error: Kubernetes cluster unreachable: dial tcp 10.0.0.1:6443: connect: connection refused")
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Deploy(ctx, *cluster)).To(Succeed())
+			commands := runner.Commands()
+			Expect(commands).To(HaveLen(2))
+			Expect(commands[0]).To(HavePrefix(install))
+			Expect(commands[1]).To(Equal("helm upgrade --install " + commands[0][len("helm install "):]))
+		})
+
+		It("fails with a helm error on permanent helm failures, without retrying", func() {
+			cluster, _ := newCluster(runningPod("cf-operator", "cf-operator-0", nil))
+			runner.Errors[install] = errors.New("Error: values don't meet the specifications of the schema(s) in the following chart(s)")
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = quarks.Deploy(ctx, *cluster)
+			Expect(kubernetes.ErrorClass(err)).To(Equal(kubernetes.ClassHelm))
+			Expect(kubernetes.ExitCode(err)).To(Equal(kubernetes.ExitHelm))
+			Expect(runner.Commands()).To(HaveLen(1))
+		})
+
+		It("retries the API calls failing with transient errors", func() {
+			cluster, client := newCluster(namespace("cf-operator"))
+			failures := 2
+			client.PrependReactor("get", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if failures == 0 {
+					return false, nil, nil
+				}
+				failures--
+				return true, nil, apierrors.NewServiceUnavailable("etcd is restarting")
+			})
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			// The namespace is found despite the failures, so the operator
+			// isn't installed over the existing one
+			Expect(quarks.Deploy(ctx, *cluster)).To(MatchError(ContainSubstring("present already")))
+			Expect(failures).To(BeZero())
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+
+		It("doesn't retry the API calls failing with permanent errors", func() {
+			cluster, client := newCluster()
+			calls := 0
+			client.PrependReactor("get", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+				calls++
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "cf-operator", errors.New("denied"))
+			})
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Deploy(ctx, *cluster)).To(MatchError(ContainSubstring("forbidden")))
+			Expect(calls).To(Equal(1))
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+	})
+
+	Describe("Upgrade", func() {
+		It("upgrades the operator release", func() {
+			cluster, _ := newCluster(namespace("cf-operator"), runningPod("cf-operator", "cf-operator-0", nil))
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Upgrade(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(ConsistOf(HavePrefix("helm upgrade cf-operator ")))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("removes the namespaces and the CRDs", func() {
+			cluster, client := newCluster(namespace("cf-operator"), namespace("kubecf"))
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(quarks.Delete(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(BeEmpty())
+			Expect(actions(client)).To(Equal([]string{
+				"delete namespaces/kubecf",
+				"delete namespaces/cf-operator",
+			}))
+		})
+	})
+
+	Describe("Audit", func() {
+		It("records the failures with their class, and filters by component", func() {
+			cluster, _ := newCluster(namespace("cf-operator"))
+			quarks, err := GlobalCatalog.Deployment("quarks", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			inst := kubernetes.NewInstaller()
+			inst.Component = "quarks"
+			Expect(inst.Install(ctx, quarks, *cluster)).ToNot(Succeed())
+			Expect(inst.Delete(ctx, quarks, *cluster)).To(Succeed())
+
+			records, err := cluster.AuditHistory(ctx, "quarks")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Operation).To(Equal("install"))
+			Expect(records[0].Result).To(Equal(kubernetes.AuditFailure))
+			Expect(records[0].Error).To(ContainSubstring("present already"))
+			Expect(records[0].Class).To(Equal(kubernetes.ClassPreflight))
+			Expect(records[1].Operation).To(Equal("delete"))
+			Expect(records[1].Result).To(Equal(kubernetes.AuditSuccess))
+
+			Expect(cluster.AuditHistory(ctx, "kubecf")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,118 @@
+package deployments
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+)
+
+// ccInstanceGroups are the KubeCF instance groups writing to the CCDB and
+// to the blobstore: the API, the workers and the clock (in scheduler)
+var ccInstanceGroups = []string{"api", "cc-worker", "scheduler"}
+
+// quiescedAnnotation records on a StatefulSet the replicas it had before
+// being scaled down, so they can be recovered if kubecfctl is interrupted
+const quiescedAnnotation = "kubecfctl.io/quiesced-replicas"
+
+// quiesceCC scales down the Cloud Controller instance groups of namespace
+// and waits for their pods to be gone. It returns the original replicas of
+// every StatefulSet it scaled, to be passed to resumeCC
+func quiesceCC(ctx context.Context, c kubernetes.Cluster, namespace string, t kubernetes.PhaseTimeout) (map[string]int32, error) {
+	replicas := map[string]int32{}
+	for _, ig := range ccInstanceGroups {
+		selector := "quarks.cloudfoundry.org/quarks-statefulset-name=" + ig
+		sets, err := c.ListStatefulSets(ctx, namespace, selector)
+		if err != nil {
+			return replicas, errors.Wrap(err, "while listing "+ig)
+		}
+		for _, set := range sets.Items {
+			// Keep the replicas recorded by an interrupted run, the
+			// StatefulSet is still scaled down
+			original := *set.Spec.Replicas
+			if v, ok := set.Annotations[quiescedAnnotation]; ok {
+				if r, err := strconv.Atoi(v); err == nil {
+					original = int32(r)
+				}
+			} else if err := c.AnnotateStatefulSet(ctx, namespace, set.Name, quiescedAnnotation, strconv.Itoa(int(original))); err != nil {
+				return replicas, errors.Wrap(err, "while recording replicas of "+set.Name)
+			}
+			replicas[set.Name] = original
+
+			if _, err := c.ScaleStatefulSet(ctx, namespace, set.Name, 0); err != nil {
+				return replicas, errors.Wrap(err, "while scaling down "+set.Name)
+			}
+		}
+		if err := c.WaitUntilPodBySelectorGone(ctx, namespace, selector, t); err != nil {
+			return replicas, errors.Wrap(err, "failed waiting for "+ig+" to stop")
+		}
+	}
+	helpers.Info(PhaseQuiesce, ":pause_button: Cloud Controller stopped in "+namespace)
+	return replicas, nil
+}
+
+// resumeCC scales back the StatefulSets stopped by quiesceCC
+func resumeCC(ctx context.Context, c kubernetes.Cluster, namespace string, replicas map[string]int32) error {
+	var result error
+	for name, r := range replicas {
+		if _, err := c.ScaleStatefulSet(ctx, namespace, name, r); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "while scaling up "+name))
+			continue
+		}
+		if err := c.AnnotateStatefulSet(ctx, namespace, name, quiescedAnnotation, ""); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "while clearing recorded replicas of "+name))
+		}
+	}
+	if result == nil {
+		helpers.Info(PhaseQuiesce, ":arrow_forward: Cloud Controller resumed in "+namespace)
+	}
+	return kubernetes.Partial(result)
+}
+
+// recoverCC resumes the Cloud Controller instance groups left scaled down
+// by an interrupted run
+func recoverCC(ctx context.Context, c kubernetes.Cluster, namespace string) error {
+	replicas := map[string]int32{}
+	for _, ig := range ccInstanceGroups {
+		sets, err := c.ListStatefulSets(ctx, namespace, "quarks.cloudfoundry.org/quarks-statefulset-name="+ig)
+		if err != nil {
+			return err
+		}
+		for _, set := range sets.Items {
+			if v, ok := set.Annotations[quiescedAnnotation]; ok {
+				if r, err := strconv.Atoi(v); err == nil {
+					replicas[set.Name] = int32(r)
+				}
+			}
+		}
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+	helpers.Warning(PhaseQuiesce, ":warning: Cloud Controller in "+namespace+" was left stopped by an interrupted run, resuming it")
+	return resumeCC(ctx, c, namespace, replicas)
+}
+
+// whileQuiesced runs fn with the Cloud Controller of namespace stopped, and
+// resumes it afterwards, even if fn fails or ctx is cancelled
+func whileQuiesced(ctx context.Context, c kubernetes.Cluster, namespace string, t kubernetes.PhaseTimeout, fn func() error) (err error) {
+	replicas, err := quiesceCC(ctx, c, namespace, t)
+	defer func() {
+		// Resume even if interrupted, so the Cloud Controller isn't left
+		// stopped
+		if ctx.Err() != nil {
+			helpers.Warning(PhaseQuiesce, ":warning: Interrupted, resuming Cloud Controller in "+namespace)
+		}
+		if resumeErr := resumeCC(context.Background(), c, namespace, replicas); resumeErr != nil && err == nil {
+			err = resumeErr
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	return fn()
+}
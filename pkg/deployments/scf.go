@@ -11,13 +11,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/briandowns/spinner"
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type SCF struct {
@@ -33,8 +30,11 @@ type SCF struct {
 
 	AdditionalNamespaces []string
 
+	// Include and Exclude select the data sets to backup and restore
+	Include, Exclude []string
+
 	Eirini, Ingress, Autoscaler, LB bool
-	Timeout                         int
+	Timeouts                        kubernetes.Timeouts
 }
 
 func (k *SCF) SetDomain(d string) {
@@ -45,62 +45,81 @@ func (k SCF) GetDomain() string {
 	return k.domain
 }
 
+func (k SCF) GetNamespace() string {
+	return k.Namespace
+}
+
 func (k SCF) GetVersion() string {
 	return k.Version
 }
 
 func (k SCF) Describe() string {
-	return emoji.Sprintf(":cloud: SCF version: %s\n:clipboard:SCF chart: %s", k.Version, k.ChartURL)
+	return fmt.Sprintf(":cloud: SCF version: %s\n:clipboard:SCF chart: %s", k.Version, k.ChartURL)
 }
 
-func (k SCF) Delete(c kubernetes.Cluster) error {
-	//currentdir, _ := os.Getwd()
-
-	c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace, metav1.DeleteOptions{})
-	c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace+"-eirini", metav1.DeleteOptions{})
+func (k SCF) Delete(ctx context.Context, c kubernetes.Cluster) error {
+	if err := c.DeleteNamespaces(ctx, k.Timeouts.Phase(PhaseDelete), k.Namespace, k.Namespace+"-eirini"); err != nil {
+		return err
+	}
 
-	emoji.Println(":heavy_check_mark: SCF deleted")
+	helpers.Success(PhaseSCF, ":heavy_check_mark: SCF deleted")
 
 	return nil
 }
 
-func (k SCF) GetPassword(namespace string, c kubernetes.Cluster) (string, error) {
+// Owned selects the cluster wide resources installed by SCF
+func (k SCF) Owned() kubernetes.Owned {
+	return kubernetes.Owned{Namespaces: []string{k.Namespace, k.Namespace + "-eirini"}}
+}
+
+func (k SCF) GetPassword(ctx context.Context, namespace string, c kubernetes.Cluster) (string, error) {
 	return "admin", nil
-	// secret, err := c.Kubectl.CoreV1().Secrets(namespace).Get(context.TODO(), "var-cf-admin-password", metav1.GetOptions{})
+	// secret, err := c.Kubectl.CoreV1().Secrets(namespace).Get(ctx, "var-cf-admin-password", metav1.GetOptions{})
 	// if err != nil {
 	// 	return "", errors.Wrap(err, "couldn't find password secret")
 	// }
 	// return string(secret.Data["password"]), nil
 }
 
-// TODO
-
-func (k SCF) genHelmSettings(c kubernetes.Cluster, domain, ns string) []string {
-	var helmArgs []string
-	//helmArgs = append(helmArgs, "--set system_domain="+domain)
-
+// encryptionValues writes the CCDB encryption keys restored from a backup
+// to a values file in dir, and returns its path. The keys are JSON, which
+// can't be passed safely with --set
+func (k SCF) encryptionValues(dir string) (string, error) {
+	values := map[string]map[string]string{
+		"secrets": {},
+		"env":     {},
+	}
 	if len(k.ccdbEncKey) != 0 {
-		//	helmArgs = append(helmArgs, "--set credentials.cc_db_encryption_key="+k.ccdbEncKey)
+		values["secrets"]["DB_ENCRYPTION_KEY"] = k.ccdbEncKey
 	}
 	if len(k.encKeys) != 0 {
-		// i := 0
-		// for label, key := range k.encKeys {
-		// 	helmArgs = append(helmArgs, "--set ccdb.encryption.rotation.key_labels["+strconv.Itoa(i)+"]="+label)
-		// 	helmArgs = append(helmArgs, "--set credentials.ccdb_key_label_"+label+"="+key)
-		// 	i++
-		// }
-		// helmArgs = append(helmArgs, "--set credentials.cc_db_encryption_key="+k.ccdbEncKey)
+		keys, err := json.Marshal(k.encKeys)
+		if err != nil {
+			return "", err
+		}
+		values["secrets"]["CC_DB_ENCRYPTION_KEYS"] = string(keys)
+	}
+	if len(k.currentKey) != 0 {
+		values["env"]["CC_DB_CURRENT_KEY_LABEL"] = k.currentKey
 	}
 
+	dat, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, "encryption.yaml")
+	return file, ioutil.WriteFile(file, dat, 0600)
+}
+
+func (k SCF) genHelmSettings(ctx context.Context, c kubernetes.Cluster, domain, ns string) []string {
+	var helmArgs []string
+
 	helmArgs = append(helmArgs, "--set secrets.CLUSTER_ADMIN_PASSWORD=admin")
 	helmArgs = append(helmArgs, "--set secrets.UAA_ADMIN_CLIENT_SECRET=admin")
 	helmArgs = append(helmArgs, "--set enable.uaa=true")
 	helmArgs = append(helmArgs, "--set env.DOMAIN="+domain)
 	helmArgs = append(helmArgs, "--set env.UAA_HOST=uaa."+domain)
 
-	if len(k.currentKey) != 0 {
-		//	helmArgs = append(helmArgs, "--set ccdb.encryption.rotation.current_key_label="+k.currentKey)
-	}
 	if k.Eirini {
 		helmArgs = append(helmArgs, "--set enable.eirini=true")
 	}
@@ -128,175 +147,231 @@ func (k SCF) genHelmSettings(c kubernetes.Cluster, domain, ns string) []string {
 	return helmArgs
 }
 
-func (k SCF) Restore(c kubernetes.Cluster, output string) error {
+// SCF 2.x keeps all the databases in mysql-0, and its clients read the
+// credentials from the mysql job configuration
+const (
+	scfMySQL     = "/var/vcap/packages/mariadb/bin/mysql --defaults-file=/var/vcap/jobs/mysql/config/mylogin.cnf"
+	scfMySQLDump = "/var/vcap/packages/mariadb/bin/mysqldump --defaults-file=/var/vcap/jobs/mysql/config/mylogin.cnf"
+)
 
-	err := k.Deploy(c)
-	if err != nil {
-		return errors.Wrap(err, "while deploying kubecf")
-	}
+// scfDataSets are the data sets which can be captured from SCF. Its
+// secrets are regenerated by the chart, and it doesn't ship CredHub
+var scfDataSets = []string{DataUAA, DataCCDB, DataBlobstore, DataConfig}
 
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
-	s.Suffix = " Extracting encryption configuration"
+// scfDatabases maps the data sets to the SCF database names
+var scfDatabases = map[string]string{
+	DataUAA:  "uaadb",
+	DataCCDB: "ccdb",
+}
 
-	dat, err := ioutil.ReadFile(filepath.Join(output, "cc_config.yaml"))
+func (k SCF) dataSets(available []string) ([]string, error) {
+	dataSets, err := SelectDataSets(available, k.Include, k.Exclude)
 	if err != nil {
-		return errors.Wrap(err, "while reading cc_config.yaml")
+		return nil, err
 	}
-	config := ccConfig{}
-	err = yaml.Unmarshal(dat, &config)
-	if err != nil {
-		return errors.Wrap(err, "while unmarshalling cc_config.yaml")
+	for _, d := range dataSets {
+		if !contains(scfDataSets, d) {
+			return nil, kubernetes.UsageError{Err: errors.New(d + " is not supported by SCF")}
+		}
 	}
+	return dataSets, nil
+}
 
-	var keys map[string]string
-
-	err = json.Unmarshal([]byte(config.Encryption.Keys), &keys)
-	if err != nil {
-		return errors.Wrap(err, "while unmarshalling encryption keys")
-	}
-	k.encKeys = keys
-	k.ccdbEncKey = config.DbKey
-	k.currentKey = config.Encryption.Current
-
-	s.Suffix = " Disable db restrictions"
-	out, stderr, err := c.Exec(k.Namespace, "database-0", "database", "mysql", `SET GLOBAL pxc_strict_mode=PERMISSIVE;
-SET GLOBAL
-sql_mode='STRICT_ALL_TABLES,NO_AUTO_CREATE_USER,NO_ENGINE_SUBSTITUTION';
-set GLOBAL innodb_strict_mode='OFF';
-quit;
-`)
+func (k SCF) Restore(ctx context.Context, c kubernetes.Cluster, output string) error {
+	manifest, err := ReadBackupManifest(output, k.Namespace)
 	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while disabling db restrictions")
+		return err
+	}
+	if manifest.Deployment != "" && manifest.Deployment != "scf" {
+		return kubernetes.PreflightError{Err: errors.New("the backup is of " + manifest.Deployment + ", not scf")}
 	}
 
-	dat, err = ioutil.ReadFile(filepath.Join(output, "uaadb-src.sql"))
+	dataSets, err := k.dataSets(manifest.DataSets)
 	if err != nil {
-		return errors.Wrap(err, "while reading up uaa backup")
+		return err
 	}
-	s.Suffix = " Restoring UAA"
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql uaa", string(dat))
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up uaa db")
+	if err := manifest.checkRestorable(dataSets); err != nil {
+		return errors.Wrap(err, "cannot restore the backup")
+	}
+	output = manifest.Dir(output, manifest.Namespace)
+	if err := checkDumps(output, dataSets); err != nil {
+		return err
 	}
 
-	s.Suffix = " Restoring Blobstore"
+	if contains(dataSets, DataConfig) {
+		dat, err := ioutil.ReadFile(filepath.Join(output, "cc_config.yaml"))
+		if err != nil {
+			return errors.Wrap(err, "while reading cc_config.yaml")
+		}
+		config := ccConfig{}
+		err = yaml.Unmarshal(dat, &config)
+		if err != nil {
+			return errors.Wrap(err, "while unmarshalling cc_config.yaml")
+		}
 
-	_, err = helpers.RunProcNoErr("kubectl exec --namespace "+k.Namespace+" singleton-blobstore-0 -- tar xfz - -C < blob.tgz", output, k.Debug)
-	if err != nil {
-		return errors.Wrap(err, "while restoring up blobstore")
+		var keys map[string]string
+		if config.Encryption.Keys != "" {
+			err = json.Unmarshal([]byte(config.Encryption.Keys), &keys)
+			if err != nil {
+				return errors.Wrap(err, "while unmarshalling encryption keys")
+			}
+		}
+		helpers.RegisterSecret(config.DbKey)
+		for _, key := range keys {
+			helpers.RegisterSecret(key)
+		}
+		k.encKeys = keys
+		k.ccdbEncKey = config.DbKey
+		k.currentKey = config.Encryption.Current
+		if k.domain == "" {
+			k.domain = config.SystemDomain
+		}
 	}
-	_, err = helpers.RunProcNoErr("kubectl delete pod --namespace "+k.Namespace+" singleton-blobstore-0", output, k.Debug)
+
+	// The encryption keys are set from the start, so the Cloud Controller
+	// never runs with different ones
+	err = k.Deploy(ctx, c)
 	if err != nil {
-		return errors.Wrap(err, "while restarting blobstore")
+		return errors.Wrap(err, "while deploying scf")
 	}
 
-	s.Suffix = " Restoring CCDB"
+	s := helpers.Wait(PhaseSCF, "Stopping Cloud Controller")
+	defer s.Done()
 
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql", `drop database cloud_controller; 
-create database	cloud_controller;
-quit;
-`)
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while pruning cc db")
+	for _, pod := range []string{"api-group-0", "cc-worker-0", "cc-clock-0"} {
+		out, stderr, err := c.Exec(ctx, k.Namespace, pod, strings.TrimSuffix(pod, "-0"), "monit stop all", "")
+		if err != nil {
+			helpers.Output(PhaseSCF, out)
+			helpers.Output(PhaseSCF, stderr)
+			return errors.Wrap(err, "while stopping "+pod)
+		}
 	}
 
-	dat, err = ioutil.ReadFile(filepath.Join(output, "ccdb-src.sql"))
-	if err != nil {
-		return errors.Wrap(err, "while reading up ccdb backup")
+	if contains(dataSets, DataUAA) {
+		s.Update(helpers.Event{Message: "Restoring UAA"})
+		if err := k.loadDatabase(ctx, c, scfDatabases[DataUAA], dumpFile(output, DataUAA)); err != nil {
+			return err
+		}
 	}
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql cloud_controller", string(dat))
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up ccdb db")
+
+	if contains(dataSets, DataBlobstore) {
+		s.Update(helpers.Event{Message: "Restoring Blobstore"})
+		if err := extractBlobstore(ctx, c, k.Namespace, "blobstore-0", output); err != nil {
+			return errors.Wrap(err, "while restoring blobstore")
+		}
 	}
 
-	err = k.Upgrade(c)
-	if err != nil {
-		return errors.Wrap(err, "while deploying kubecf")
+	if contains(dataSets, DataCCDB) {
+		s.Update(helpers.Event{Message: "Restoring CCDB"})
+		if err := k.loadDatabase(ctx, c, scfDatabases[DataCCDB], dumpFile(output, DataCCDB)); err != nil {
+			return err
+		}
 	}
-	return nil
 
-}
+	// Restarting the pods starts the Cloud Controller with the restored data
+	s.Update(helpers.Event{Message: "Restarting Cloud Controller"})
+	for _, pod := range []string{"blobstore-0", "api-group-0", "cc-worker-0", "cc-clock-0"} {
+		if err := c.DeletePod(ctx, k.Namespace, pod); err != nil {
+			return errors.Wrap(err, "while restarting "+pod)
+		}
+	}
+	s.Done()
 
-func (k SCF) Backup(c kubernetes.Cluster, output string) error {
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
+	return k.waitForSCF(ctx, c, k.Namespace)
+}
 
-	s.Suffix = " Backing up uaa"
-	out, stderr, err := c.Exec(k.Namespace, "database-0", "database", "mysqldump --skip-lock-tables uaa > uaa.sql && cat uaa.sql && rm -rf uaa.sql", "")
+// loadDatabase drops the database in mysql-0 and loads the dump in file. The
+// dump is read first, so the database is kept if it can't be
+func (k SCF) loadDatabase(ctx context.Context, c kubernetes.Cluster, database, file string) error {
+	dat, err := ioutil.ReadFile(file)
 	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up uaa db")
+		return errors.Wrap(err, "while reading up "+database+" backup")
 	}
-	err = ioutil.WriteFile(filepath.Join(output, "uaadb-src.sql"), []byte(out), 0644)
+
+	out, stderr, err := c.Exec(ctx, k.Namespace, "mysql-0", "mysql", scfMySQL+" -e 'drop database "+database+"; create database "+database+";'", "")
 	if err != nil {
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up uaa db")
+		helpers.Output(PhaseSCF, out)
+		helpers.Output(PhaseSCF, stderr)
+		return errors.Wrap(err, "while pruning "+database)
 	}
 
-	s.Suffix = " Backing up ccdb"
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysqldump --max_allowed_packet=1G --single-transaction --quick --lock-tables=false cloud_controller > cc.sql; cat cc.sql && rm -rf cc.sql", "")
+	out, stderr, err = c.Exec(ctx, k.Namespace, "mysql-0", "mysql", scfMySQL+" "+database, string(dat))
 	if err != nil {
-		fmt.Println(out)
-
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up ccdb db")
+		helpers.Output(PhaseSCF, out)
+		helpers.Output(PhaseSCF, stderr)
+		return errors.Wrap(err, "while restoring "+database)
 	}
-	err = ioutil.WriteFile(filepath.Join(output, "ccdb-src.sql"), []byte(out), 0644)
+	return nil
+}
+
+func (k SCF) Backup(ctx context.Context, c kubernetes.Cluster, output string) error {
+	dataSets, err := k.dataSets(scfDataSets)
 	if err != nil {
-		return errors.Wrap(err, "while backing up ccdb db")
+		return err
 	}
 
-	s.Suffix = " Backing up blobstore"
-	_, err = helpers.RunProcNoErr("kubectl exec --namespace "+k.Namespace+" singleton-blobstore-0 -- tar cfz - --exclude=/var/vcap/store/shared/tmp /var/vcap/store/shared > blob.tgz", output, k.Debug)
-	if err != nil {
-		return errors.Wrap(err, "while backing up blobstore")
+	manifest := BackupManifest{
+		Deployment: "scf",
+		Version:    k.Version,
+		Date:       time.Now().UTC(),
+		Namespace:  k.Namespace,
+		Namespaces: []string{k.Namespace},
+		DataSets:   dataSets,
+	}
+	dir := manifest.Dir(output, k.Namespace)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
 	}
 
-	s.Suffix = " Disable db restrictions"
-	out, stderr, err = c.Exec(k.Namespace, "database-0", "database", "mysql", `SET GLOBAL pxc_strict_mode=PERMISSIVE;
-SET GLOBAL
-sql_mode='STRICT_ALL_TABLES,NO_AUTO_CREATE_USER,NO_ENGINE_SUBSTITUTION';
-set GLOBAL innodb_strict_mode='OFF';
-quit;
-`)
-	if err != nil {
-		fmt.Println(out)
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while disabling db restrictions")
+	s := helpers.Wait(PhaseSCF, "")
+	defer s.Done()
+
+	for _, d := range []string{DataUAA, DataCCDB} {
+		if !contains(dataSets, d) {
+			continue
+		}
+		database := scfDatabases[d]
+		s.Update(helpers.Event{Message: "Backing up " + database})
+		out, stderr, err := c.Exec(ctx, k.Namespace, "mysql-0", "mysql", scfMySQLDump+" "+database+" > "+database+".sql && cat "+database+".sql && rm -rf "+database+".sql", "")
+		if err != nil {
+			helpers.Output(PhaseSCF, out)
+			helpers.Output(PhaseSCF, stderr)
+			return errors.Wrap(err, "while backing up "+database)
+		}
+		err = ioutil.WriteFile(dumpFile(dir, d), []byte(out), 0644)
+		if err != nil {
+			return errors.Wrap(err, "while backing up "+database)
+		}
 	}
 
-	s.Suffix = " Backing up cloud_controller_ng.yml"
-	out, stderr, err = c.Exec(k.Namespace, "api-0", "cloud-controller-ng-cloud-controller-ng", "cat /var/vcap/jobs/cloud_controller_ng/config/cloud_controller_ng.yml", "")
-	if err != nil {
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up cc config")
+	if contains(dataSets, DataBlobstore) {
+		s.Update(helpers.Event{Message: "Backing up blobstore"})
+		if err := archiveBlobstore(ctx, c, k.Namespace, "blobstore-0", dir); err != nil {
+			return errors.Wrap(err, "while backing up blobstore")
+		}
 	}
-	err = ioutil.WriteFile(filepath.Join(output, "cc_config.yaml"), []byte(out), 0644)
-	if err != nil {
-		fmt.Println(stderr)
-		return errors.Wrap(err, "while backing up cc config")
+
+	if contains(dataSets, DataConfig) {
+		s.Update(helpers.Event{Message: "Backing up cloud_controller_ng.yml"})
+		out, stderr, err := c.Exec(ctx, k.Namespace, "api-group-0", "api-group", "cat /var/vcap/jobs/cloud_controller_ng/config/cloud_controller_ng.yml", "")
+		if err != nil {
+			helpers.Output(PhaseSCF, stderr)
+			return errors.Wrap(err, "while backing up cc config")
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "cc_config.yaml"), []byte(out), 0644)
+		if err != nil {
+			return errors.Wrap(err, "while backing up cc config")
+		}
 	}
 
-	return nil
+	return manifest.Write(output)
 }
 
-func (k SCF) applySCF(namespace, domain string, c kubernetes.Cluster, upgrade, psp bool) error {
+func (k SCF) applySCF(ctx context.Context, namespace, domain string, c kubernetes.Cluster, upgrade, psp bool) error {
 	currentdir, _ := os.Getwd()
 
 	// Setup KubeCF helm values
-	helmArgs := k.genHelmSettings(c, domain, namespace)
+	helmArgs := k.genHelmSettings(ctx, c, domain, namespace)
 
 	action := "install"
 	if upgrade {
@@ -306,75 +381,106 @@ func (k SCF) applySCF(namespace, domain string, c kubernetes.Cluster, upgrade, p
 		//helmArgs = append(helmArgs, "--set kube.psp.default=kubecf-default")
 	}
 
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	out, err := helpers.RunProc("helm "+action+" scf --namespace "+namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug)
+	if len(k.ccdbEncKey) != 0 || len(k.encKeys) != 0 || len(k.currentKey) != 0 {
+		dir, err := ioutil.TempDir(os.TempDir(), "kubecfctl")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		values, err := k.encryptionValues(dir)
+		if err != nil {
+			return errors.Wrap(err, "while writing encryption values")
+		}
+		helmArgs = append(helmArgs, "-f "+values)
+	}
+
+	s := helpers.Wait(PhaseSCF, "")
+	out, err := runHelm(ctx, action+" scf --namespace "+namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug)
+	s.Done()
 	if err != nil {
-		fmt.Println(string(out))
-		return errors.New("Failed installing scf")
-	}
-	// TODO
-	s.Stop()
-	// Wait for components to be up
-	for _, s := range []string{"api", "nats", "cc-worker", "doppler"} {
-		err = c.WaitUntilPodBySelectorExist(namespace, "quarks.cloudfoundry.org/quarks-statefulset-name="+s, k.Timeout)
+		helpers.Output(PhaseSCF, out)
+		return errors.Wrap(err, "Failed installing scf")
+	}
+
+	return k.waitForSCF(ctx, c, namespace)
+}
+
+// waitForSCF waits for the SCF roles to be up and running
+func (k SCF) waitForSCF(ctx context.Context, c kubernetes.Cluster, namespace string) error {
+	for _, s := range []string{"mysql", "api-group", "nats", "cc-worker", "blobstore"} {
+		err := c.WaitUntilPodBySelectorExist(ctx, namespace, "skiff-role-name="+s, k.Timeouts.Phase(PhaseSCF))
 		if err != nil {
-			return errors.Wrap(err, "Failed waiting for api")
+			return errors.Wrap(err, "Failed waiting for "+s)
 		}
 	}
 
-	err = c.WaitForPodBySelectorRunning(namespace, "app.kubernetes.io/name=kubecf", k.Timeout)
+	err := c.WaitForPodBySelectorRunning(ctx, namespace, "app.kubernetes.io/instance=scf", k.Timeouts.Phase(PhaseSCF))
 	if err != nil {
-		return errors.Wrap(err, "failed waiting for kubecf to be ready")
+		return errors.Wrap(err, "failed waiting for scf to be ready")
 	}
-	emoji.Println(":heavy_check_mark: KubeCF deployed correctly to the :rainbow: :cloud:")
+	helpers.Success(PhaseSCF, ":heavy_check_mark: SCF deployed correctly to the :rainbow: :cloud:")
 	return nil
 }
 
-func (k SCF) Deploy(c kubernetes.Cluster) error {
+func (k SCF) Deploy(ctx context.Context, c kubernetes.Cluster) error {
 	//currentdir, _ := os.Getwd()
 
 	if k.Ingress {
-		_, err := c.Kubectl.CoreV1().Namespaces().Get(
-			context.Background(),
-			"nginx-ingress",
-			metav1.GetOptions{},
-		)
+		exists, err := c.NamespaceExists(ctx, "nginx-ingress")
 		if err != nil {
+			return err
+		}
+		if !exists {
 			nginx, err := GlobalCatalog.GetNginx("3.7.1")
 			if err != nil {
 				return err
 			}
+			nginx.Timeouts = k.Timeouts
 
-			err = nginx.Deploy(c)
+			err = nginx.Deploy(ctx, c)
 			if err != nil {
 				return err
 			}
 		} else {
-			emoji.Println(":ship:Nginx already present. Delete if you want to test cleanly")
+			helpers.Info(PhaseSCF, ":ship:Nginx already present. Delete if you want to test cleanly")
 		}
 	}
 
-	emoji.Println(":ship:Deploying SCF")
+	helpers.Info(PhaseSCF, ":ship:Deploying SCF")
 
-	if err := k.applySCF(k.Namespace, k.domain, c, false, true); err != nil {
+	if err := k.applySCF(ctx, k.Namespace, k.domain, c, false, true); err != nil {
 		return errors.Wrap(err, "while deploying kubecf")
 	}
 
-	pwd, err := k.GetPassword(k.Namespace, c)
+	pwd, err := k.GetPassword(ctx, k.Namespace, c)
 	if err != nil {
 		return errors.Wrap(err, "couldn't find password")
 	}
 
-	emoji.Println(":lock:CF Deployment ready, now you can login with: cf login --skip-ssl-validation -a https://api." + k.domain + " -u admin -p " + string(pwd))
+	helpers.Info(PhaseSCF, ":lock:CF Deployment ready, now you can login with: cf login --skip-ssl-validation -a https://api."+k.domain+" -u admin -p "+string(pwd))
+	return nil
+}
+
+func (k SCF) Rollback(ctx context.Context, c kubernetes.Cluster, revision int) error {
+	plan, err := rollbackPlan(ctx, helmRelease{Name: "scf", Namespace: k.Namespace}, revision, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := rollbackReleases(ctx, plan, nil, k.Debug); err != nil {
+		return err
+	}
+	if err := k.waitForSCF(ctx, c, k.Namespace); err != nil {
+		return err
+	}
+	helpers.Success(PhaseSCF, ":heavy_check_mark: SCF rolled back")
 	return nil
 }
 
-func (k SCF) Upgrade(c kubernetes.Cluster) error {
+func (k SCF) Upgrade(ctx context.Context, c kubernetes.Cluster) error {
 
-	emoji.Println(":ship:Upgrading SCF")
+	helpers.Info(PhaseSCF, ":ship:Upgrading SCF")
 
-	if err := k.applySCF(k.Namespace, k.domain, c, true, true); err != nil {
+	if err := k.applySCF(ctx, k.Namespace, k.domain, c, true, true); err != nil {
 		return errors.Wrap(err, "while upgrading scf")
 	}
 
@@ -0,0 +1,116 @@
+package deployments_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	scfMySQL     = "mysql-0 /var/vcap/packages/mariadb/bin/mysql --defaults-file=/var/vcap/jobs/mysql/config/mylogin.cnf"
+	scfMySQLDump = "mysql-0 /var/vcap/packages/mariadb/bin/mysqldump --defaults-file=/var/vcap/jobs/mysql/config/mylogin.cnf"
+)
+
+// scfCluster returns a cluster running SCF, where the pods deleted to be
+// restarted are kept
+func scfCluster() (*kubernetes.Cluster, *fake.Clientset) {
+	var pods []runtime.Object
+	for _, role := range []string{"mysql", "api-group", "nats", "cc-worker", "cc-clock", "blobstore"} {
+		pod := runningPod("scf", role+"-0", map[string]string{
+			"skiff-role-name":            role,
+			"app.kubernetes.io/instance": "scf",
+		})
+		pod.Spec.Containers = []v1.Container{{Name: role}}
+		pods = append(pods, pod)
+	}
+	cluster, client := newCluster(pods...)
+	client.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	return cluster, client
+}
+
+var _ = Describe("SCF", func() {
+	ctx := context.Background()
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "kubecfctl-test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	backup := func() {
+		cluster, _ := scfCluster()
+		exec := newFakeExec(cluster)
+		exec.Outputs[scfMySQLDump+" uaadb"] = "UAA DUMP"
+		exec.Outputs[scfMySQLDump+" ccdb"] = "CCDB DUMP"
+		exec.Outputs["api-group-0 cat"] = "system_domain: example.com\ndb_encryption_key: key\n"
+		exec.Outputs["blobstore-0 tar cfz"] = "BLOBS"
+		scf, err := GlobalCatalog.Deployment("scf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(scf.Backup(ctx, *cluster, dir)).To(Succeed())
+	}
+
+	Describe("Backup", func() {
+		It("saves the databases, the blobstore and the CC config", func() {
+			backup()
+
+			for file, content := range map[string]string{
+				"uaadb-src.sql":  "UAA DUMP",
+				"ccdb-src.sql":   "CCDB DUMP",
+				"blob.tgz":       "BLOBS",
+				"cc_config.yaml": "system_domain: example.com\ndb_encryption_key: key",
+			} {
+				dat, err := ioutil.ReadFile(filepath.Join(dir, "scf", file))
+				Expect(err).ToNot(HaveOccurred(), file)
+				Expect(string(dat)).To(Equal(content), file)
+			}
+		})
+	})
+
+	Describe("Restore", func() {
+		It("loads the databases it backed up", func() {
+			backup()
+			cluster, _ := scfCluster()
+			exec := newFakeExec(cluster)
+			scf, err := GlobalCatalog.Deployment("scf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(scf.Restore(ctx, *cluster, dir)).To(Succeed())
+			Expect(exec.Stdin(scfMySQL + " uaadb")).To(Equal("UAA DUMP"))
+			Expect(exec.Stdin(scfMySQL + " ccdb")).To(Equal("CCDB DUMP"))
+			Expect(exec.Stdin("blobstore-0 tar xfz - -C /")).To(Equal("BLOBS"))
+			Expect(runner.Commands()).To(ConsistOf(And(HavePrefix("helm install scf --namespace scf "), ContainSubstring("--set env.DOMAIN=example.com"))))
+		})
+
+		It("fails before changing anything when a database dump is missing", func() {
+			backup()
+			Expect(os.Remove(filepath.Join(dir, "scf", "ccdb-src.sql"))).To(Succeed())
+			cluster, _ := scfCluster()
+			exec := newFakeExec(cluster)
+			scf, err := GlobalCatalog.Deployment("scf", DeploymentOptions{Timeouts: kubernetes.Timeouts{Default: time.Second}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(scf.Restore(ctx, *cluster, dir)).To(MatchError(ContainSubstring("the ccdb database dump is missing from the backup")))
+			Expect(exec.Commands()).To(BeEmpty())
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+	})
+})
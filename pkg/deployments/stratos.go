@@ -2,15 +2,14 @@ package deployments
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/mudler/kubecfctl/pkg/kubernetes"
 	"github.com/pkg/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type Stratos struct {
@@ -21,7 +20,7 @@ type Stratos struct {
 	Debug     bool
 
 	LB, Ingress bool
-	Timeout     int
+	Timeouts    kubernetes.Timeouts
 }
 
 func (k *Stratos) SetDomain(d string) {
@@ -32,19 +31,33 @@ func (k Stratos) GetDomain() string {
 	return k.domain
 }
 
+func (k Stratos) GetNamespace() string {
+	return k.Namespace
+}
+
 func (k Stratos) Describe() string {
-	return emoji.Sprintf(":cloud:Stratos version: %s\n:clipboard:Stratos chart: %s", k.Version, k.ChartURL)
+	return fmt.Sprintf(":cloud:Stratos version: %s\n:clipboard:Stratos chart: %s", k.Version, k.ChartURL)
+}
+
+func (k Stratos) Delete(ctx context.Context, c kubernetes.Cluster) error {
+	return c.DeleteNamespaces(ctx, k.Timeouts.Phase(PhaseDelete), k.Namespace)
 }
 
-func (k Stratos) Delete(c kubernetes.Cluster) error {
-	return c.Kubectl.CoreV1().Namespaces().Delete(context.Background(), k.Namespace, metav1.DeleteOptions{})
+// Owned selects the cluster wide resources installed by Stratos
+func (k Stratos) Owned() kubernetes.Owned {
+	return kubernetes.Owned{Namespaces: []string{k.Namespace}}
 }
 
 func (k Stratos) GetVersion() string {
 	return k.Version
 }
 
-func (k Stratos) apply(c kubernetes.Cluster, upgrade bool) error {
+// InstalledVersion returns the version of the stratos release in the cluster
+func (k Stratos) InstalledVersion(ctx context.Context, c kubernetes.Cluster) (string, error) {
+	return releaseVersion(ctx, "stratos", k.Namespace)
+}
+
+func (k Stratos) apply(ctx context.Context, c kubernetes.Cluster, upgrade bool) error {
 
 	action := "install"
 	if upgrade {
@@ -68,43 +81,55 @@ func (k Stratos) apply(c kubernetes.Cluster, upgrade bool) error {
 		helmArgs = append(helmArgs, "--set console.service.ingress.enabled=true")
 	}
 
-	if _, err := helpers.RunProc("helm "+action+" stratos --create-namespace --wait --namespace "+k.Namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug); err != nil {
-		return errors.New("Failed installing Stratos")
+	if _, err := runHelm(ctx, action+" stratos --create-namespace --wait --namespace "+k.Namespace+" "+k.ChartURL+" "+strings.Join(helmArgs, " "), currentdir, k.Debug); err != nil {
+		return errors.Wrap(err, "Failed installing Stratos")
 	}
 
-	return c.WaitForPodBySelectorRunning(k.Namespace, "", k.Timeout)
+	return c.WaitForPodBySelectorRunning(ctx, k.Namespace, "", k.Timeouts.Phase(PhaseStratos))
+}
+func (k *Stratos) Backup(ctx context.Context, c kubernetes.Cluster, d string) error {
+	return nil
 }
-func (k *Stratos) Backup(c kubernetes.Cluster, d string) error {
+func (k *Stratos) Restore(ctx context.Context, c kubernetes.Cluster, d string) error {
 	return nil
 }
-func (k *Stratos) Restore(c kubernetes.Cluster, d string) error {
+func (k Stratos) Rollback(ctx context.Context, c kubernetes.Cluster, revision int) error {
+	plan, err := rollbackPlan(ctx, helmRelease{Name: "stratos", Namespace: k.Namespace}, revision, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := rollbackReleases(ctx, plan, map[string]bool{"stratos": true}, k.Debug); err != nil {
+		return err
+	}
+	if err := c.WaitForPodBySelectorRunning(ctx, k.Namespace, "", k.Timeouts.Phase(PhaseStratos)); err != nil {
+		return errors.Wrap(err, "failed waiting for stratos to be ready")
+	}
+	helpers.Success(PhaseStratos, ":heavy_check_mark: Stratos rolled back")
 	return nil
 }
-func (k Stratos) Deploy(c kubernetes.Cluster) error {
-
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		k.Namespace,
-		metav1.GetOptions{},
-	)
-	if err == nil {
-		return errors.New("Namespace " + k.Namespace + " present already, run 'kubecfctl nginx-ingress delete " + k.Version + "' first")
+func (k Stratos) Deploy(ctx context.Context, c kubernetes.Cluster) error {
+
+	exists, err := c.NamespaceExists(ctx, k.Namespace)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace " + k.Namespace + " present already, run 'kubecfctl nginx-ingress delete " + k.Version + "' first")}
 	}
 
-	emoji.Println(":ship:Deploying Stratos")
-	return k.apply(c, false)
+	helpers.Info(PhaseStratos, ":ship:Deploying Stratos")
+	return k.apply(ctx, c, false)
 }
 
-func (k Stratos) Upgrade(c kubernetes.Cluster) error {
-	_, err := c.Kubectl.CoreV1().Namespaces().Get(
-		context.Background(),
-		k.Namespace,
-		metav1.GetOptions{},
-	)
+func (k Stratos) Upgrade(ctx context.Context, c kubernetes.Cluster) error {
+	exists, err := c.NamespaceExists(ctx, k.Namespace)
 	if err != nil {
-		return errors.New("Namespace " + k.Namespace + " not present")
+		return err
+	}
+	if !exists {
+		return kubernetes.PreflightError{Err: errors.New("Namespace " + k.Namespace + " not present")}
 	}
 
-	emoji.Println(":ship:Upgrade Stratos")
-	return k.apply(c, true)
+	helpers.Info(PhaseStratos, ":ship:Upgrade Stratos")
+	return k.apply(ctx, c, true)
 }
@@ -0,0 +1,47 @@
+package deployments_test
+
+import (
+	"context"
+
+	. "github.com/mudler/kubecfctl/pkg/deployments"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stratos", func() {
+	ctx := context.Background()
+
+	Describe("Deploy", func() {
+		It("installs the console on the platform addresses", func() {
+			cluster, _ := newCluster(runningPod("stratos", "stratos-0", nil))
+			stratos, err := GlobalCatalog.Deployment("stratos", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(stratos.Deploy(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(Equal([]string{
+				"helm install stratos --create-namespace --wait --namespace stratos https://github.com/cloudfoundry/stratos/releases/download/4.2.1/console-helm-chart-4.2.1-15dcb83ab.tgz" +
+					" --set console.service.externalIPs[0]=10.0.0.1 --set console.service.servicePort=8443 --set console.service.type=LoadBalancer",
+			}))
+		})
+	})
+
+	Describe("Upgrade", func() {
+		It("upgrades the installed console", func() {
+			cluster, _ := newCluster(namespace("stratos"), runningPod("stratos", "stratos-0", nil))
+			stratos, err := GlobalCatalog.Deployment("stratos", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(stratos.Upgrade(ctx, *cluster)).To(Succeed())
+			Expect(runner.Commands()).To(ConsistOf(HavePrefix("helm upgrade stratos --create-namespace --wait --namespace stratos ")))
+		})
+
+		It("fails when the console isn't installed", func() {
+			cluster, _ := newCluster()
+			stratos, err := GlobalCatalog.Deployment("stratos", DeploymentOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(stratos.Upgrade(ctx, *cluster)).ToNot(Succeed())
+			Expect(runner.Commands()).To(BeEmpty())
+		})
+	})
+})
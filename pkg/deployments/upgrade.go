@@ -0,0 +1,305 @@
+package deployments
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// upgradeGuard is the state of a deployment before an upgrade, used to roll
+// it back if the upgrade fails
+type upgradeGuard struct {
+	// backup is the local directory holding the pre-upgrade backup
+	backup string
+	// releases are the helm releases at their revision before the upgrade,
+	// in upgrade order
+	releases []helmRelease
+	// schemas are the database migrations applied in each namespace
+	schemas map[string]string
+}
+
+// kubecfNamespaces returns the namespaces upgraded along with KubeCF
+func (k KubeCF) kubecfNamespaces() []string {
+	return append([]string{k.Namespace}, k.AdditionalNamespaces...)
+}
+
+// schemaVersion returns the number of migrations applied to the CCDB and to
+// the UAA database of namespace, which change if an upgrade migrated them
+func (k KubeCF) schemaVersion(ctx context.Context, c kubernetes.Cluster, namespace string) (string, error) {
+	out, stderr, err := c.Exec(ctx, namespace, "database-0", "database", "mysql -N", `SELECT COUNT(*) FROM cloud_controller.schema_migrations;
+SELECT COUNT(*) FROM uaa.schema_version;
+quit;
+`)
+	if err != nil {
+		return "", errors.Wrap(err, "while reading schema migrations: "+stderr)
+	}
+	return strings.Join(strings.Fields(out), " "), nil
+}
+
+// prepareUpgrade backs up the deployment to BackupTo, or to a new directory
+// in the current one, and records its releases and schema migrations
+func (k KubeCF) prepareUpgrade(ctx context.Context, c kubernetes.Cluster) (upgradeGuard, error) {
+	guard := upgradeGuard{schemas: map[string]string{}}
+
+	guard.backup = k.BackupTo
+	if helpers.IsRemote(k.BackupTo) {
+		dir, err := ioutil.TempDir(os.TempDir(), "kubecfctl")
+		if err != nil {
+			return guard, err
+		}
+		guard.backup = dir
+	} else if guard.backup == "" {
+		currentdir, _ := os.Getwd()
+		guard.backup = filepath.Join(currentdir, "kubecfctl-pre-upgrade-"+time.Now().UTC().Format("20060102150405"))
+	}
+
+	helpers.Info(PhaseKubeCF, ":floppy_disk:Backing up before upgrading to "+guard.backup)
+	b := k
+	b.Include, b.Exclude = nil, nil
+	if err := os.MkdirAll(guard.backup, os.ModePerm); err != nil {
+		return guard, err
+	}
+	if err := b.Backup(ctx, c, guard.backup); err != nil {
+		return guard, errors.Wrap(err, "while taking the pre-upgrade backup")
+	}
+	if helpers.IsRemote(k.BackupTo) {
+		if err := helpers.Upload(ctx, guard.backup, k.BackupTo, k.Debug); err != nil {
+			return guard, err
+		}
+		helpers.Info(PhaseKubeCF, ":floppy_disk: Backup stored in "+k.BackupTo)
+	}
+
+	if !k.AutoRollback {
+		return guard, nil
+	}
+
+	r, err := currentRelease(ctx, "cf-operator", "cf-operator")
+	if err != nil {
+		return guard, err
+	}
+	guard.releases = append(guard.releases, r)
+	for _, ns := range k.kubecfNamespaces() {
+		r, err := currentRelease(ctx, "kubecf", ns)
+		if err != nil {
+			return guard, err
+		}
+		guard.releases = append(guard.releases, r)
+
+		schema, err := k.schemaVersion(ctx, c, ns)
+		if err != nil {
+			return guard, err
+		}
+		guard.schemas[ns] = schema
+	}
+	return guard, nil
+}
+
+// rollbackUpgrade rolls the releases back to the revisions recorded in
+// guard, and restores the data of the namespaces whose databases were
+// migrated in the meantime
+func (k KubeCF) rollbackUpgrade(ctx context.Context, c kubernetes.Cluster, guard upgradeGuard) error {
+	helpers.Info(PhaseKubeCF, ":rewind: Upgrade failed, rolling back")
+	for i := len(guard.releases) - 1; i >= 0; i-- {
+		r := guard.releases[i]
+		// KubeCF is rolled back first, then the operator, waiting for it
+		// to be up again so it can reconcile KubeCF
+		if err := helmRollback(ctx, r, r.Name == "cf-operator", k.Debug); err != nil {
+			return err
+		}
+	}
+
+	var result error
+	var migrated []string
+	for _, ns := range k.kubecfNamespaces() {
+		if err := c.WaitForPodBySelectorRunning(ctx, ns, "app.kubernetes.io/name=kubecf", k.Timeouts.Phase(PhaseKubeCF)); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "failed waiting for kubecf to be ready in "+ns))
+			continue
+		}
+		schema, err := k.schemaVersion(ctx, c, ns)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if schema != guard.schemas[ns] {
+			helpers.Warning(PhaseKubeCF, ":warning: Databases of "+ns+" were migrated by the upgrade, restoring them")
+			migrated = append(migrated, ns)
+		}
+	}
+	if result != nil {
+		return kubernetes.Partial(result)
+	}
+
+	if len(migrated) != 0 {
+		if err := k.restoreAfterRollback(ctx, c, guard.backup, migrated); err != nil {
+			return errors.Wrap(err, "while restoring data, the pre-upgrade backup is in "+guard.backup)
+		}
+	}
+	helpers.Success(PhaseKubeCF, ":heavy_check_mark: Rolled back to the previous release")
+	return nil
+}
+
+// restoreAfterRollback restores namespaces from the backup in dir into the
+// rolled back deployment. The rolled back releases already have the right
+// encryption keys, so no upgrade is needed afterwards
+func (k KubeCF) restoreAfterRollback(ctx context.Context, c kubernetes.Cluster, dir string, namespaces []string) error {
+	k.Include, k.Exclude = nil, nil
+	k.RestoreNamespaces = namespaces
+	_, dataSets, targets, err := k.restorePlan(dir)
+	if err != nil {
+		return err
+	}
+
+	k.secrets = map[string][]v1.Secret{}
+	if contains(dataSets, DataSecrets) {
+		for _, t := range targets {
+			secrets, err := k.readSecrets(t.dir)
+			if err != nil {
+				return errors.Wrap(err, "while reading secrets")
+			}
+			k.secrets[t.target] = secrets
+		}
+	}
+
+	k.encryption = map[string]ccEncryption{}
+	for _, t := range targets {
+		helpers.Info(PhaseKubeCF, ":floppy_disk:Restoring namespace "+t.target)
+		if err := k.restoreNamespaceInPlace(ctx, c, t, dataSets); err != nil {
+			return errors.Wrap(err, "while restoring namespace "+t.target)
+		}
+	}
+	return nil
+}
+
+// guardedUpgrade upgrades KubeCF after backing it up, and rolls it back if
+// the upgrade fails and AutoRollback is set
+func (k KubeCF) guardedUpgrade(ctx context.Context, c kubernetes.Cluster) error {
+	guard, err := k.prepareUpgrade(ctx, c)
+	if helpers.IsRemote(k.BackupTo) {
+		defer os.RemoveAll(guard.backup)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = k.upgrade(ctx, c)
+	if err != nil && ctx.Err() != nil {
+		location := guard.backup
+		if helpers.IsRemote(k.BackupTo) {
+			location = k.BackupTo
+		}
+		return errors.Wrap(err, "upgrade interrupted, the releases may be partially upgraded: run kubecfctl rollback kubecf to go back, the pre-upgrade backup is in "+location)
+	}
+	if err == nil || !k.AutoRollback {
+		return err
+	}
+	if rollbackErr := k.rollbackUpgrade(ctx, c, guard); rollbackErr != nil {
+		return kubernetes.Partial(multierror.Append(err, errors.Wrap(rollbackErr, "while rolling back")))
+	}
+	return errors.Wrap(err, "upgrade failed and was rolled back")
+}
+
+// installedVersion returns the version of the KubeCF release in the primary
+// namespace, failing if it isn't installed
+func (k KubeCF) installedVersion(ctx context.Context) (string, error) {
+	version, err := releaseVersion(ctx, "kubecf", k.Namespace)
+	if err == nil && version == "" {
+		err = kubernetes.PreflightError{Err: errors.New("kubecf is not installed in namespace " + k.Namespace)}
+	}
+	return version, err
+}
+
+// upgradePath returns the upgrades to apply, one per version, to go from
+// the installed version to k.Version. Downgrades and upgrades which aren't
+// supported are refused, as well as the ones going through intermediate
+// versions unless MultiHop is set
+func (k KubeCF) upgradePath(ctx context.Context) ([]KubeCF, error) {
+	if _, ok := quarksCompatibility[k.Version]; !ok {
+		helpers.Warning(PhaseKubeCF, ":warning: kubecf "+k.Version+" is not in the catalog, skipping upgrade path checks")
+		return []KubeCF{k}, nil
+	}
+
+	installed, err := k.installedVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := GlobalCatalog.KubeCFUpgradePath(installed, k.Version)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		// Same version, the settings are applied again
+		return []KubeCF{k}, nil
+	}
+	if len(versions) > 1 && !k.MultiHop {
+		return nil, kubernetes.PreflightError{Err: errors.New("upgrading kubecf from " + installed + " to " + k.Version + " goes through " + strings.Join(versions[:len(versions)-1], ", ") + ": upgrade to each of them first, or use --multi-hop")}
+	}
+
+	var path []KubeCF
+	previous := installed
+	for _, v := range versions {
+		step := k
+		if v != k.Version {
+			entry, err := GlobalCatalog.GetKubeCF(v)
+			if err != nil {
+				return nil, errors.Wrap(err, "kubecf "+v)
+			}
+			step.Version, step.ChartURL, step.quarksVersion = entry.Version, entry.ChartURL, entry.quarksVersion
+		}
+		// Quarks is upgraded first, so it has to run the previous version
+		// as well
+		for _, running := range []string{previous, v} {
+			if !contains(quarksCompatibility[running], step.quarksVersion) {
+				return nil, kubernetes.PreflightError{Err: errors.New("quarks " + step.quarksVersion + " doesn't support kubecf " + running)}
+			}
+		}
+		path = append(path, step)
+		previous = v
+	}
+
+	helpers.Info(PhaseKubeCF, ":world_map: Upgrade path: "+installed+" -> "+strings.Join(versions, " -> "))
+	return path, nil
+}
+
+// Rollback rolls the KubeCF release of the primary namespace back to
+// revision, the previous one if 0. The tenant namespaces and the Quarks
+// operator are rolled back to the revisions they had at that time
+func (k KubeCF) Rollback(ctx context.Context, c kubernetes.Cluster, revision int) error {
+	tenants, err := k.tenantNamespaces(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "while detecting tenant namespaces")
+	}
+	var after []helmRelease
+	for _, ns := range tenants {
+		after = append(after, helmRelease{Name: "kubecf", Namespace: ns})
+	}
+
+	plan, err := rollbackPlan(ctx,
+		helmRelease{Name: "kubecf", Namespace: k.Namespace}, revision,
+		[]helmRelease{{Name: "cf-operator", Namespace: "cf-operator"}}, after)
+	if err != nil {
+		return err
+	}
+	if err := rollbackReleases(ctx, plan, map[string]bool{"cf-operator": true}, k.Debug); err != nil {
+		return err
+	}
+
+	for _, ns := range append([]string{k.Namespace}, tenants...) {
+		if err := c.WaitUntilPodBySelectorExist(ctx, ns, "quarks.cloudfoundry.org/quarks-statefulset-name=api", k.Timeouts.Phase(PhaseKubeCF)); err != nil {
+			return errors.Wrap(err, "failed waiting for api in "+ns)
+		}
+		if err := c.WaitForPodBySelectorRunning(ctx, ns, "app.kubernetes.io/name=kubecf", k.Timeouts.Phase(PhaseKubeCF)); err != nil {
+			return errors.Wrap(err, "failed waiting for kubecf to be ready in "+ns)
+		}
+	}
+	helpers.Success(PhaseKubeCF, ":heavy_check_mark: KubeCF rolled back")
+	return nil
+}
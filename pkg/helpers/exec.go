@@ -2,23 +2,90 @@ package helpers
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
 	"os"
+	"strings"
+	"syscall"
 
 	"github.com/codeskyblue/kexec"
+	"github.com/pkg/errors"
 )
 
-func RunProc(cmd, dir string, toStdout bool) (string, error) {
+// Runner runs the external commands, like helm
+type Runner interface {
+	// Run runs cmd in dir, returning its combined output
+	Run(ctx context.Context, cmd, dir string, toStdout bool) (string, error)
+	// RunNoErr runs cmd in dir, returning its standard output only. Its
+	// standard error is in the error returned when it fails
+	RunNoErr(ctx context.Context, cmd, dir string, toStdout bool) (string, error)
+	// RunEnv runs cmd like Run, with env added to its environment. Secrets
+	// are passed this way, to keep them out of the command line
+	RunEnv(ctx context.Context, cmd, dir string, env []string, toStdout bool) (string, error)
+}
+
+// DefaultRunner runs the commands of RunProc and RunProcNoErr. It can be
+// replaced, e.g. by a RecordingRunner in tests
+var DefaultRunner Runner = ProcRunner{}
+
+func RunProc(ctx context.Context, cmd, dir string, toStdout bool) (string, error) {
+	return DefaultRunner.Run(ctx, cmd, dir, toStdout)
+}
+
+func RunProcNoErr(ctx context.Context, cmd, dir string, toStdout bool) (string, error) {
+	return DefaultRunner.RunNoErr(ctx, cmd, dir, toStdout)
+}
+
+// RunProcEnv runs cmd with env, a list of KEY=value, added to its
+// environment
+func RunProcEnv(ctx context.Context, cmd, dir string, env []string, toStdout bool) (string, error) {
+	return DefaultRunner.RunEnv(ctx, cmd, dir, env, toStdout)
+}
+
+// ProcRunner runs the commands in a shell. The registered secrets are
+// masked in what it prints
+type ProcRunner struct{}
+
+// runCmd runs p, terminating it along with its children when ctx is done
+func runCmd(ctx context.Context, p *kexec.KCommand) error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Terminate(syscall.SIGTERM)
+		case <-done:
+		}
+	}()
+
+	err := p.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (r ProcRunner) Run(ctx context.Context, cmd, dir string, toStdout bool) (string, error) {
+	return r.RunEnv(ctx, cmd, dir, nil, toStdout)
+}
+
+func (ProcRunner) RunEnv(ctx context.Context, cmd, dir string, env []string, toStdout bool) (string, error) {
 	if os.Getenv("DEBUG") == "true" {
-		fmt.Println("Executing ", cmd)
+		Info("", "Executing "+cmd)
 	}
 	p := kexec.CommandString(cmd)
+	if len(env) != 0 {
+		p.Env = append(os.Environ(), env...)
+	}
 
 	var b bytes.Buffer
 	if toStdout {
-		p.Stdout = io.MultiWriter(os.Stdout, &b)
-		p.Stderr = io.MultiWriter(os.Stderr, &b)
+		p.Stdout = io.MultiWriter(OutputWriter(""), &b)
+		p.Stderr = io.MultiWriter(OutputWriter(""), &b)
 	} else {
 		p.Stdout = &b
 		p.Stderr = &b
@@ -26,35 +93,29 @@ func RunProc(cmd, dir string, toStdout bool) (string, error) {
 
 	p.Dir = dir
 
-	if err := p.Run(); err != nil {
-		return b.String(), err
-	}
-
-	err := p.Wait()
+	err := runCmd(ctx, p)
 	return b.String(), err
 }
 
-func RunProcNoErr(cmd, dir string, toStdout bool) (string, error) {
+func (ProcRunner) RunNoErr(ctx context.Context, cmd, dir string, toStdout bool) (string, error) {
 	if os.Getenv("DEBUG") == "true" {
-		fmt.Println("Executing ", cmd)
+		Info("", "Executing "+cmd)
 	}
 	p := kexec.CommandString(cmd)
 
-	var b bytes.Buffer
+	var b, stderr bytes.Buffer
 	if toStdout {
-		p.Stdout = io.MultiWriter(os.Stdout, &b)
-		p.Stderr = nil
+		p.Stdout = io.MultiWriter(OutputWriter(""), &b)
 	} else {
 		p.Stdout = &b
-		p.Stderr = nil
 	}
+	p.Stderr = &stderr
 
 	p.Dir = dir
 
-	if err := p.Run(); err != nil {
-		return b.String(), err
+	err := runCmd(ctx, p)
+	if err != nil && ctx.Err() == nil && stderr.Len() != 0 {
+		err = errors.Wrap(err, strings.TrimSpace(stderr.String()))
 	}
-
-	err := p.Wait()
 	return b.String(), err
 }
@@ -0,0 +1,82 @@
+package helpers
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// RecordingRunner records the commands it is asked to run instead of
+// running them, and replies with canned outputs
+type RecordingRunner struct {
+	// Outputs are the outputs of the commands starting with the keys. The
+	// longest matching key is used, commands without a match output nothing
+	Outputs map[string]string
+	// Errors are the errors of the commands starting with the keys
+	Errors map[string]error
+
+	mu       sync.Mutex
+	commands []string
+	env      map[int][]string
+}
+
+// NewRecordingRunner returns a runner recording the commands
+func NewRecordingRunner() *RecordingRunner {
+	return &RecordingRunner{Outputs: map[string]string{}, Errors: map[string]error{}}
+}
+
+// Env returns the environment added to the i-th command run
+func (r *RecordingRunner) Env(i int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.env[i]
+}
+
+// Commands returns the commands run so far, in order
+func (r *RecordingRunner) Commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.commands...)
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, cmd, dir string, toStdout bool) (string, error) {
+	return r.run(ctx, cmd, nil)
+}
+
+func (r *RecordingRunner) RunNoErr(ctx context.Context, cmd, dir string, toStdout bool) (string, error) {
+	return r.run(ctx, cmd, nil)
+}
+
+func (r *RecordingRunner) RunEnv(ctx context.Context, cmd, dir string, env []string, toStdout bool) (string, error) {
+	return r.run(ctx, cmd, env)
+}
+
+func (r *RecordingRunner) run(ctx context.Context, cmd string, env []string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if env != nil {
+		if r.env == nil {
+			r.env = map[int][]string{}
+		}
+		r.env[len(r.commands)] = env
+	}
+	r.commands = append(r.commands, cmd)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var out, prefix string
+	for p, o := range r.Outputs {
+		if strings.HasPrefix(cmd, p) && len(p) >= len(prefix) {
+			out, prefix = o, p
+		}
+	}
+	var err error
+	prefix = ""
+	for p, e := range r.Errors {
+		if strings.HasPrefix(cmd, p) && len(p) >= len(prefix) {
+			err, prefix = e, p
+		}
+	}
+	return out, err
+}
@@ -0,0 +1,329 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/kyokomi/emoji"
+	"github.com/mattn/go-isatty"
+)
+
+// The output formats of the reporters
+const (
+	// OutputAuto is OutputPretty on a terminal, OutputPlain otherwise
+	OutputAuto = "auto"
+	// OutputPretty prints the events with emoji, and spinners while waiting
+	OutputPretty = "pretty"
+	// OutputPlain prints the events as plain text lines
+	OutputPlain = "plain"
+	// OutputJSON prints an event per line, as JSON objects
+	OutputJSON = "json"
+)
+
+// OutputFormats are the output formats of the reporters
+var OutputFormats = []string{OutputAuto, OutputPretty, OutputPlain, OutputJSON}
+
+// The kinds of the events
+const (
+	EventInfo    = "info"
+	EventSuccess = "success"
+	EventWarning = "warning"
+	EventError   = "error"
+	EventRetry   = "retry"
+	// EventOutput is the output of a command
+	EventOutput = "output"
+	// EventWaiting is the start and the progress of a wait
+	EventWaiting = "waiting"
+	// EventDone is the end of a wait
+	EventDone = "done"
+	// EventPrompt is a question asked to the user
+	EventPrompt = "prompt"
+)
+
+// Event is something happening while running a command, e.g. a component
+// being deployed or a wait for pods to be running
+type Event struct {
+	// Phase is the phase of the operation the event belongs to, if any
+	Phase string `json:"phase,omitempty"`
+	// Event is the kind of the event, e.g. EventWaiting
+	Event string `json:"event"`
+	// Message describes the event. It can contain emoji codes like :ship:,
+	// which are only rendered by the pretty output
+	Message   string `json:"message,omitempty"`
+	PodsReady *int   `json:"pods_ready,omitempty"`
+	PodsTotal *int   `json:"pods_total,omitempty"`
+	// Class and ExitCode are the class and the exit code of the errors
+	// ending the commands
+	Class    string `json:"class,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// WithPods returns e with the number of pods ready out of total
+func (e Event) WithPods(ready, total int) Event {
+	e.PodsReady = &ready
+	e.PodsTotal = &total
+	return e
+}
+
+// Reporter renders the events of the commands
+type Reporter interface {
+	// Report renders e
+	Report(e Event)
+	// Wait renders e as the start of a wait, which lasts until the returned
+	// Progress is done
+	Wait(e Event) Progress
+}
+
+// Progress is a wait being reported
+type Progress interface {
+	// Update renders the progress of the wait, e.g. the pods ready
+	Update(e Event)
+	// Done ends the wait
+	Done()
+}
+
+// DefaultReporter renders the events of the commands. The format is set from
+// the command line
+var DefaultReporter = autoReporter(os.Stdout)
+
+// PromptReporter renders the questions asked to the user. It writes to
+// stderr, so they don't mix with the output of the commands
+var PromptReporter = autoReporter(os.Stderr)
+
+// NewReporter returns a reporter rendering the events to w in format, one of
+// OutputFormats
+func NewReporter(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case OutputAuto, "":
+		return autoReporter(w), nil
+	case OutputPretty:
+		return &prettyReporter{w: w}, nil
+	case OutputPlain:
+		return &plainReporter{w: w}, nil
+	case OutputJSON:
+		return &jsonReporter{w: w}, nil
+	}
+	return nil, fmt.Errorf("invalid output %q, valid outputs are %s", format, strings.Join(OutputFormats, ", "))
+}
+
+func autoReporter(w io.Writer) Reporter {
+	if f, ok := w.(*os.File); ok && isatty.IsTerminal(f.Fd()) {
+		return &prettyReporter{w: w}
+	}
+	return &plainReporter{w: w}
+}
+
+// Report renders an event of the default reporter
+func Report(phase, event, message string) {
+	DefaultReporter.Report(Event{Phase: phase, Event: event, Message: message})
+}
+
+// Info reports an informational message
+func Info(phase, message string) { Report(phase, EventInfo, message) }
+
+// Success reports the success of a step
+func Success(phase, message string) { Report(phase, EventSuccess, message) }
+
+// Warning reports a warning
+func Warning(phase, message string) { Report(phase, EventWarning, message) }
+
+// Error reports an error
+func Error(phase, message string) { Report(phase, EventError, message) }
+
+// Output reports the output of a command
+func Output(phase, output string) { Report(phase, EventOutput, output) }
+
+// Prompt reports a question asked to the user with the prompt reporter
+func Prompt(question string) {
+	PromptReporter.Report(Event{Event: EventPrompt, Message: question})
+}
+
+// Wait reports the start of a wait with the default reporter
+func Wait(phase, message string) Progress {
+	return DefaultReporter.Wait(Event{Phase: phase, Event: EventWaiting, Message: message})
+}
+
+// OutputWriter returns a writer reporting each line written to it as the
+// output of a command
+func OutputWriter(phase string) io.Writer {
+	return &outputWriter{phase: phase}
+}
+
+type outputWriter struct {
+	phase string
+	buf   bytes.Buffer
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	o.buf.Write(p)
+	for {
+		i := bytes.IndexByte(o.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(o.buf.Next(i + 1))
+		Output(o.phase, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+var emojiCode = regexp.MustCompile(`:[a-zA-Z0-9_+\-]+:\s*`)
+
+// stripEmoji removes the emoji codes from s
+func stripEmoji(s string) string {
+	codes := emoji.CodeMap()
+	return strings.TrimRight(emojiCode.ReplaceAllStringFunc(s, func(code string) string {
+		if _, ok := codes[strings.TrimSpace(code)]; ok {
+			return ""
+		}
+		return code
+	}), " ")
+}
+
+// prettyReporter renders the events with emoji, and spinners while waiting
+type prettyReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *prettyReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e.Event == EventOutput || e.Event == EventError {
+		fmt.Fprintln(r.w, Redact(e.Message))
+		return
+	}
+	emoji.Fprintln(r.w, Redact(e.Message))
+}
+
+func (r *prettyReporter) Wait(e Event) Progress {
+	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriter(r.w))
+	s.Suffix = spinnerSuffix(e.Message)
+	s.Start()
+	return prettyProgress{s}
+}
+
+func spinnerSuffix(message string) string {
+	if message == "" {
+		return ""
+	}
+	return " " + emoji.Sprint(Redact(message))
+}
+
+type prettyProgress struct {
+	s *spinner.Spinner
+}
+
+func (p prettyProgress) Update(e Event) { p.s.Suffix = spinnerSuffix(e.Message) }
+
+func (p prettyProgress) Done() { p.s.Stop() }
+
+// plainReporter renders the events as plain text lines
+type plainReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *plainReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg := Redact(e.Message)
+	if e.Event != EventOutput {
+		msg = stripEmoji(msg)
+	}
+	switch e.Event {
+	case EventWarning:
+		msg = "WARNING: " + msg
+	case EventError:
+		msg = "ERROR: " + msg
+	}
+	fmt.Fprintln(r.w, msg)
+}
+
+func (r *plainReporter) Wait(e Event) Progress {
+	p := &lineProgress{report: r.Report, start: e}
+	p.Update(e)
+	return p
+}
+
+// jsonReporter renders each event as a JSON object on its own line
+type jsonReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *jsonReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Message = Redact(e.Message)
+	if e.Event != EventOutput {
+		e.Message = stripEmoji(e.Message)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(r.w, string(b))
+}
+
+func (r *jsonReporter) Wait(e Event) Progress {
+	p := &lineProgress{report: r.Report, start: e, pods: true, done: true}
+	p.Update(e)
+	return p
+}
+
+// lineProgress reports the updates of a wait which change it as events of
+// their own. Updates without a message aren't reported
+type lineProgress struct {
+	mu     sync.Mutex
+	report func(Event)
+	start  Event
+	last   *Event
+	// pods reports the updates changing only the pods too
+	pods bool
+	// done reports the end of the wait too
+	done bool
+}
+
+func (p *lineProgress) Update(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e.Phase == "" {
+		e.Phase = p.start.Phase
+	}
+	if e.Event == "" {
+		e.Event = EventWaiting
+	}
+	if e.Message == "" {
+		return
+	}
+	if p.last != nil && e.Message == p.last.Message && (!p.pods || samePods(e.PodsReady, p.last.PodsReady) && samePods(e.PodsTotal, p.last.PodsTotal)) {
+		return
+	}
+	p.last = &e
+	p.report(e)
+}
+
+func (p *lineProgress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.done || p.last == nil {
+		return
+	}
+	p.report(Event{Phase: p.last.Phase, Event: EventDone, Message: p.last.Message})
+	p.last = nil
+}
+
+func samePods(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
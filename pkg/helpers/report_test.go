@@ -0,0 +1,65 @@
+package helpers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reporter", func() {
+	var out bytes.Buffer
+
+	newReporter := func(format string) helpers.Reporter {
+		reporter, err := helpers.NewReporter(format, &out)
+		Expect(err).ToNot(HaveOccurred())
+		return reporter
+	}
+
+	BeforeEach(func() {
+		out.Reset()
+	})
+
+	It("prints an event per line as JSON, with the pods ready of the waits", func() {
+		reporter := newReporter(helpers.OutputJSON)
+
+		p := reporter.Wait(helpers.Event{Phase: "kubecf", Event: helpers.EventWaiting, Message: ":zzz: Waiting for kubecf"})
+		p.Update(helpers.Event{Message: "Waiting for kubecf"}.WithPods(1, 4))
+		p.Update(helpers.Event{Message: "Waiting for kubecf"}.WithPods(1, 4))
+		p.Update(helpers.Event{Message: "Waiting for kubecf"}.WithPods(3, 4))
+		p.Done()
+
+		var events []helpers.Event
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var e helpers.Event
+			Expect(json.Unmarshal([]byte(line), &e)).To(Succeed(), line)
+			events = append(events, e)
+		}
+		Expect(events).To(HaveLen(4))
+		Expect(events[0]).To(Equal(helpers.Event{Phase: "kubecf", Event: helpers.EventWaiting, Message: "Waiting for kubecf"}))
+		Expect(*events[2].PodsReady).To(Equal(3))
+		Expect(*events[2].PodsTotal).To(Equal(4))
+		Expect(events[3]).To(Equal(helpers.Event{Phase: "kubecf", Event: helpers.EventDone, Message: "Waiting for kubecf"}))
+	})
+
+	It("prints plain text without emoji and secrets", func() {
+		reporter := newReporter(helpers.OutputPlain)
+		helpers.RegisterSecret("s3cr3t-report")
+
+		reporter.Report(helpers.Event{Event: helpers.EventWarning, Message: ":warning: Password s3cr3t-report left behind"})
+		reporter.Report(helpers.Event{Event: helpers.EventSuccess, Message: ":heavy_check_mark: KubeCF deployed correctly to the :rainbow: :cloud:"})
+		reporter.Report(helpers.Event{Event: helpers.EventOutput, Message: "keeps :colons: of the commands"})
+
+		Expect(out.String()).To(Equal("WARNING: Password " + helpers.Mask + " left behind\n" +
+			"KubeCF deployed correctly to the\n" +
+			"keeps :colons: of the commands\n"))
+	})
+
+	It("rejects unknown formats", func() {
+		_, err := helpers.NewReporter("yaml", &out)
+		Expect(err).To(MatchError(ContainSubstring("invalid output \"yaml\"")))
+	})
+})
@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy is how operations failing with transient errors, like an API
+// server blip, are retried
+type RetryPolicy struct {
+	// Attempts is the maximum number of runs, the first one included
+	Attempts int
+	// Backoff is the wait before the first retry. It doubles at each retry,
+	// up to MaxBackoff
+	Backoff, MaxBackoff time.Duration
+	// Retryable tells whether an error is transient. No error is retried
+	// when it's nil
+	Retryable func(error) bool
+}
+
+// DefaultRetry is the policy of the helm commands and of the cluster calls.
+// The attempts and the backoff are set from the command line
+var DefaultRetry = RetryPolicy{
+	Attempts:   5,
+	Backoff:    2 * time.Second,
+	MaxBackoff: 30 * time.Second,
+	Retryable:  IsTransient,
+}
+
+// TransientErrors are fragments of the messages of the errors worth
+// retrying, matched ignoring case
+var TransientErrors = []string{
+	"connection refused",
+	"connection reset by peer",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"http2: server sent GOAWAY",
+	"http2: client connection lost",
+	"Client.Timeout exceeded",
+	"error dialing backend",
+	"the server is currently unable to handle the request",
+	"the server has received too many requests",
+	"etcdserver: request timed out",
+	"etcdserver: leader changed",
+	"Internal error occurred",
+}
+
+// IsTransientMessage tells whether msg is the message of a transient
+// failure, see TransientErrors
+func IsTransientMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, t := range TransientErrors {
+		if strings.Contains(msg, strings.ToLower(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTransient tells whether err is a network timeout, or has the message of
+// a transient failure
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return IsTransientMessage(err.Error())
+}
+
+// Do runs f until it succeeds, fails with an error which isn't retryable,
+// runs out of attempts or ctx is done. Each retry is logged, what names the
+// operation retried
+func (p RetryPolicy) Do(ctx context.Context, what string, f func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.Attempts || ctx.Err() != nil || p.Retryable == nil || !p.Retryable(err) {
+			return err
+		}
+
+		Report("", EventRetry, fmt.Sprintf(":repeat: %s failed, retrying in %s (attempt %d of %d): %s", what, backoff, attempt+1, p.Attempts, err.Error()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package helpers_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	ctx := context.Background()
+	policy := helpers.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Retryable: helpers.IsTransient}
+
+	It("retries the transient failures until it runs out of attempts", func() {
+		runs := 0
+		err := policy.Do(ctx, "get", func() error {
+			runs++
+			return errors.New("dial tcp 10.0.0.1:6443: connect: connection refused")
+		})
+		Expect(err).To(MatchError(ContainSubstring("connection refused")))
+		Expect(runs).To(Equal(3))
+	})
+
+	It("stops at the first success", func() {
+		runs := 0
+		Expect(policy.Do(ctx, "get", func() error {
+			runs++
+			if runs == 1 {
+				return errors.New("http2: server sent GOAWAY")
+			}
+			return nil
+		})).To(Succeed())
+		Expect(runs).To(Equal(2))
+	})
+
+	It("doesn't retry the permanent failures", func() {
+		runs := 0
+		Expect(policy.Do(ctx, "get", func() error {
+			runs++
+			return errors.New("namespaces \"kubecf\" is forbidden")
+		})).ToNot(Succeed())
+		Expect(runs).To(Equal(1))
+	})
+
+	It("stops when the context is done", func() {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		runs := 0
+		Expect(policy.Do(cancelled, "get", func() error {
+			runs++
+			return errors.New("i/o timeout")
+		})).ToNot(Succeed())
+		Expect(runs).To(Equal(1))
+	})
+})
@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the secret values in the redacted text
+const Mask = "*****"
+
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// RegisterSecret adds values to the secrets masked by Redact, like registry
+// passwords or encryption keys. Empty values are ignored
+func RegisterSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range values {
+		if v == "" || contains(secrets.values, v) {
+			continue
+		}
+		secrets.values = append(secrets.values, v)
+	}
+	// Longest first, so secrets containing others are masked whole
+	sort.Slice(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact masks the registered secrets in s
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, Mask)
+	}
+	return s
+}
+
+// redactedError is an error whose message has the secrets masked
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e redactedError) Error() string { return e.msg }
+func (e redactedError) Cause() error  { return e.err }
+func (e redactedError) Unwrap() error { return e.err }
+
+// RedactError returns err with the registered secrets masked in its message
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return redactedError{err: err, msg: msg}
+}
+
+// redactWriter masks the registered secrets in what is written to w
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// RedactWriter returns a writer masking the registered secrets written to w.
+// Secrets split across writes aren't masked
+func RedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w: w}
+}
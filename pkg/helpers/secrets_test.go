@@ -0,0 +1,38 @@
+package helpers_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secrets", func() {
+	BeforeEach(func() {
+		helpers.RegisterSecret("s3cr3t-key", "s3cr3t-key-long", "")
+	})
+
+	It("masks the registered secrets, the longest first", func() {
+		Expect(helpers.Redact("--key s3cr3t-key-long --other s3cr3t-key")).To(Equal("--key " + helpers.Mask + " --other " + helpers.Mask))
+		Expect(helpers.Redact("nothing to hide")).To(Equal("nothing to hide"))
+	})
+
+	It("masks the messages of the errors, keeping their cause", func() {
+		cause := errors.New("login with s3cr3t-key failed")
+		err := helpers.RedactError(fmt.Errorf("while logging in: %w", cause))
+		Expect(err).To(MatchError("while logging in: login with " + helpers.Mask + " failed"))
+		Expect(errors.Is(err, cause)).To(BeTrue())
+
+		plain := errors.New("no secrets")
+		Expect(helpers.RedactError(plain)).To(BeIdenticalTo(plain))
+	})
+
+	It("masks what is written", func() {
+		var out bytes.Buffer
+		fmt.Fprint(helpers.RedactWriter(&out), "key: s3cr3t-key\n")
+		Expect(out.String()).To(Equal("key: " + helpers.Mask + "\n"))
+	})
+})
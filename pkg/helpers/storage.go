@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IsRemote returns true if the backup location is an object storage URL
+// rather than a local directory
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, "s3://")
+}
+
+// Upload copies the content of a local directory to a remote location
+func Upload(ctx context.Context, dir, location string, debug bool) error {
+	out, err := RunProc(ctx, fmt.Sprintf("aws s3 cp --recursive --only-show-errors %s %s", dir, location), dir, debug)
+	if err != nil {
+		Output("", out)
+		return errors.Wrap(err, "while uploading to "+location)
+	}
+	return nil
+}
+
+// Download copies the content of a remote location into a local directory
+func Download(ctx context.Context, location, dir string, debug bool) error {
+	out, err := RunProc(ctx, fmt.Sprintf("aws s3 cp --recursive --only-show-errors %s %s", location, dir), dir, debug)
+	if err != nil {
+		Output("", out)
+		return errors.Wrap(err, "while downloading from "+location)
+	}
+	return nil
+}
+
+// JoinLocation appends elem to a local path or a remote URL
+func JoinLocation(location, elem string) string {
+	if IsRemote(location) {
+		return strings.TrimSuffix(location, "/") + "/" + elem
+	}
+	return strings.TrimSuffix(location, string(os.PathSeparator)) + string(os.PathSeparator) + elem
+}
@@ -0,0 +1,151 @@
+package kubernetes
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AuditLabel holds the component of the audit records
+	AuditLabel = "kubecfctl.io/audit"
+
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+
+	// AuditRecordsKept is the number of audit records kept per component,
+	// the older ones are removed
+	AuditRecordsKept = 100
+)
+
+// AuditRecord tells who ran an operation changing the cluster, when, and how
+// it went. The records are kept in the cluster, one ConfigMap each
+type AuditRecord struct {
+	Operation string `json:"operation"`
+	Component string `json:"component"`
+	// User and Host are the ones running kubecfctl, KubeUser is the user of
+	// the kubeconfig context
+	User     string `json:"user"`
+	KubeUser string `json:"kube_user"`
+	Host     string `json:"host"`
+	// Command is the command line, with the secrets masked
+	Command string `json:"command"`
+	// VersionBefore and VersionAfter are the versions installed, if known
+	VersionBefore string        `json:"version_before"`
+	VersionAfter  string        `json:"version_after"`
+	Started       time.Time     `json:"started"`
+	Duration      time.Duration `json:"duration"`
+	// Result is AuditSuccess or AuditFailure. The failures have their error
+	// and its class
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	Class  string `json:"class,omitempty"`
+}
+
+func auditName(r AuditRecord) string {
+	return "kubecfctl-audit-" + r.Started.UTC().Format("20060102-150405.000000000")
+}
+
+// SaveAuditRecord stores r in the cluster. The secrets registered in helpers
+// are masked
+func (c *Cluster) SaveAuditRecord(ctx context.Context, r AuditRecord) error {
+	if err := c.ensureStateNamespace(ctx); err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: auditName(r),
+			Labels: map[string]string{
+				ManagedByLabel: "kubecfctl",
+				AuditLabel:     r.Component,
+			},
+		},
+		Data: map[string]string{
+			"operation":      r.Operation,
+			"component":      r.Component,
+			"user":           r.User,
+			"kube-user":      r.KubeUser,
+			"host":           r.Host,
+			"command":        helpers.Redact(r.Command),
+			"version-before": r.VersionBefore,
+			"version-after":  r.VersionAfter,
+			"started":        r.Started.Format(time.RFC3339Nano),
+			"duration":       r.Duration.String(),
+			"result":         r.Result,
+			"error":          helpers.Redact(r.Error),
+			"class":          r.Class,
+		},
+	}
+	if _, err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	return errors.Wrap(c.pruneAuditRecords(ctx, r.Component), "while removing the old audit records")
+}
+
+// pruneAuditRecords removes the oldest audit records of component, keeping
+// AuditRecordsKept of them
+func (c *Cluster) pruneAuditRecords(ctx context.Context, component string) error {
+	list, err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).List(ctx, metav1.ListOptions{LabelSelector: AuditLabel + "=" + component})
+	if err != nil {
+		return err
+	}
+	if len(list.Items) <= AuditRecordsKept {
+		return nil
+	}
+
+	// The names of the records sort by date
+	var names []string
+	for _, cm := range list.Items {
+		names = append(names, cm.Name)
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-AuditRecordsKept] {
+		err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditHistory returns the audit records of component, or all of them if
+// component is empty, the oldest first
+func (c *Cluster) AuditHistory(ctx context.Context, component string) ([]AuditRecord, error) {
+	selector := AuditLabel
+	if component != "" {
+		selector = AuditLabel + "=" + component
+	}
+	list, err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []AuditRecord
+	for _, cm := range list.Items {
+		r := AuditRecord{
+			Operation:     cm.Data["operation"],
+			Component:     cm.Data["component"],
+			User:          cm.Data["user"],
+			KubeUser:      cm.Data["kube-user"],
+			Host:          cm.Data["host"],
+			Command:       cm.Data["command"],
+			VersionBefore: cm.Data["version-before"],
+			VersionAfter:  cm.Data["version-after"],
+			Result:        cm.Data["result"],
+			Error:         cm.Data["error"],
+			Class:         cm.Data["class"],
+		}
+		r.Started, _ = time.Parse(time.RFC3339Nano, cm.Data["started"])
+		r.Duration, _ = time.ParseDuration(cm.Data["duration"])
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Started.Before(records[j].Started) })
+	return records, nil
+}
@@ -0,0 +1,51 @@
+package kubernetes_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	. "github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit records", func() {
+	ctx := context.Background()
+
+	It("keeps the latest records of each component", func() {
+		cluster, _ := newCluster()
+		start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < AuditRecordsKept+2; i++ {
+			Expect(cluster.SaveAuditRecord(ctx, AuditRecord{Operation: "upgrade", Component: "kubecf", Started: start.Add(time.Duration(i) * time.Minute)})).To(Succeed())
+		}
+		Expect(cluster.SaveAuditRecord(ctx, AuditRecord{Operation: "install", Component: "quarks", Started: start})).To(Succeed())
+
+		records, err := cluster.AuditHistory(ctx, "kubecf")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(AuditRecordsKept))
+		Expect(records[0].Started).To(Equal(start.Add(2 * time.Minute)))
+		Expect(cluster.AuditHistory(ctx, "quarks")).To(HaveLen(1))
+	})
+
+	It("masks the secrets in the commands and the errors", func() {
+		cluster, _ := newCluster()
+		helpers.RegisterSecret("s3cr3t-audit")
+		Expect(cluster.SaveAuditRecord(ctx, AuditRecord{
+			Operation: "upgrade",
+			Component: "kubecf",
+			Command:   "kubecfctl upgrade kubecf --registry-password s3cr3t-audit",
+			Result:    AuditFailure,
+			Error:     "login with s3cr3t-audit failed",
+			Class:     ClassHelm,
+			Started:   time.Now(),
+		})).To(Succeed())
+
+		records, err := cluster.AuditHistory(ctx, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Command).To(Equal("kubecfctl upgrade kubecf --registry-password " + helpers.Mask))
+		Expect(records[0].Error).To(Equal("login with " + helpers.Mask + " failed"))
+		Expect(records[0].Class).To(Equal(ClassHelm))
+	})
+})
@@ -0,0 +1,112 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CheckpointNamespace holds the progress of the installs
+	CheckpointNamespace = "kubecfctl"
+	CheckpointLabel     = "kubecfctl.io/install"
+)
+
+// InstallCheckpoint records the phases of an install completed so far
+type InstallCheckpoint struct {
+	Component string
+	Version   string
+	Domain    string
+	Completed []string
+}
+
+// Done returns true if phase was completed
+func (cp InstallCheckpoint) Done(phase string) bool {
+	for _, p := range cp.Completed {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureStateNamespace creates the namespace holding the state of kubecfctl,
+// if missing
+func (c *Cluster) ensureStateNamespace(ctx context.Context) error {
+	// Look for it first, the backup jobs can't create namespaces
+	if _, err := c.Kubectl.CoreV1().Namespaces().Get(ctx, CheckpointNamespace, metav1.GetOptions{}); err == nil {
+		return nil
+	}
+	_, err := c.Kubectl.CoreV1().Namespaces().Create(ctx,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: CheckpointNamespace}},
+		metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func checkpointName(component string) string {
+	return "kubecfctl-install-" + component
+}
+
+// GetInstallCheckpoint returns the checkpoint of an install of component,
+// or nil if there is none
+func (c *Cluster) GetInstallCheckpoint(ctx context.Context, component string) (*InstallCheckpoint, error) {
+	cm, err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).Get(ctx, checkpointName(component), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &InstallCheckpoint{
+		Component: component,
+		Version:   cm.Data["version"],
+		Domain:    cm.Data["domain"],
+	}
+	if completed := cm.Data["completed"]; completed != "" {
+		cp.Completed = strings.Split(completed, "\n")
+	}
+	return cp, nil
+}
+
+// SaveInstallCheckpoint stores the checkpoint in the cluster
+func (c *Cluster) SaveInstallCheckpoint(ctx context.Context, cp InstallCheckpoint) error {
+	if err := c.ensureStateNamespace(ctx); err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: checkpointName(cp.Component),
+			Labels: map[string]string{
+				ManagedByLabel:  "kubecfctl",
+				CheckpointLabel: cp.Component,
+			},
+		},
+		Data: map[string]string{
+			"version":   cp.Version,
+			"domain":    cp.Domain,
+			"completed": strings.Join(cp.Completed, "\n"),
+		},
+	}
+	_, err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).Create(ctx, cm, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// DeleteInstallCheckpoint removes the checkpoint of component, if any
+func (c *Cluster) DeleteInstallCheckpoint(ctx context.Context, component string) error {
+	err := c.Kubectl.CoreV1().ConfigMaps(CheckpointNamespace).Delete(ctx, checkpointName(component), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
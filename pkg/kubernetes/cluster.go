@@ -3,13 +3,13 @@ package kubernetes
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
 	"time"
 
-	"github.com/briandowns/spinner"
-	"github.com/kyokomi/emoji"
+	"github.com/mudler/kubecfctl/pkg/helpers"
 	"github.com/pkg/errors"
 
 	generic "github.com/mudler/kubecfctl/pkg/kubernetes/platform/generic"
@@ -17,9 +17,13 @@ import (
 	k3s "github.com/mudler/kubecfctl/pkg/kubernetes/platform/k3s"
 	kind "github.com/mudler/kubecfctl/pkg/kubernetes/platform/kind"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
@@ -31,19 +35,29 @@ import (
 )
 
 type Platform interface {
-	Detect(*kubernetes.Clientset) bool
+	Detect(kubernetes.Interface) bool
 	Describe() string
 	String() string
-	Load(*kubernetes.Clientset) error
+	Load(kubernetes.Interface) error
 	ExternalIPs() []string
 }
 
 var SupportedPlatforms []Platform = []Platform{kind.NewPlatform(), k3s.NewPlatform(), ibm.NewPlatform()}
 
+// ExecFunc runs command in a container of a pod
+type ExecFunc func(ctx context.Context, namespace, podName, containerName string,
+	command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error
+
 type Cluster struct {
 	//	InternalIPs []string
 	//	Ingress     bool
-	Kubectl    *kubernetes.Clientset
+	Kubectl kubernetes.Interface
+	// Dynamic serves the resources without a typed client, like CRDs
+	Dynamic dynamic.Interface
+	// Executor runs the commands in pods, through the API server unless set
+	Executor ExecFunc
+	// User is the user of the kubeconfig context connected with, if any
+	User       string
 	restConfig *restclient.Config
 	platform   Platform
 }
@@ -53,29 +67,66 @@ func NewCluster(kubeconfig string) (*Cluster, error) {
 	return c, c.Connect(kubeconfig)
 }
 
+// NewClusterWithClients returns a cluster using the given clients, e.g. the
+// fake ones of client-go, running on platform. Commands can only be run in
+// pods once an Executor is set
+func NewClusterWithClients(client kubernetes.Interface, dynamicClient dynamic.Interface, platform Platform) *Cluster {
+	return &Cluster{
+		Kubectl:  client,
+		Dynamic:  dynamicClient,
+		platform: platform,
+		Executor: func(context.Context, string, string, string, string, bool, io.Reader, io.Writer, io.Writer) error {
+			return errors.New("no executor set for the cluster")
+		},
+	}
+}
+
+func (c *Cluster) exec() ExecFunc {
+	if c.Executor != nil {
+		return c.Executor
+	}
+	return c.execPod
+}
+
 func (c *Cluster) GetPlatform() Platform {
 	return c.platform
 }
 
+// Connect connects to the cluster of the kubeconfig file config, or to the
+// one it runs in if config is empty. Failures are returned as ConnectionError
 func (c *Cluster) Connect(config string) error {
 	restConfig, err := clientcmd.BuildConfigFromFlags("", config)
 	if err != nil {
-		return err
+		return ConnectionError{Err: err}
 	}
 	c.restConfig = restConfig
+	if config != "" {
+		if raw, err := clientcmd.LoadFromFile(config); err == nil {
+			if kubeContext, ok := raw.Contexts[raw.CurrentContext]; ok {
+				c.User = kubeContext.AuthInfo
+			}
+		}
+	}
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return err
+		return ConnectionError{Err: err}
 	}
 	c.Kubectl = clientset
+	c.Dynamic, err = dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return ConnectionError{Err: err}
+	}
 	c.detectPlatform()
 	if c.platform == nil {
-		emoji.Println(":warning: No valid platform detected, trying general platform. Things might go wrong")
+		helpers.Warning("", ":warning: No valid platform detected, trying general platform. Things might go wrong")
 		c.platform = generic.NewPlatform()
 		//return errors.New("No supported platform detected. Bailing out")
 	}
 
-	return c.platform.Load(clientset)
+	if err := c.platform.Load(clientset); err != nil {
+		return ConnectionError{Err: err}
+	}
+	return nil
 }
 
 func (c *Cluster) detectPlatform() {
@@ -87,88 +138,113 @@ func (c *Cluster) detectPlatform() {
 	}
 }
 
-// return a condition function that indicates whether the given pod is
-// currently running
-func (c *Cluster) isPodRunning(podName, namespace string) wait.ConditionFunc {
-	return func() (bool, error) {
-		pod, err := c.Kubectl.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
-		if err != nil {
-			return false, err
+// podReady tells whether pod is running or completed, with none of its
+// containers waiting and its init containers done
+func podReady(pod v1.Pod) bool {
+	for _, cont := range pod.Status.ContainerStatuses {
+		if cont.State.Waiting != nil {
+			return false
 		}
-
-		for _, cont := range pod.Status.ContainerStatuses {
-			if cont.State.Waiting != nil {
-				//fmt.Println("containers still in waiting")
-				return false, nil
-			}
-		}
-
-		for _, cont := range pod.Status.InitContainerStatuses {
-			if cont.State.Waiting != nil || cont.State.Running != nil {
-				return false, nil
-			}
+	}
+	for _, cont := range pod.Status.InitContainerStatuses {
+		if cont.State.Waiting != nil || cont.State.Running != nil {
+			return false
 		}
+	}
+	return pod.Status.Phase == v1.PodRunning || pod.Status.Phase == v1.PodSucceeded
+}
 
-		switch pod.Status.Phase {
-		case v1.PodRunning, v1.PodSucceeded:
-			return true, nil
-		case v1.PodFailed:
-			return false, nil
+// podState describes the state of pod, e.g. "api-0 (CrashLoopBackOff)"
+func podState(pod v1.Pod) string {
+	state := string(pod.Status.Phase)
+	for _, cont := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if cont.State.Waiting != nil && cont.State.Waiting.Reason != "" {
+			state = cont.State.Waiting.Reason
+			break
 		}
-		return false, nil
 	}
+	if state == "" {
+		return pod.Name
+	}
+	return pod.Name + " (" + state + ")"
 }
 
-func (c *Cluster) podExists(namespace, selector string) wait.ConditionFunc {
-	return func() (bool, error) {
-		podList, err := c.ListPods(namespace, selector)
-		if err != nil {
-			return false, err
-		}
-		if len(podList.Items) == 0 {
-			return false, nil
-		}
-		return true, nil
+// describePods describes the pods of namespace matching selector
+func describePods(namespace, selector string) string {
+	if selector == "" {
+		return "the pods in " + namespace
 	}
+	return "the pods " + selector + " in " + namespace
 }
 
-// Poll up to timeout seconds for pod to enter running state.
-// Returns an error if the pod never enters the running state.
-func (c *Cluster) WaitForPodRunning(namespace, podName string, timeout time.Duration) error {
-	return wait.PollImmediate(time.Second, timeout, c.isPodRunning(podName, namespace))
+// poll checks condition every second until it's true, timeout expires or
+// ctx is done
+func poll(ctx context.Context, timeout time.Duration, condition wait.ConditionFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(time.Second, condition, ctx.Done())
+	if err == wait.ErrWaitTimeout && errors.Cause(ctx.Err()) == context.Canceled {
+		return context.Canceled
+	}
+	return err
 }
 
 // ListPods returns the list of currently scheduled or running pods in `namespace` with the given selector
-func (c *Cluster) ListPods(namespace, selector string) (*v1.PodList, error) {
+func (c *Cluster) ListPods(ctx context.Context, namespace, selector string) (*v1.PodList, error) {
 	listOptions := metav1.ListOptions{}
 	if len(selector) > 0 {
 		listOptions.LabelSelector = selector
 	}
-	podList, err := c.Kubectl.CoreV1().Pods(namespace).List(context.Background(), listOptions)
+	var podList *v1.PodList
+	err := retry(ctx, "listing pods in "+namespace, func() (err error) {
+		podList, err = c.Kubectl.CoreV1().Pods(namespace).List(ctx, listOptions)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return podList, nil
 }
 
-// Wait up to timeout seconds for all pods in 'namespace' with given 'selector' to enter running state.
-// Returns an error if no pods are found or not all discovered pods enter running state.
-func (c *Cluster) WaitUntilPodBySelectorExist(namespace, selector string, timeout int) error {
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
-	s.Suffix = emoji.Sprintf(" Waiting for resource %s to be created in %s ... :zzz: ", selector, namespace)
-	return wait.PollImmediate(time.Second, time.Duration(timeout)*time.Second, c.podExists(namespace, selector))
-}
-
-// Wait up to timeout seconds for all pods in 'namespace' with given 'selector' to enter running state.
-// Returns an error if no pods are found or not all discovered pods enter running state.
-func (c *Cluster) WaitForPodBySelectorRunning(namespace, selector string, timeout int) error {
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
-	s.Start()                                                    // Start the spinner
-	defer s.Stop()
-	s.Suffix = emoji.Sprintf(" Waiting for resource %s to be running in %s ... :zzz: ", selector, namespace)
-	podList, err := c.ListPods(namespace, selector)
+// NamespaceExists returns true if namespace exists. Errors other than the
+// namespace not being found are returned, once retried if transient
+func (c *Cluster) NamespaceExists(ctx context.Context, namespace string) (bool, error) {
+	err := retry(ctx, "getting namespace "+namespace, func() error {
+		_, err := c.Kubectl.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "while getting namespace "+namespace)
+	}
+	return true, nil
+}
+
+// WaitUntilPodBySelectorExist waits until pods in namespace match selector.
+// It returns a TimeoutError if none shows up before t expires
+func (c *Cluster) WaitUntilPodBySelectorExist(ctx context.Context, namespace, selector string, t PhaseTimeout) error {
+	s := helpers.Wait(t.Phase, fmt.Sprintf("Waiting for resource %s to be created in %s ... :zzz:", selector, namespace))
+	defer s.Done()
+	err := poll(ctx, t.Timeout, func() (bool, error) {
+		podList, err := c.ListPods(ctx, namespace, selector)
+		if err != nil {
+			return false, err
+		}
+		return len(podList.Items) != 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return &TimeoutError{PhaseTimeout: t, What: describePods(namespace, selector) + " to be created"}
+	}
+	return err
+}
+
+// WaitForPodBySelectorRunning waits until all the pods in namespace matching
+// selector are running. It returns an error if there are no such pods, and a
+// TimeoutError listing the pods not ready if t expires
+func (c *Cluster) WaitForPodBySelectorRunning(ctx context.Context, namespace, selector string, t PhaseTimeout) error {
+	podList, err := c.ListPods(ctx, namespace, selector)
 	if err != nil {
 		return errors.Wrapf(err, "failed listingpods with selector %s", selector)
 	}
@@ -177,28 +253,78 @@ func (c *Cluster) WaitForPodBySelectorRunning(namespace, selector string, timeou
 		return fmt.Errorf("no pods in %s with selector %s", namespace, selector)
 	}
 
-	for _, pod := range podList.Items {
-		s.Suffix = emoji.Sprintf(" Waiting for pod %s to be running in %s ... :zzz: ", pod.Name, namespace)
-		if err := c.WaitForPodRunning(namespace, pod.Name, time.Duration(timeout)*time.Second); err != nil {
-			return errors.Wrapf(err, "failed waiting for %s", pod.Name)
+	message := fmt.Sprintf("Waiting for resource %s to be running in %s ... :zzz:", selector, namespace)
+	s := helpers.Wait(t.Phase, message)
+	defer s.Done()
+	var notReady []string
+	err = poll(ctx, t.Timeout, func() (bool, error) {
+		podList, err := c.ListPods(ctx, namespace, selector)
+		if err != nil {
+			return false, err
+		}
+		notReady = nil
+		for _, pod := range podList.Items {
+			if !podReady(pod) {
+				notReady = append(notReady, podState(pod))
+			}
 		}
+		e := helpers.Event{Message: message}
+		if len(notReady) != 0 {
+			e.Message = fmt.Sprintf("Waiting for %d pods to be running in %s ... :zzz:", len(notReady), namespace)
+		}
+		s.Update(e.WithPods(len(podList.Items)-len(notReady), len(podList.Items)))
+		return len(notReady) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return &TimeoutError{PhaseTimeout: t, What: describePods(namespace, selector) + " to be running", NotReady: notReady}
 	}
-	return nil
+	return err
 }
 
-func (c *Cluster) Exec(namespace, podName, containerName string, command, stdin string) (string, string, error) {
+// Exec runs command in a container of the pod, with stdin as its input. It
+// is retried when the command couldn't be started because of a transient
+// error
+func (c *Cluster) Exec(ctx context.Context, namespace, podName, containerName string, command, stdin string) (string, string, error) {
 	var stdout, stderr bytes.Buffer
-	stdinput := bytes.NewBuffer([]byte(stdin))
-
-	err := c.execPod(namespace, podName, containerName, command, stdinput, &stdout, &stderr)
+	err := retry(ctx, "running a command in "+podName, func() error {
+		stdout.Reset()
+		stderr.Reset()
+		return c.exec()(ctx, namespace, podName, containerName, command, true, bytes.NewBufferString(stdin), &stdout, &stderr)
+	})
 
 	// if options.PreserveWhitespace {
 	// 	return stdout.String(), stderr.String(), err
 	// }
 	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), err
 }
-func (c *Cluster) execPod(namespace, podName, containerName string,
-	command string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+// ExecStream runs command in a container of the pod without a terminal,
+// streaming stdin and stdout, which can hold binary data. If containerName is
+// empty the first container of the pod is used, as kubectl does. Streams
+// can't be replayed, so it isn't retried
+func (c *Cluster) ExecStream(ctx context.Context, namespace, podName, containerName string, command string, stdin io.Reader, stdout io.Writer) error {
+	if containerName == "" {
+		pod, err := c.Kubectl.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		containerName = pod.Spec.Containers[0].Name
+	}
+	var stderr bytes.Buffer
+	err := c.exec()(ctx, namespace, podName, containerName, command, false, stdin, stdout, &stderr)
+	if err != nil && stderr.Len() != 0 {
+		return errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// DeletePod deletes a pod, to have it recreated by its controller
+func (c *Cluster) DeletePod(ctx context.Context, namespace, podName string) error {
+	return c.Kubectl.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+}
+
+func (c *Cluster) execPod(ctx context.Context, namespace, podName, containerName string,
+	command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
 	cmd := []string{
 		"sh",
 		"-c",
@@ -212,7 +338,7 @@ func (c *Cluster) execPod(namespace, podName, containerName string,
 		Stdin:     true,
 		Stdout:    true,
 		Stderr:    true,
-		TTY:       true,
+		TTY:       tty,
 	}
 	if stdin == nil {
 		option.Stdin = false
@@ -225,14 +351,77 @@ func (c *Cluster) execPod(namespace, podName, containerName string,
 	if err != nil {
 		return err
 	}
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  stdin,
-		Stdout: stdout,
-		Stderr: stderr,
+	// The stream can't be cancelled, stop waiting for it instead
+	result := make(chan error, 1)
+	go func() {
+		result <- exec.Stream(remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ListStatefulSets returns the StatefulSets in `namespace` with the given selector
+func (c *Cluster) ListStatefulSets(ctx context.Context, namespace, selector string) (*appsv1.StatefulSetList, error) {
+	return c.Kubectl.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+}
+
+// ScaleStatefulSet sets the replicas of a StatefulSet, returning the previous count
+func (c *Cluster) ScaleStatefulSet(ctx context.Context, namespace, name string, replicas int32) (int32, error) {
+	scale, err := c.Kubectl.AppsV1().StatefulSets(namespace).GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	previous := scale.Spec.Replicas
+	scale.Spec.Replicas = replicas
+	_, err = c.Kubectl.AppsV1().StatefulSets(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{})
+	return previous, err
+}
+
+// WaitUntilPodBySelectorGone waits until no pod in namespace matches
+// selector. It returns a TimeoutError listing the pods left if t expires
+func (c *Cluster) WaitUntilPodBySelectorGone(ctx context.Context, namespace, selector string, t PhaseTimeout) error {
+	s := helpers.Wait(t.Phase, fmt.Sprintf("Waiting for resource %s to be deleted in %s ... :zzz:", selector, namespace))
+	defer s.Done()
+	var remaining []string
+	err := poll(ctx, t.Timeout, func() (bool, error) {
+		podList, err := c.ListPods(ctx, namespace, selector)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, pod := range podList.Items {
+			remaining = append(remaining, podState(pod))
+		}
+		return len(remaining) == 0, nil
 	})
+	if err == wait.ErrWaitTimeout {
+		return &TimeoutError{PhaseTimeout: t, What: describePods(namespace, selector) + " to be deleted", Remaining: remaining}
+	}
+	return err
+}
+
+// AnnotateStatefulSet sets an annotation on a StatefulSet. An empty value removes it
+func (c *Cluster) AnnotateStatefulSet(ctx context.Context, namespace, name, key, value string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{key: value},
+		},
+	}
+	if value == "" {
+		patch["metadata"].(map[string]interface{})["annotations"] = map[string]interface{}{key: nil}
+	}
+	dat, err := json.Marshal(patch)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	_, err = c.Kubectl.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, dat, metav1.PatchOptions{})
+	return err
 }
@@ -0,0 +1,144 @@
+package kubernetes
+
+import (
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/pkg/errors"
+)
+
+// The classes of the errors, telling why an operation failed. The commands
+// exit with a different code for each of them
+const (
+	// ClassUsage is an invalid command line, like an unknown component
+	ClassUsage = "usage"
+	// ClassConnection is a cluster which can't be reached
+	ClassConnection = "connection"
+	// ClassPreflight is a check failing before the cluster is changed, like
+	// a namespace locked by another run
+	ClassPreflight = "preflight"
+	// ClassHelm is a helm command failing
+	ClassHelm = "helm"
+	// ClassTimeout is a wait timing out, see TimeoutError
+	ClassTimeout = "timeout"
+	// ClassPartial is an operation which went on after some of its steps
+	// failed, like a delete leaving resources behind
+	ClassPartial = "partial"
+)
+
+// The exit codes of the commands failing with the errors of each class.
+// ExitFailure is the one of the errors without a class
+const (
+	ExitFailure    = 1
+	ExitUsage      = 2
+	ExitConnection = 3
+	ExitPreflight  = 4
+	ExitHelm       = 5
+	ExitTimeout    = 6
+	ExitPartial    = 7
+)
+
+var exitCodes = map[string]int{
+	ClassUsage:      ExitUsage,
+	ClassConnection: ExitConnection,
+	ClassPreflight:  ExitPreflight,
+	ClassHelm:       ExitHelm,
+	ClassTimeout:    ExitTimeout,
+	ClassPartial:    ExitPartial,
+}
+
+// ClassifiedError is an error of one of the classes
+type ClassifiedError interface {
+	error
+	Class() string
+}
+
+// ErrorClass returns the class of the outermost classified error err wraps,
+// or an empty string if there is none
+func ErrorClass(err error) string {
+	var c ClassifiedError
+	if errors.As(err, &c) {
+		return c.Class()
+	}
+	return ""
+}
+
+// ExitCode returns the exit code of a command failing with err
+func ExitCode(err error) int {
+	if code, ok := exitCodes[ErrorClass(err)]; ok {
+		return code
+	}
+	return ExitFailure
+}
+
+// ReportError reports err as the error ending a command, along with its
+// class and exit code, and returns the exit code
+func ReportError(err error) int {
+	code := ExitCode(err)
+	helpers.DefaultReporter.Report(helpers.Event{
+		Event:    helpers.EventError,
+		Message:  err.Error(),
+		Class:    ErrorClass(err),
+		ExitCode: code,
+	})
+	return code
+}
+
+// UsageError is returned when the options of an operation are invalid
+type UsageError struct {
+	Err error
+}
+
+func (e UsageError) Error() string { return e.Err.Error() }
+func (e UsageError) Cause() error  { return e.Err }
+func (e UsageError) Unwrap() error { return e.Err }
+func (UsageError) Class() string   { return ClassUsage }
+
+// ConnectionError is returned when the cluster can't be reached
+type ConnectionError struct {
+	Err error
+}
+
+func (e ConnectionError) Error() string { return "cannot reach the cluster: " + e.Err.Error() }
+func (e ConnectionError) Cause() error  { return e.Err }
+func (e ConnectionError) Unwrap() error { return e.Err }
+func (ConnectionError) Class() string   { return ClassConnection }
+
+// PreflightError is returned when an operation can't start, e.g. because of
+// an interrupted install of the same component
+type PreflightError struct {
+	Err error
+}
+
+func (e PreflightError) Error() string { return e.Err.Error() }
+func (e PreflightError) Cause() error  { return e.Err }
+func (e PreflightError) Unwrap() error { return e.Err }
+func (PreflightError) Class() string   { return ClassPreflight }
+
+// PartialError is returned when some steps of an operation failed, and the
+// others went on
+type PartialError struct {
+	Err error
+}
+
+func (e PartialError) Error() string { return e.Err.Error() }
+func (e PartialError) Cause() error  { return e.Err }
+func (e PartialError) Unwrap() error { return e.Err }
+func (PartialError) Class() string   { return ClassPartial }
+
+// Partial returns err, the errors accumulated while going on with the next
+// steps, as a PartialError. A single error is returned as it is, keeping its
+// class, and nil if there is none
+func Partial(err error) error {
+	if merr, ok := err.(*multierror.Error); ok {
+		switch {
+		case merr == nil || len(merr.Errors) == 0:
+			return nil
+		case len(merr.Errors) == 1:
+			return merr.Errors[0]
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	return PartialError{Err: err}
+}
@@ -0,0 +1,60 @@
+package kubernetes_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	. "github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Errors", func() {
+	It("classifies the errors by the outermost class they wrap", func() {
+		err := errors.Wrap(UsageError{Err: errors.New("invalid data set")}, "cannot restore")
+		Expect(ErrorClass(err)).To(Equal(ClassUsage))
+		Expect(ExitCode(err)).To(Equal(ExitUsage))
+
+		err = PreflightError{Err: ConnectionError{Err: errors.New("connection refused")}}
+		Expect(ErrorClass(err)).To(Equal(ClassPreflight))
+		Expect(ExitCode(err)).To(Equal(ExitPreflight))
+
+		Expect(ErrorClass(errors.New("unknown"))).To(BeEmpty())
+		Expect(ExitCode(errors.New("unknown"))).To(Equal(ExitFailure))
+	})
+
+	It("returns a single error of a partial failure as it is", func() {
+		var merr *multierror.Error
+		Expect(Partial(merr)).To(BeNil())
+
+		merr = multierror.Append(merr, PreflightError{Err: errors.New("locked")})
+		Expect(ExitCode(Partial(merr))).To(Equal(ExitPreflight))
+
+		merr = multierror.Append(merr, errors.New("namespace left behind"))
+		Expect(ExitCode(Partial(merr))).To(Equal(ExitPartial))
+	})
+
+	It("reports the class and the exit code as JSON", func() {
+		var out bytes.Buffer
+		previous := helpers.DefaultReporter
+		defer func() { helpers.DefaultReporter = previous }()
+		reporter, err := helpers.NewReporter(helpers.OutputJSON, &out)
+		Expect(err).ToNot(HaveOccurred())
+		helpers.DefaultReporter = reporter
+
+		code := ReportError(ConnectionError{Err: errors.New("connection refused")})
+		Expect(code).To(Equal(ExitConnection))
+
+		var e helpers.Event
+		Expect(json.Unmarshal(out.Bytes(), &e)).To(Succeed())
+		Expect(e).To(Equal(helpers.Event{
+			Event:    helpers.EventError,
+			Message:  "cannot reach the cluster: connection refused",
+			Class:    ClassConnection,
+			ExitCode: ExitConnection,
+		}))
+	})
+})
@@ -1,56 +1,312 @@
 package kubernetes
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/pkg/errors"
 )
 
+// Installer runs the operations on deployments. The secrets registered in
+// helpers are masked in the errors it returns
 type Installer struct {
+	// Resume continues an interrupted install from its first incomplete
+	// phase
+	Resume bool
+	// ForceUnlock removes the lock of the namespaces operated on, in case
+	// it was left behind by a run which is not going anymore
+	ForceUnlock bool
+	// Command is recorded in the locks taken, to show who holds them
+	Command string
+	// Component and CommandLine are recorded in the audit records of the
+	// operations. The component defaults to the one of phased deployments
+	Component   string
+	CommandLine string
+}
+
+// Phase is a step of an install
+type Phase struct {
+	Name string
+	Run  func(context.Context) error
+}
+
+// Phased is implemented by deployments installed in phases. Their progress
+// is checkpointed in the cluster under the component name, so an interrupted
+// install can be resumed
+type Phased interface {
+	Component() string
+	Phases(Cluster) []Phase
+}
+
+// Owner is implemented by deployments creating cluster wide resources, to
+// find the ones left behind once they are deleted
+type Owner interface {
+	Owned() Owned
 }
 
 type Deployment interface {
-	Deploy(Cluster) error
-	Upgrade(Cluster) error
+	Deploy(context.Context, Cluster) error
+	Upgrade(context.Context, Cluster) error
 	SetDomain(d string)
 	GetDomain() string
-	Delete(Cluster) error
+	GetNamespace() string
+	Delete(context.Context, Cluster) error
 	Describe() string
 	GetVersion() string
 
-	Restore(Cluster, string) error
-	Backup(Cluster, string) error
+	Restore(context.Context, Cluster, string) error
+	Backup(context.Context, Cluster, string) error
+	Rollback(context.Context, Cluster, int) error
+}
+
+// Versioned is implemented by deployments telling the version installed in
+// the cluster, recorded in the audit records. It is empty if there is none
+type Versioned interface {
+	InstalledVersion(context.Context, Cluster) (string, error)
 }
 
 func NewInstaller() *Installer {
-	return &Installer{}
+	return &Installer{CommandLine: strings.Join(os.Args, " ")}
 }
 
-func (i *Installer) Install(d Deployment, cluster Cluster) error {
-	fmt.Println(d.Describe())
+// Lock takes the locks of namespaces for operation, preventing concurrent
+// runs from changing them. The returned function releases them
+func (i *Installer) Lock(ctx context.Context, cluster Cluster, operation string, namespaces ...string) (func(), error) {
+	command := i.Command
+	if command == "" {
+		command = "kubecfctl " + operation
+	}
+	holder := NewLockHolder(command)
+
+	var unlocks []func()
+	unlock := func() {
+		for j := len(unlocks) - 1; j >= 0; j-- {
+			unlocks[j]()
+		}
+	}
+	for _, ns := range namespaces {
+		if i.ForceUnlock {
+			if err := cluster.ForceUnlock(ctx, ns); err != nil {
+				unlock()
+				return nil, errors.Wrap(err, "while removing the lock of "+ns)
+			}
+		}
+		u, err := cluster.Lock(ctx, ns, holder)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		unlocks = append(unlocks, u)
+	}
+	return unlock, nil
+}
+
+// Audit starts the audit record of operation on d, which can be nil for the
+// operations not on a deployment. The returned function ends it with the
+// error of the operation, and saves it in the cluster. Failing to save it is
+// only warned about
+func (i *Installer) Audit(ctx context.Context, cluster Cluster, operation string, d Deployment) func(error) {
+	command := i.CommandLine
+	if command == "" {
+		command = i.Command
+	}
+	holder := NewLockHolder(command)
+	record := AuditRecord{
+		Operation: operation,
+		Component: i.Component,
+		User:      holder.User,
+		KubeUser:  cluster.User,
+		Host:      holder.Host,
+		Command:   holder.Command,
+		Started:   holder.Started,
+	}
+	if phased, ok := d.(Phased); ok && record.Component == "" {
+		record.Component = phased.Component()
+	}
+	versioned, _ := d.(Versioned)
+	if versioned != nil {
+		record.VersionBefore, _ = versioned.InstalledVersion(ctx, cluster)
+	}
+
+	return func(err error) {
+		// The record is saved even if the operation was interrupted
+		ctx := context.Background()
+		record.Duration = time.Since(record.Started).Round(time.Second)
+		record.Result = AuditSuccess
+		if err != nil {
+			record.Result = AuditFailure
+			record.Error = err.Error()
+			record.Class = ErrorClass(err)
+		}
+		if versioned != nil {
+			record.VersionAfter, _ = versioned.InstalledVersion(ctx, cluster)
+		}
+		if err := cluster.SaveAuditRecord(ctx, record); err != nil {
+			helpers.Warning("", ":warning: Failed saving the audit record of "+operation+": "+err.Error())
+		}
+	}
+}
+
+func (i *Installer) Install(ctx context.Context, d Deployment, cluster Cluster) (err error) {
+	defer func() { err = helpers.RedactError(err) }()
+	audit := i.Audit(ctx, cluster, "install", d)
+	defer func() { audit(err) }()
+
+	unlock, err := i.Lock(ctx, cluster, "install", d.GetNamespace())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	helpers.Info("", d.Describe())
+
+	var checkpoint *InstallCheckpoint
+	phased, isPhased := d.(Phased)
+	if isPhased {
+		cp, err := cluster.GetInstallCheckpoint(ctx, phased.Component())
+		if err != nil {
+			return err
+		}
+		switch {
+		case cp != nil && !i.Resume:
+			return PreflightError{Err: errors.New("an interrupted install of " + phased.Component() + " was found, run install again with --resume, or delete it first")}
+		case cp != nil && cp.Version != d.GetVersion():
+			return PreflightError{Err: errors.New("the interrupted install is of version " + cp.Version + ", not " + d.GetVersion())}
+		case cp != nil:
+			if d.GetDomain() == "" {
+				d.SetDomain(cp.Domain)
+			}
+			checkpoint = cp
+		case i.Resume:
+			helpers.Info("", ":information_source: No interrupted install of "+phased.Component()+" found, starting from scratch")
+		}
+	}
 
 	// Automatically set a deployment domain based on platform reported ExternalIPs
 	if d.GetDomain() == "" {
 		ips := cluster.GetPlatform().ExternalIPs()
 		if len(ips) == 0 {
-			return errors.New("Could not detect cluster ExternalIPs and no deployment domain was specified")
+			return PreflightError{Err: errors.New("Could not detect cluster ExternalIPs and no deployment domain was specified")}
 		}
 		d.SetDomain(fmt.Sprintf("%s.nip.io", ips[0]))
 	}
-	return d.Deploy(cluster)
+	if !isPhased {
+		return d.Deploy(ctx, cluster)
+	}
+
+	if checkpoint == nil {
+		checkpoint = &InstallCheckpoint{Component: phased.Component(), Version: d.GetVersion(), Domain: d.GetDomain()}
+		if err := cluster.SaveInstallCheckpoint(ctx, *checkpoint); err != nil {
+			return err
+		}
+	}
+	for _, p := range phased.Phases(cluster) {
+		if checkpoint.Done(p.Name) {
+			helpers.Info(p.Name, ":fast_forward: Skipping "+p.Name+", already completed")
+			continue
+		}
+		if err := p.Run(ctx); err != nil {
+			if ctx.Err() != nil {
+				completed := "none"
+				if len(checkpoint.Completed) != 0 {
+					completed = strings.Join(checkpoint.Completed, ", ")
+				}
+				helpers.Warning(p.Name, ":warning: Install interrupted in phase "+p.Name+", completed phases: "+completed)
+			}
+			return errors.Wrap(err, "install failed in phase "+p.Name+", run install again with --resume to continue from it")
+		}
+		checkpoint.Completed = append(checkpoint.Completed, p.Name)
+		if err := cluster.SaveInstallCheckpoint(ctx, *checkpoint); err != nil {
+			return err
+		}
+	}
+	return cluster.DeleteInstallCheckpoint(ctx, phased.Component())
 }
 
-func (i *Installer) Delete(d Deployment, cluster Cluster) error {
-	return d.Delete(cluster)
+func (i *Installer) Delete(ctx context.Context, d Deployment, cluster Cluster) (err error) {
+	defer func() { err = helpers.RedactError(err) }()
+	audit := i.Audit(ctx, cluster, "delete", d)
+	defer func() { audit(err) }()
+
+	unlock, err := i.Lock(ctx, cluster, "delete", d.GetNamespace())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if phased, ok := d.(Phased); ok {
+		if err := cluster.DeleteInstallCheckpoint(ctx, phased.Component()); err != nil {
+			return err
+		}
+	}
+	return d.Delete(ctx, cluster)
 }
 
-func (i *Installer) Upgrade(d Deployment, cluster Cluster) error {
-	return d.Upgrade(cluster)
+// Leftovers returns the cluster wide resources of d still in the cluster
+func (i *Installer) Leftovers(ctx context.Context, d Deployment, cluster Cluster) ([]Resource, error) {
+	owner, ok := d.(Owner)
+	if !ok {
+		return nil, nil
+	}
+	return cluster.FindLeftovers(ctx, owner.Owned())
 }
 
-func (i *Installer) Backup(d Deployment, cluster Cluster, output string) error {
-	return d.Backup(cluster, output)
+func (i *Installer) Upgrade(ctx context.Context, d Deployment, cluster Cluster) (err error) {
+	defer func() { err = helpers.RedactError(err) }()
+	audit := i.Audit(ctx, cluster, "upgrade", d)
+	defer func() { audit(err) }()
+
+	unlock, err := i.Lock(ctx, cluster, "upgrade", d.GetNamespace())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.Upgrade(ctx, cluster)
+}
+
+func (i *Installer) Backup(ctx context.Context, d Deployment, cluster Cluster, output string) (err error) {
+	defer func() { err = helpers.RedactError(err) }()
+	audit := i.Audit(ctx, cluster, "backup", d)
+	defer func() { audit(err) }()
+
+	unlock, err := i.Lock(ctx, cluster, "backup", d.GetNamespace())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.Backup(ctx, cluster, output)
 }
 
-func (i *Installer) Restore(d Deployment, cluster Cluster, output string) error {
-	return d.Restore(cluster, output)
+func (i *Installer) Restore(ctx context.Context, d Deployment, cluster Cluster, output string) (err error) {
+	defer func() { err = helpers.RedactError(err) }()
+	audit := i.Audit(ctx, cluster, "restore", d)
+	defer func() { audit(err) }()
+
+	unlock, err := i.Lock(ctx, cluster, "restore", d.GetNamespace())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.Restore(ctx, cluster, output)
+}
+
+func (i *Installer) Rollback(ctx context.Context, d Deployment, cluster Cluster, revision int) (err error) {
+	defer func() { err = helpers.RedactError(err) }()
+	audit := i.Audit(ctx, cluster, "rollback", d)
+	defer func() { audit(err) }()
+
+	unlock, err := i.Lock(ctx, cluster, "rollback", d.GetNamespace())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.Rollback(ctx, cluster, revision)
 }
@@ -0,0 +1,93 @@
+package kubernetes_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// phasedDeployment is installed in phases, the one named in fail failing
+type phasedDeployment struct {
+	version, domain, fail string
+	ran                   []string
+}
+
+func (d *phasedDeployment) Deploy(context.Context, Cluster) error          { return nil }
+func (d *phasedDeployment) Upgrade(context.Context, Cluster) error         { return nil }
+func (d *phasedDeployment) SetDomain(domain string)                        { d.domain = domain }
+func (d *phasedDeployment) GetDomain() string                              { return d.domain }
+func (d *phasedDeployment) GetNamespace() string                           { return "phased" }
+func (d *phasedDeployment) Delete(context.Context, Cluster) error          { return nil }
+func (d *phasedDeployment) Describe() string                               { return "phased" }
+func (d *phasedDeployment) GetVersion() string                             { return d.version }
+func (d *phasedDeployment) Restore(context.Context, Cluster, string) error { return nil }
+func (d *phasedDeployment) Backup(context.Context, Cluster, string) error  { return nil }
+func (d *phasedDeployment) Rollback(context.Context, Cluster, int) error   { return nil }
+func (d *phasedDeployment) Component() string                              { return "phased" }
+
+func (d *phasedDeployment) Phases(Cluster) []Phase {
+	var phases []Phase
+	for _, name := range []string{"operator", "database", "api"} {
+		name := name
+		phases = append(phases, Phase{Name: name, Run: func(context.Context) error {
+			d.ran = append(d.ran, name)
+			if name == d.fail {
+				return errors.New(name + " failed")
+			}
+			return nil
+		}})
+	}
+	return phases
+}
+
+var _ = Describe("Installer", func() {
+	ctx := context.Background()
+	var cluster *Cluster
+
+	BeforeEach(func() {
+		cluster, _ = newCluster()
+	})
+
+	It("removes the checkpoint of the installs completed", func() {
+		d := &phasedDeployment{version: "1.0", domain: "example.com"}
+
+		Expect(NewInstaller().Install(ctx, d, *cluster)).To(Succeed())
+		Expect(d.ran).To(Equal([]string{"operator", "database", "api"}))
+		Expect(cluster.GetInstallCheckpoint(ctx, "phased")).To(BeNil())
+	})
+
+	It("resumes the interrupted installs from their first incomplete phase", func() {
+		d := &phasedDeployment{version: "1.0", domain: "example.com", fail: "database"}
+		Expect(NewInstaller().Install(ctx, d, *cluster)).To(MatchError(ContainSubstring("install failed in phase database")))
+
+		cp, err := cluster.GetInstallCheckpoint(ctx, "phased")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*cp).To(Equal(InstallCheckpoint{Component: "phased", Version: "1.0", Domain: "example.com", Completed: []string{"operator"}}))
+
+		d = &phasedDeployment{version: "1.0"}
+		err = NewInstaller().Install(ctx, d, *cluster)
+		Expect(err).To(MatchError(ContainSubstring("run install again with --resume")))
+		Expect(ErrorClass(err)).To(Equal(ClassPreflight))
+		Expect(d.ran).To(BeEmpty())
+
+		inst := NewInstaller()
+		inst.Resume = true
+		Expect(inst.Install(ctx, d, *cluster)).To(Succeed())
+		Expect(d.ran).To(Equal([]string{"database", "api"}))
+		Expect(d.domain).To(Equal("example.com"))
+		Expect(cluster.GetInstallCheckpoint(ctx, "phased")).To(BeNil())
+	})
+
+	It("doesn't resume an install of another version", func() {
+		Expect(cluster.SaveInstallCheckpoint(ctx, InstallCheckpoint{Component: "phased", Version: "1.0", Completed: []string{"operator"}})).To(Succeed())
+
+		inst := NewInstaller()
+		inst.Resume = true
+		d := &phasedDeployment{version: "2.0", domain: "example.com"}
+		Expect(inst.Install(ctx, d, *cluster)).To(MatchError(ContainSubstring("the interrupted install is of version 1.0, not 2.0")))
+		Expect(d.ran).To(BeEmpty())
+	})
+})
@@ -0,0 +1,22 @@
+package kubernetes_test
+
+import (
+	"testing"
+
+	"github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetes(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kubernetes Suite")
+}
+
+// newCluster returns a cluster backed by a fake client holding objects
+func newCluster(objects ...runtime.Object) (*kubernetes.Cluster, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	return kubernetes.NewClusterWithClients(client, nil, nil), client
+}
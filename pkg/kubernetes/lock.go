@@ -0,0 +1,192 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+)
+
+const (
+	LockLabel = "kubecfctl.io/lock"
+
+	// lockDuration is how long a lock is reported as held without being
+	// renewed. Locks don't expire, the renewals only tell whether their
+	// holder is still running
+	lockDuration = 60
+	lockRenew    = 20 * time.Second
+)
+
+// LockHolder is who holds the lock of a namespace
+type LockHolder struct {
+	User, Host, Command string
+	Started, Renewed    time.Time
+}
+
+// NewLockHolder returns a holder for command run by the current user on
+// this host
+func NewLockHolder(command string) LockHolder {
+	h := LockHolder{Command: command, Started: time.Now()}
+	if u, err := user.Current(); err == nil {
+		h.User = u.Username
+	}
+	h.Host, _ = os.Hostname()
+	return h
+}
+
+// Identity returns the holder as user@host
+func (h LockHolder) Identity() string {
+	return h.User + "@" + h.Host
+}
+
+// LockedError is returned when a namespace is locked by another run
+type LockedError struct {
+	Namespace string
+	Holder    LockHolder
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("namespace %s is locked by %s running %q since %s (last renewed %s ago): wait for it to finish, or use --force-unlock if it is not running anymore",
+		e.Namespace, e.Holder.Identity(), e.Holder.Command, e.Holder.Started.Format(time.RFC3339),
+		time.Since(e.Holder.Renewed).Round(time.Second))
+}
+
+// Class is ClassPreflight, the operation didn't start
+func (LockedError) Class() string { return ClassPreflight }
+
+func lockName(namespace string) string {
+	return "kubecfctl-lock-" + namespace
+}
+
+func leaseHolder(l *coordinationv1.Lease) LockHolder {
+	h := LockHolder{
+		User:    l.Annotations["kubecfctl.io/user"],
+		Host:    l.Annotations["kubecfctl.io/host"],
+		Command: l.Annotations["kubecfctl.io/command"],
+	}
+	if l.Spec.AcquireTime != nil {
+		h.Started = l.Spec.AcquireTime.Time
+	}
+	if l.Spec.RenewTime != nil {
+		h.Renewed = l.Spec.RenewTime.Time
+	}
+	return h
+}
+
+// GetLock returns the holder of the lock of namespace, or nil if it isn't
+// locked
+func (c *Cluster) GetLock(ctx context.Context, namespace string) (*LockHolder, error) {
+	l, err := c.Kubectl.CoordinationV1().Leases(CheckpointNamespace).Get(ctx, lockName(namespace), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h := leaseHolder(l)
+	return &h, nil
+}
+
+// Lock takes the lock of namespace for holder, and renews it until the
+// returned unlock function is called. If the namespace is locked already,
+// a LockedError is returned
+func (c *Cluster) Lock(ctx context.Context, namespace string, holder LockHolder) (func(), error) {
+	if err := c.ensureStateNamespace(ctx); err != nil {
+		return nil, err
+	}
+
+	identity := holder.Identity()
+	duration := int32(lockDuration)
+	now := metav1.NewMicroTime(holder.Started)
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: lockName(namespace),
+			Labels: map[string]string{
+				ManagedByLabel: "kubecfctl",
+				LockLabel:      namespace,
+			},
+			Annotations: map[string]string{
+				"kubecfctl.io/user":    holder.User,
+				"kubecfctl.io/host":    holder.Host,
+				"kubecfctl.io/command": holder.Command,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &identity,
+			LeaseDurationSeconds: &duration,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+	leases := c.Kubectl.CoordinationV1().Leases(CheckpointNamespace)
+	created, err := leases.Create(ctx, lease, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		held, err := leases.Get(ctx, lease.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "while reading the lock of "+namespace)
+		}
+		return nil, LockedError{Namespace: namespace, Holder: leaseHolder(held)}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while locking "+namespace)
+	}
+
+	done := make(chan struct{})
+	go c.renewLock(created.Name, created.UID, done)
+
+	return func() {
+		close(done)
+		// The lock is released even if the operation was interrupted, as
+		// long as it is still the one taken here
+		uid := created.UID
+		err := leases.Delete(context.Background(), created.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			helpers.Warning("", ":warning: Failed releasing the lock of "+namespace+": "+err.Error())
+		}
+	}, nil
+}
+
+// renewLock updates the renew time of the lease name until done is closed
+// or the lease is replaced
+func (c *Cluster) renewLock(name string, uid types.UID, done chan struct{}) {
+	leases := c.Kubectl.CoordinationV1().Leases(CheckpointNamespace)
+	ticker := time.NewTicker(lockRenew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		l, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil || l.UID != uid {
+			continue
+		}
+		now := metav1.NewMicroTime(time.Now())
+		l.Spec.RenewTime = &now
+		leases.Update(context.Background(), l, metav1.UpdateOptions{})
+	}
+}
+
+// ForceUnlock removes the lock of namespace, whoever holds it
+func (c *Cluster) ForceUnlock(ctx context.Context, namespace string) error {
+	holder, err := c.GetLock(ctx, namespace)
+	if err != nil || holder == nil {
+		return err
+	}
+	helpers.Info("", ":unlock: Removing the lock of "+namespace+" held by "+holder.Identity()+" running \""+holder.Command+"\"")
+	err = c.Kubectl.CoordinationV1().Leases(CheckpointNamespace).Delete(ctx, lockName(namespace), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package kubernetes_test
+
+import (
+	"context"
+
+	. "github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Locks", func() {
+	ctx := context.Background()
+	var cluster *Cluster
+
+	BeforeEach(func() {
+		cluster, _ = newCluster()
+	})
+
+	It("can't be taken twice", func() {
+		unlock, err := cluster.Lock(ctx, "kubecf", NewLockHolder("kubecfctl upgrade kubecf"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = cluster.Lock(ctx, "kubecf", NewLockHolder("kubecfctl delete kubecf"))
+		Expect(err).To(BeAssignableToTypeOf(LockedError{}))
+		Expect(err.(LockedError).Holder.Command).To(Equal("kubecfctl upgrade kubecf"))
+		Expect(ErrorClass(err)).To(Equal(ClassPreflight))
+
+		unlock()
+		Expect(cluster.GetLock(ctx, "kubecf")).To(BeNil())
+		unlock, err = cluster.Lock(ctx, "kubecf", NewLockHolder("kubecfctl delete kubecf"))
+		Expect(err).ToNot(HaveOccurred())
+		unlock()
+	})
+
+	It("are taken per namespace", func() {
+		unlock, err := cluster.Lock(ctx, "kubecf", NewLockHolder("kubecfctl upgrade kubecf"))
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock()
+
+		unlockScf, err := cluster.Lock(ctx, "scf", NewLockHolder("kubecfctl upgrade scf"))
+		Expect(err).ToNot(HaveOccurred())
+		unlockScf()
+	})
+
+	It("are removed by force-unlock, whoever holds them", func() {
+		_, err := cluster.Lock(ctx, "kubecf", NewLockHolder("kubecfctl upgrade kubecf"))
+		Expect(err).ToNot(HaveOccurred())
+
+		inst := NewInstaller()
+		_, err = inst.Lock(ctx, *cluster, "delete", "kubecf")
+		Expect(err).To(BeAssignableToTypeOf(LockedError{}))
+
+		inst.ForceUnlock = true
+		inst.Command = "kubecfctl delete kubecf"
+		unlock, err := inst.Lock(ctx, *cluster, "delete", "kubecf")
+		Expect(err).ToNot(HaveOccurred())
+		holder, err := cluster.GetLock(ctx, "kubecf")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(holder.Command).To(Equal("kubecfctl delete kubecf"))
+		unlock()
+	})
+
+	It("release the ones taken when a namespace is locked already", func() {
+		unlock, err := cluster.Lock(ctx, "tenant", NewLockHolder("kubecfctl backup kubecf"))
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock()
+
+		_, err = NewInstaller().Lock(ctx, *cluster, "upgrade", "kubecf", "tenant")
+		Expect(err).To(BeAssignableToTypeOf(LockedError{}))
+		Expect(cluster.GetLock(ctx, "kubecf")).To(BeNil())
+	})
+})
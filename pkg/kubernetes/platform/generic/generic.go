@@ -2,8 +2,8 @@ package generic
 
 import (
 	"context"
+	"fmt"
 
-	"github.com/kyokomi/emoji"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -13,16 +13,16 @@ type Generic struct {
 }
 
 func (k *Generic) Describe() string {
-	return emoji.Sprintf(":anchor:Detected kubernetes platform: %s\n:earth_americas:ExternalIPs: %s\n:curly_loop:InternalIPs: %s", k.String(), k.ExternalIPs(), k.InternalIPs)
+	return fmt.Sprintf(":anchor:Detected kubernetes platform: %s\n:earth_americas:ExternalIPs: %s\n:curly_loop:InternalIPs: %s", k.String(), k.ExternalIPs(), k.InternalIPs)
 }
 
 func (k *Generic) String() string { return "generic" }
 
-func (k *Generic) Detect(kube *kubernetes.Clientset) bool {
+func (k *Generic) Detect(kube kubernetes.Interface) bool {
 	return false
 }
 
-func (k *Generic) Load(kube *kubernetes.Clientset) error {
+func (k *Generic) Load(kube kubernetes.Interface) error {
 	nodes, err := kube.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		return err
@@ -2,9 +2,9 @@ package ibm
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
-	"github.com/kyokomi/emoji"
 	"github.com/mudler/kubecfctl/pkg/kubernetes/platform/generic"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -15,12 +15,12 @@ type ibm struct {
 }
 
 func (k *ibm) Describe() string {
-	return emoji.Sprintf(":anchor:Detected kubernetes platform: %s\n:earth_americas:ExternalIPs: %s\n:curly_loop:InternalIPs: %s", k.String(), k.ExternalIPs(), k.InternalIPs)
+	return fmt.Sprintf(":anchor:Detected kubernetes platform: %s\n:earth_americas:ExternalIPs: %s\n:curly_loop:InternalIPs: %s", k.String(), k.ExternalIPs(), k.InternalIPs)
 }
 
 func (k *ibm) String() string { return "ibm" }
 
-func (k *ibm) Detect(kube *kubernetes.Clientset) bool {
+func (k *ibm) Detect(kube kubernetes.Interface) bool {
 	nodes, err := kube.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		return false
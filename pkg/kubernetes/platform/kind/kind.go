@@ -2,11 +2,11 @@ package kind
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/mudler/kubecfctl/pkg/kubernetes/platform/generic"
 
-	"github.com/kyokomi/emoji"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -16,12 +16,12 @@ type kind struct {
 }
 
 func (k *kind) Describe() string {
-	return emoji.Sprintf(":anchor:Detected kubernetes platform: %s\n:earth_americas:ExternalIPs: %s\n:curly_loop:InternalIPs: %s", k.String(), k.ExternalIPs(), k.InternalIPs)
+	return fmt.Sprintf(":anchor:Detected kubernetes platform: %s\n:earth_americas:ExternalIPs: %s\n:curly_loop:InternalIPs: %s", k.String(), k.ExternalIPs(), k.InternalIPs)
 }
 
 func (k *kind) String() string { return "kind" }
 
-func (k *kind) Detect(kube *kubernetes.Clientset) bool {
+func (k *kind) Detect(kube kubernetes.Interface) bool {
 	nodes, err := kube.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return false
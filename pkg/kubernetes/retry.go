@@ -0,0 +1,43 @@
+package kubernetes
+
+import (
+	"context"
+
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/exec"
+)
+
+// IsTransientError tells whether an API call or an exec failing with err is
+// worth retrying. The API errors are transient if the server timed out, was
+// overloaded or failed internally, the others if they are network errors.
+// Commands which ran and exited with an error aren't retried
+func IsTransientError(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsServiceUnavailable(err), apierrors.IsInternalError(err), apierrors.IsUnexpectedServerError(err):
+		return true
+	case apierrors.ReasonForError(err) != metav1.StatusReasonUnknown:
+		return false
+	}
+	if _, ok := err.(exec.ExitError); ok {
+		return false
+	}
+	return helpers.IsTransient(err)
+}
+
+// retry runs the cluster call f, retrying it on transient errors with the
+// attempts and backoff of the default policy. Transient errors still there
+// after the last attempt are returned as ConnectionError
+func retry(ctx context.Context, what string, f func() error) error {
+	policy := helpers.DefaultRetry
+	policy.Retryable = IsTransientError
+	err := policy.Do(ctx, what, f)
+	if IsTransientError(err) && ctx.Err() == nil {
+		return ConnectionError{Err: err}
+	}
+	return err
+}
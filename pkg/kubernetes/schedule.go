@@ -0,0 +1,354 @@
+package kubernetes
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ManagedByLabel         = "app.kubernetes.io/managed-by"
+	ScheduleLabel          = "kubecfctl.io/schedule"
+	ScheduleComponentLabel = "kubecfctl.io/component"
+	ScheduleOutputKey      = "kubecfctl.io/output"
+
+	backupServiceAccount = "kubecfctl-backup"
+)
+
+// BackupSchedule describes a periodic backup of a deployment which runs
+// inside the cluster as a CronJob
+type BackupSchedule struct {
+	Name      string
+	Namespace string
+	Component string
+	Cron      string
+	Output    string
+	Image     string
+	// Secret is an optional secret exposed as environment to the backup
+	// job, e.g. holding the object storage credentials
+	Secret string
+	Args   []string
+
+	LastSchedule *time.Time
+	LastResult   string
+}
+
+// CreateBackupSchedule creates the CronJob for the schedule, along with the
+// ServiceAccount and the RBAC rules needed by kubecfctl to run in the cluster
+func (c *Cluster) CreateBackupSchedule(ctx context.Context, s BackupSchedule) error {
+	if err := c.prepareBackupRBAC(ctx, s.Namespace); err != nil {
+		return errors.Wrap(err, "while creating backup service account")
+	}
+
+	labels := map[string]string{
+		ManagedByLabel:         "kubecfctl",
+		ScheduleLabel:          s.Name,
+		ScheduleComponentLabel: s.Component,
+	}
+
+	container := v1.Container{
+		Name:    "backup",
+		Image:   s.Image,
+		Command: append([]string{"kubecfctl"}, s.Args...),
+		Env: []v1.EnvVar{
+			{Name: "KUBECFCTL_SCHEDULE", Value: s.Name},
+		},
+		WorkingDir: "/backup",
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "backup", MountPath: "/backup"},
+		},
+	}
+	if s.Secret != "" {
+		container.EnvFrom = []v1.EnvFromSource{
+			{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: s.Secret}}},
+		}
+	}
+
+	var backoff int32
+	var history int32 = 3
+	cronJob := &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        s.Name,
+			Labels:      labels,
+			Annotations: map[string]string{ScheduleOutputKey: s.Output},
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:                   s.Cron,
+			ConcurrencyPolicy:          batchv1beta1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: &history,
+			FailedJobsHistoryLimit:     &history,
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: batchv1.JobSpec{
+					BackoffLimit: &backoff,
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: v1.PodSpec{
+							ServiceAccountName: backupServiceAccount,
+							RestartPolicy:      v1.RestartPolicyNever,
+							Containers:         []v1.Container{container},
+							Volumes: []v1.Volume{
+								{Name: "backup", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, err := c.Kubectl.BatchV1beta1().CronJobs(s.Namespace).Create(ctx, cronJob, metav1.CreateOptions{})
+	return err
+}
+
+// backupClusterRules are what the backup jobs need in the namespaces of the
+// components, which aren't known when the schedule is created (e.g. the
+// tenants of KubeCF are detected at each run): reading the resources backed
+// up and running the dumps in the pods
+var backupClusterRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"nodes", "namespaces", "pods", "secrets"},
+		Verbs:     []string{"get", "list"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods/exec"},
+		Verbs:     []string{"create"},
+	},
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{"statefulsets"},
+		Verbs:     []string{"get", "list"},
+	},
+}
+
+// backupStateRules are what the backup jobs need in the namespace holding
+// the state of kubecfctl: taking the locks, and recording the audit entries
+var backupStateRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "list", "create", "update", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+		Verbs:     []string{"get", "list", "create", "update", "delete"},
+	},
+}
+
+func (c *Cluster) prepareBackupRBAC(ctx context.Context, namespace string) error {
+	for _, ns := range []string{namespace, CheckpointNamespace} {
+		_, err := c.Kubectl.CoreV1().Namespaces().Create(ctx,
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}},
+			metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	_, err := c.Kubectl.CoreV1().ServiceAccounts(namespace).Create(ctx,
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: backupServiceAccount}},
+		metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	labels := map[string]string{ManagedByLabel: "kubecfctl"}
+	subjects := []rbacv1.Subject{
+		{Kind: "ServiceAccount", Name: backupServiceAccount, Namespace: namespace},
+	}
+
+	clusterRoles := c.Kubectl.RbacV1().ClusterRoles()
+	role := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: backupServiceAccount, Labels: labels},
+		Rules:      backupClusterRules,
+	}
+	_, err = clusterRoles.Create(ctx, role, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Update the role of the schedules created by older versions, which
+		// may lack some rules or grant too much
+		var existing *rbacv1.ClusterRole
+		existing, err = clusterRoles.Get(ctx, backupServiceAccount, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Rules = role.Rules
+		_, err = clusterRoles.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Kubectl.RbacV1().ClusterRoleBindings().Create(ctx,
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: backupServiceAccount + "-" + namespace, Labels: labels},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     backupServiceAccount,
+			},
+			Subjects: subjects,
+		},
+		metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	roles := c.Kubectl.RbacV1().Roles(CheckpointNamespace)
+	stateRole := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: backupServiceAccount, Labels: labels},
+		Rules:      backupStateRules,
+	}
+	_, err = roles.Create(ctx, stateRole, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var existing *rbacv1.Role
+		existing, err = roles.Get(ctx, backupServiceAccount, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Rules = stateRole.Rules
+		_, err = roles.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Kubectl.RbacV1().RoleBindings(CheckpointNamespace).Create(ctx,
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: backupServiceAccount + "-" + namespace, Labels: labels},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     backupServiceAccount,
+			},
+			Subjects: subjects,
+		},
+		metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// ListBackupSchedules returns the backup schedules in namespace, along with
+// the result of their most recent run
+func (c *Cluster) ListBackupSchedules(ctx context.Context, namespace string) ([]BackupSchedule, error) {
+	cronJobs, err := c.Kubectl.BatchV1beta1().CronJobs(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: ManagedByLabel + "=kubecfctl," + ScheduleLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []BackupSchedule
+	for _, cj := range cronJobs.Items {
+		s := BackupSchedule{
+			Name:       cj.Name,
+			Namespace:  cj.Namespace,
+			Component:  cj.Labels[ScheduleComponentLabel],
+			Cron:       cj.Spec.Schedule,
+			Output:     cj.Annotations[ScheduleOutputKey],
+			LastResult: "never run",
+		}
+		if len(cj.Spec.JobTemplate.Spec.Template.Spec.Containers) > 0 {
+			s.Image = cj.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Image
+		}
+		if cj.Status.LastScheduleTime != nil {
+			t := cj.Status.LastScheduleTime.Time
+			s.LastSchedule = &t
+		}
+
+		jobs, err := c.Kubectl.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: ScheduleLabel + "=" + cj.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs.Items) > 0 {
+			sort.Slice(jobs.Items, func(i, j int) bool {
+				return jobs.Items[i].CreationTimestamp.After(jobs.Items[j].CreationTimestamp.Time)
+			})
+			s.LastResult = jobResult(jobs.Items[0])
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func jobResult(j batchv1.Job) string {
+	switch {
+	case j.Status.Succeeded > 0:
+		return "succeeded"
+	case j.Status.Failed > 0:
+		return "failed"
+	case j.Status.Active > 0:
+		return "running"
+	}
+	return "pending"
+}
+
+// renamedBackupArgs maps the arguments of the backups run by the schedules
+// created by older versions of kubecfctl to the current ones
+var renamedBackupArgs = map[string]string{
+	"--output": "--location",
+}
+
+// UpdateBackupSchedules rewrites the backup commands of the schedules in
+// namespace created by older versions of kubecfctl, and returns the names of
+// the ones updated
+func (c *Cluster) UpdateBackupSchedules(ctx context.Context, namespace string) ([]string, error) {
+	cronJobs, err := c.Kubectl.BatchV1beta1().CronJobs(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: ManagedByLabel + "=kubecfctl," + ScheduleLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []string
+	for i := range cronJobs.Items {
+		cj := &cronJobs.Items[i]
+		changed := false
+		containers := cj.Spec.JobTemplate.Spec.Template.Spec.Containers
+		for j := range containers {
+			for k, arg := range containers[j].Command {
+				if renamed, ok := renamedBackupArgs[arg]; ok {
+					containers[j].Command[k] = renamed
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		if _, err := c.Kubectl.BatchV1beta1().CronJobs(namespace).Update(ctx, cj, metav1.UpdateOptions{}); err != nil {
+			return updated, errors.Wrap(err, "while updating schedule "+cj.Name)
+		}
+		updated = append(updated, cj.Name)
+	}
+	return updated, nil
+}
+
+// DeleteBackupSchedule deletes the schedule CronJob and the jobs it created
+func (c *Cluster) DeleteBackupSchedule(ctx context.Context, namespace, name string) error {
+	policy := metav1.DeletePropagationBackground
+	err := c.Kubectl.BatchV1beta1().CronJobs(namespace).Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &policy,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Kubectl.BatchV1().Jobs(namespace).DeleteCollection(ctx,
+		metav1.DeleteOptions{PropagationPolicy: &policy},
+		metav1.ListOptions{LabelSelector: ScheduleLabel + "=" + name})
+}
@@ -0,0 +1,153 @@
+package kubernetes_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/mudler/kubecfctl/pkg/kubernetes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Backup schedules", func() {
+	ctx := context.Background()
+
+	schedule := BackupSchedule{
+		Name:      "kubecf-backup",
+		Namespace: "kubecfctl",
+		Component: "kubecf",
+		Cron:      "0 2 * * *",
+		Image:     "kubecfctl",
+		Args:      []string{"backup", "kubecf", "--location", "s3://bucket/kubecf"},
+	}
+
+	It("runs kubecfctl in a CronJob, and lists the result of its last run", func() {
+		cluster, client := newCluster()
+		s := schedule
+		s.Secret = "s3-credentials"
+		Expect(cluster.CreateBackupSchedule(ctx, s)).To(Succeed())
+
+		cj, err := client.BatchV1beta1().CronJobs("kubecfctl").Get(ctx, "kubecf-backup", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cj.Spec.Schedule).To(Equal("0 2 * * *"))
+		Expect(containers(cj)[0].Command).To(Equal([]string{"kubecfctl", "backup", "kubecf", "--location", "s3://bucket/kubecf"}))
+		Expect(containers(cj)[0].EnvFrom[0].SecretRef.Name).To(Equal("s3-credentials"))
+		Expect(cj.Spec.JobTemplate.Spec.Template.Spec.ServiceAccountName).To(Equal("kubecfctl-backup"))
+
+		schedules, err := cluster.ListBackupSchedules(ctx, "kubecfctl")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(schedules).To(HaveLen(1))
+		Expect(schedules[0].Component).To(Equal("kubecf"))
+		Expect(schedules[0].LastResult).To(Equal("never run"))
+
+		for i, status := range []batchv1.JobStatus{{Succeeded: 1}, {Failed: 1}} {
+			_, err := client.BatchV1().Jobs("kubecfctl").Create(ctx, &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              fmt.Sprintf("kubecf-backup-%d", i),
+					Labels:            map[string]string{ScheduleLabel: "kubecf-backup"},
+					CreationTimestamp: metav1.NewTime(time.Date(2020, 1, i+1, 2, 0, 0, 0, time.UTC)),
+				},
+				Status: status,
+			}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		schedules, err = cluster.ListBackupSchedules(ctx, "kubecfctl")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(schedules[0].LastResult).To(Equal("failed"))
+
+		Expect(cluster.DeleteBackupSchedule(ctx, "kubecfctl", "kubecf-backup")).To(Succeed())
+		Expect(cluster.ListBackupSchedules(ctx, "kubecfctl")).To(BeEmpty())
+	})
+
+	leases := rbacv1.PolicyRule{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "list", "create", "update", "delete"},
+	}
+
+	// writes returns the rules changing something else than pods/exec
+	writes := func(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+		var result []rbacv1.PolicyRule
+		for _, r := range rules {
+			for _, v := range r.Verbs {
+				if v != "get" && v != "list" && v != "watch" && !(v == "create" && r.Resources[0] == "pods/exec") {
+					result = append(result, r)
+					break
+				}
+			}
+		}
+		return result
+	}
+
+	It("lets the backup jobs take the locks in the namespace of kubecfctl only", func() {
+		cluster, client := newCluster()
+		Expect(cluster.CreateBackupSchedule(ctx, schedule)).To(Succeed())
+
+		role, err := client.RbacV1().Roles(CheckpointNamespace).Get(ctx, "kubecfctl-backup", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(role.Rules).To(ContainElement(leases))
+		binding, err := client.RbacV1().RoleBindings(CheckpointNamespace).Get(ctx, "kubecfctl-backup-kubecfctl", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(binding.RoleRef.Kind).To(Equal("Role"))
+		Expect(binding.Subjects[0].Namespace).To(Equal("kubecfctl"))
+
+		clusterRole, err := client.RbacV1().ClusterRoles().Get(ctx, "kubecfctl-backup", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writes(clusterRole.Rules)).To(BeEmpty())
+		Expect(clusterRole.Rules).To(ContainElement(rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"pods/exec"},
+			Verbs:     []string{"create"},
+		}))
+	})
+
+	It("narrows the role of the schedules created before", func() {
+		cluster, client := newCluster(&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubecfctl-backup"},
+			Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"secrets", "namespaces"}, Verbs: []string{"get", "list", "create", "delete"}},
+				leases,
+			},
+		})
+		Expect(cluster.CreateBackupSchedule(ctx, schedule)).To(Succeed())
+
+		role, err := client.RbacV1().ClusterRoles().Get(ctx, "kubecfctl-backup", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writes(role.Rules)).To(BeEmpty())
+		Expect(role.Rules).ToNot(ContainElement(leases))
+	})
+
+	It("rewrites the flags renamed since the schedules were created", func() {
+		cluster, client := newCluster()
+		Expect(cluster.CreateBackupSchedule(ctx, BackupSchedule{
+			Name:      "kubecf-backup",
+			Namespace: "kubecfctl",
+			Component: "kubecf",
+			Cron:      "0 2 * * *",
+			Image:     "kubecfctl",
+			Args:      []string{"backup", "kubecf", "--output", "s3://bucket/kubecf", "--timestamp"},
+		})).To(Succeed())
+
+		updated, err := cluster.UpdateBackupSchedules(ctx, "kubecfctl")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated).To(Equal([]string{"kubecf-backup"}))
+
+		cj, err := client.BatchV1beta1().CronJobs("kubecfctl").Get(ctx, "kubecf-backup", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(containers(cj)[0].Command).To(Equal([]string{"kubecfctl", "backup", "kubecf", "--location", "s3://bucket/kubecf", "--timestamp"}))
+
+		updated, err = cluster.UpdateBackupSchedules(ctx, "kubecfctl")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated).To(BeEmpty())
+	})
+})
+
+func containers(cj *batchv1beta1.CronJob) []v1.Container {
+	return cj.Spec.JobTemplate.Spec.Template.Spec.Containers
+}
@@ -0,0 +1,262 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/kubecfctl/pkg/helpers"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Kinds of the resources removed along with a component
+const (
+	KindPodSecurityPolicy              = "PodSecurityPolicy"
+	KindClusterRole                    = "ClusterRole"
+	KindClusterRoleBinding             = "ClusterRoleBinding"
+	KindCustomResourceDefinition       = "CustomResourceDefinition"
+	KindMutatingWebhookConfiguration   = "MutatingWebhookConfiguration"
+	KindValidatingWebhookConfiguration = "ValidatingWebhookConfiguration"
+	KindPersistentVolume               = "PersistentVolume"
+	KindServiceAccount                 = "ServiceAccount"
+	KindRoleBinding                    = "RoleBinding"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// Resource is a resource of a component, Namespace is empty for the
+// cluster wide ones
+type Resource struct {
+	Kind, Namespace, Name string
+}
+
+func (r Resource) String() string {
+	if r.Namespace == "" {
+		return r.Kind + "/" + r.Name
+	}
+	return r.Kind + "/" + r.Name + " in " + r.Namespace
+}
+
+// Owned selects the cluster wide resources belonging to a component: the
+// ones installed by helm releases in Namespaces, the ones named one of Names,
+// or starting with one of Prefixes, or ending with one of Suffixes, and the
+// volumes released by claims in Namespaces. The resources created by
+// kubecfctl itself, like the roles of the backup schedules, never belong to a
+// component
+type Owned struct {
+	Namespaces, Names, Prefixes, Suffixes []string
+}
+
+func (o Owned) owns(meta metav1.ObjectMeta) bool {
+	if meta.Labels[ManagedByLabel] == "kubecfctl" {
+		return false
+	}
+	for _, ns := range o.Namespaces {
+		if ns != "" && meta.Annotations["meta.helm.sh/release-namespace"] == ns {
+			return true
+		}
+	}
+	for _, n := range o.Names {
+		if meta.Name == n {
+			return true
+		}
+	}
+	for _, p := range o.Prefixes {
+		if strings.HasPrefix(meta.Name, p) {
+			return true
+		}
+	}
+	for _, s := range o.Suffixes {
+		if strings.HasSuffix(meta.Name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Owned) ownsVolume(pv v1.PersistentVolume) bool {
+	if pv.Status.Phase != v1.VolumeReleased || pv.Spec.ClaimRef == nil {
+		return false
+	}
+	for _, ns := range o.Namespaces {
+		if ns != "" && pv.Spec.ClaimRef.Namespace == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteNamespaces deletes namespaces, skipping the ones already gone, and
+// waits until they are removed
+func (c *Cluster) DeleteNamespaces(ctx context.Context, t PhaseTimeout, namespaces ...string) error {
+	var result error
+	var deleting []string
+	for _, ns := range namespaces {
+		if ns == "" {
+			continue
+		}
+		err := c.Kubectl.CoreV1().Namespaces().Delete(ctx, ns, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "while deleting namespace "+ns))
+			continue
+		}
+		deleting = append(deleting, ns)
+	}
+	for _, ns := range deleting {
+		if err := c.WaitUntilNamespaceGone(ctx, ns, t); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "namespace "+ns+" is still terminating"))
+		}
+	}
+	return Partial(result)
+}
+
+// WaitUntilNamespaceGone waits until namespace is removed. It returns a
+// TimeoutError listing the pods left in it if t expires
+func (c *Cluster) WaitUntilNamespaceGone(ctx context.Context, namespace string, t PhaseTimeout) error {
+	s := helpers.Wait(t.Phase, fmt.Sprintf("Waiting for namespace %s to be deleted ... :zzz:", namespace))
+	defer s.Done()
+	err := poll(ctx, t.Timeout, func() (bool, error) {
+		_, err := c.Kubectl.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+	timeoutErr := &TimeoutError{PhaseTimeout: t, What: "namespace " + namespace + " to be deleted"}
+	if podList, err := c.ListPods(ctx, namespace, ""); err == nil {
+		for _, pod := range podList.Items {
+			timeoutErr.Remaining = append(timeoutErr.Remaining, podState(pod))
+		}
+	}
+	return timeoutErr
+}
+
+// FindResources returns the pod security policies, cluster roles and
+// bindings, CRDs and webhooks selected by match
+func (c *Cluster) FindResources(ctx context.Context, match func(kind string, meta metav1.ObjectMeta) bool) ([]Resource, error) {
+	var found []Resource
+	add := func(kind string, meta metav1.ObjectMeta) {
+		if match(kind, meta) {
+			found = append(found, Resource{Kind: kind, Name: meta.Name})
+		}
+	}
+
+	psps, err := c.Kubectl.PolicyV1beta1().PodSecurityPolicies().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing pod security policies")
+	}
+	for _, r := range psps.Items {
+		add(KindPodSecurityPolicy, r.ObjectMeta)
+	}
+
+	roles, err := c.Kubectl.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing cluster roles")
+	}
+	for _, r := range roles.Items {
+		add(KindClusterRole, r.ObjectMeta)
+	}
+
+	bindings, err := c.Kubectl.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing cluster role bindings")
+	}
+	for _, r := range bindings.Items {
+		add(KindClusterRoleBinding, r.ObjectMeta)
+	}
+
+	crds, err := c.Dynamic.Resource(crdResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing custom resource definitions")
+	}
+	for _, r := range crds.Items {
+		add(KindCustomResourceDefinition, metav1.ObjectMeta{Name: r.GetName(), Labels: r.GetLabels(), Annotations: r.GetAnnotations()})
+	}
+
+	mutating, err := c.Kubectl.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing mutating webhooks")
+	}
+	for _, r := range mutating.Items {
+		add(KindMutatingWebhookConfiguration, r.ObjectMeta)
+	}
+
+	validating, err := c.Kubectl.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing validating webhooks")
+	}
+	for _, r := range validating.Items {
+		add(KindValidatingWebhookConfiguration, r.ObjectMeta)
+	}
+
+	return found, nil
+}
+
+// FindLeftovers returns the cluster wide resources selected by o
+func (c *Cluster) FindLeftovers(ctx context.Context, o Owned) ([]Resource, error) {
+	leftovers, err := c.FindResources(ctx, func(kind string, meta metav1.ObjectMeta) bool {
+		return o.owns(meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pvs, err := c.Kubectl.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing persistent volumes")
+	}
+	for _, pv := range pvs.Items {
+		if o.ownsVolume(pv) {
+			leftovers = append(leftovers, Resource{Kind: KindPersistentVolume, Name: pv.Name})
+		}
+	}
+
+	return leftovers, nil
+}
+
+// DeleteResources deletes resources, skipping the ones already gone
+func (c *Cluster) DeleteResources(ctx context.Context, resources []Resource) error {
+	var result error
+	for _, r := range resources {
+		var err error
+		opts := metav1.DeleteOptions{}
+		switch r.Kind {
+		case KindPodSecurityPolicy:
+			err = c.Kubectl.PolicyV1beta1().PodSecurityPolicies().Delete(ctx, r.Name, opts)
+		case KindClusterRole:
+			err = c.Kubectl.RbacV1().ClusterRoles().Delete(ctx, r.Name, opts)
+		case KindClusterRoleBinding:
+			err = c.Kubectl.RbacV1().ClusterRoleBindings().Delete(ctx, r.Name, opts)
+		case KindCustomResourceDefinition:
+			err = c.Dynamic.Resource(crdResource).Delete(ctx, r.Name, opts)
+		case KindMutatingWebhookConfiguration:
+			err = c.Kubectl.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(ctx, r.Name, opts)
+		case KindValidatingWebhookConfiguration:
+			err = c.Kubectl.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, r.Name, opts)
+		case KindPersistentVolume:
+			err = c.Kubectl.CoreV1().PersistentVolumes().Delete(ctx, r.Name, opts)
+		case KindServiceAccount:
+			err = c.Kubectl.CoreV1().ServiceAccounts(r.Namespace).Delete(ctx, r.Name, opts)
+		case KindRoleBinding:
+			err = c.Kubectl.RbacV1().RoleBindings(r.Namespace).Delete(ctx, r.Name, opts)
+		default:
+			err = errors.New("unknown kind " + r.Kind)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			result = multierror.Append(result, errors.Wrap(err, "while deleting "+r.String()))
+		}
+	}
+	return Partial(result)
+}
@@ -0,0 +1,60 @@
+package kubernetes
+
+import (
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is how long the waits last when no timeout is set
+const DefaultTimeout = 20 * time.Minute
+
+// Timeouts are how long the waits of each phase of an operation last at
+// most, e.g. the wait for the Quarks operator pods to be running. Phases
+// without a timeout of their own use Default
+type Timeouts struct {
+	Default time.Duration
+	Phases  map[string]time.Duration
+}
+
+// Phase returns the timeout of the waits of phase
+func (t Timeouts) Phase(phase string) PhaseTimeout {
+	timeout := t.Phases[phase]
+	if timeout <= 0 {
+		timeout = t.Default
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return PhaseTimeout{Phase: phase, Timeout: timeout}
+}
+
+// PhaseTimeout is how long a wait of a phase lasts at most
+type PhaseTimeout struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+// TimeoutError is returned by the waits which time out
+type TimeoutError struct {
+	PhaseTimeout
+	// What is what was waited for
+	What string
+	// NotReady are the pods which weren't ready when the wait timed out
+	NotReady []string
+	// Remaining are the pods which weren't gone when the wait timed out
+	Remaining []string
+}
+
+func (e *TimeoutError) Error() string {
+	msg := "phase " + e.Phase + " timed out after " + e.Timeout.String() + " waiting for " + e.What
+	if len(e.NotReady) != 0 {
+		msg += ", pods not ready: " + strings.Join(e.NotReady, ", ")
+	}
+	if len(e.Remaining) != 0 {
+		msg += ", pods remaining: " + strings.Join(e.Remaining, ", ")
+	}
+	return msg
+}
+
+// Class is ClassTimeout
+func (*TimeoutError) Class() string { return ClassTimeout }